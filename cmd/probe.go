@@ -8,6 +8,8 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 	"os"
 
 	"github.com/gardener/dependency-watchdog/pkg/scaler"
@@ -38,8 +40,29 @@ var probeCmd = &cobra.Command{
 	Run: runProbe,
 }
 
+const (
+	webhookPath = "/webhooks/validate-replicas"
+	// statusPath serves the status of the probers next to the metrics.
+	statusPath = "/status"
+)
+
+var (
+	webhookPort     int
+	webhookCertFile string
+	webhookKeyFile  string
+	webhookMode     string
+	readinessSource string
+	probeWorkers    int
+)
+
 func init() {
 	rootCmd.AddCommand(probeCmd)
+	probeCmd.Flags().IntVar(&webhookPort, "webhook-port", 0, "The port on which the webhook rejecting scale ups of targets held down by the dependency-watchdog is served. It is disabled if 0.")
+	probeCmd.Flags().StringVar(&webhookCertFile, "webhook-cert-file", "", "The path to the TLS certificate of the webhook.")
+	probeCmd.Flags().StringVar(&webhookKeyFile, "webhook-key-file", "", "The path to the TLS key of the webhook.")
+	probeCmd.Flags().IntVar(&probeWorkers, "probe-workers", scaler.DefaultProbeWorkers, "The number of workers running the probes of all namespaces concurrently.")
+	probeCmd.Flags().StringVar(&readinessSource, "readiness-source", "", "The source deciding which namespaces are ready to be probed. One of GardenerCluster, Namespace or AlwaysReady. Overrides the readiness source of the config file if set.")
+	probeCmd.Flags().StringVar(&webhookMode, "webhook-mode", string(scaler.WebhookModeReject), "What the webhook does with scale ups of targets held down by the dependency-watchdog. One of reject or warn.")
 }
 
 func runProbe(cmd *cobra.Command, args []string) {
@@ -53,6 +76,10 @@ func runProbe(cmd *cobra.Command, args []string) {
 	klog.V(2).Infoln("qps: ", qps)
 	klog.V(2).Infoln("burst: ", burst)
 	klog.V(2).Infoln("port: ", port)
+	klog.V(2).Infoln("probe-workers: ", probeWorkers)
+	klog.V(2).Infoln("readiness-source: ", readinessSource)
+	klog.V(2).Infoln("webhook-port: ", webhookPort)
+	klog.V(2).Infoln("webhook-mode: ", webhookMode)
 
 	// set up signals so we handle the first shutdown signal gracefully
 	stopCh := setupSignalHandler()
@@ -61,6 +88,16 @@ func runProbe(cmd *cobra.Command, args []string) {
 		klog.Fatalf("Error parsing config file: %s", err.Error())
 	}
 
+	if readinessSource != "" {
+		if deps.ReadinessSource == nil {
+			deps.ReadinessSource = &scalerapi.ReadinessSourceConfig{}
+		}
+		deps.ReadinessSource.Type = scalerapi.ReadinessSourceType(readinessSource)
+		if err := scaler.ValidateReadinessSource(deps.ReadinessSource); err != nil {
+			klog.Fatalf("Invalid readiness source: %s", err.Error())
+		}
+	}
+
 	configContent, err := scalerapi.Encode(deps)
 	klog.V(2).Infof("Probe configuration: \n %s", configContent)
 
@@ -87,23 +124,37 @@ func runProbe(cmd *cobra.Command, args []string) {
 		defaultSyncDuration,
 		opts...)
 
-	gardenerClientSet, err := gardenerclientset.NewForConfig(config)
-	if err != nil {
-		klog.Fatalf("Error creating k8s clientset: %s", err.Error())
+	var gardenerInformerFactory gardenerinformer.SharedInformerFactory
+	if scaler.ReadinessSourceTypeOf(deps) == scalerapi.ReadinessSourceGardenerCluster {
+		gardenerClientSet, err := gardenerclientset.NewForConfig(config)
+		if err != nil {
+			klog.Fatalf("Error creating k8s clientset: %s", err.Error())
+		}
+
+		gardenerInformerFactory = gardenerinformer.NewSharedInformerFactory(
+			gardenerClientSet,
+			defaultSyncDuration,
+		)
 	}
 
-	gardenerInformerFactory := gardenerinformer.NewSharedInformerFactory(
-		gardenerClientSet,
-		defaultSyncDuration,
-	)
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		klog.Fatalf("Error creating k8s dynamic client: %s", err.Error())
+	}
 
 	scaleKindResolver := scale.NewDiscoveryScaleKindResolver(clientset.Discovery()) // DiscoveryScaleKindResolver does the caching
 	scaleGetter := scale.New(clientset.RESTClient(), mapper, dynamic.LegacyAPIPathResolverFunc, scaleKindResolver)
-	controller := scaler.NewController(clientset, mapper, scaleGetter, factory, gardenerInformerFactory, deps, stopCh)
 	leaderElectionClient := kubernetes.NewForConfigOrDie(rest.AddUserAgent(config, "dependency-watchdog-election"))
 	recorder := createRecorder(leaderElectionClient)
+	controller := scaler.NewController(clientset, mapper, scaleGetter, factory, gardenerInformerFactory, dynamicClient, deps, recorder, stopCh)
+	controller.ProbeWorkers = probeWorkers
+	controller.EmergencyStop = newEmergencyStop(clientset, recorder)
 	run := func(ctx context.Context) {
-		go serveMetrics()
+		http.Handle(statusPath, controller.NewStatusHandler())
+		go serveMetrics(controller.EmergencyStop)
+		if webhookPort != 0 {
+			go serveWebhook(controller)
+		}
 		klog.Info("Starting endpoint controller.")
 		if err = controller.Run(concurrentSyncs); err != nil {
 			klog.Fatalf("Error running controller: %s", err.Error())
@@ -147,3 +198,22 @@ func runProbe(cmd *cobra.Command, args []string) {
 	})
 	panic("unreachable")
 }
+
+// serveWebhook serves the webhook rejecting scale ups of targets held down by the dependency-watchdog.
+// It is only served by the leader as only the leader knows about the targets held down.
+func serveWebhook(controller *scaler.Controller) {
+	handler, err := controller.NewScaleUpWebhook(scaler.WebhookMode(webhookMode))
+	if err != nil {
+		klog.Fatalf("Error creating webhook: %s", err.Error())
+	}
+	mux := http.NewServeMux()
+	mux.Handle(webhookPath, handler)
+	server := &http.Server{
+		Addr:    fmt.Sprintf("%s%d", ":", webhookPort),
+		Handler: mux,
+	}
+	klog.Infof("Serving webhook on port %d", webhookPort)
+	if err := server.ListenAndServeTLS(webhookCertFile, webhookKeyFile); err != nil {
+		klog.Fatalf("Error serving webhook: %s", err.Error())
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/gardener/dependency-watchdog/pkg/maintenance"
 	"github.com/gardener/dependency-watchdog/pkg/restarter"
 	restarterapi "github.com/gardener/dependency-watchdog/pkg/restarter/api"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -52,6 +53,7 @@ var (
 	qps                         float32
 	burst                       int
 	port                        int
+	emergencyStopConfigMap      string
 
 	onlyOneSignalHandler = make(chan struct{})
 	shutdownSignals      = []os.Signal{os.Interrupt, syscall.SIGTERM}
@@ -97,6 +99,7 @@ func init() {
 	rootCmd.PersistentFlags().Float32Var(&qps, "qps", rest.DefaultQPS, "Throttling QPS configuration for the client to host apiserver.")
 	rootCmd.PersistentFlags().IntVar(&burst, "burst", rest.DefaultBurst, "Throttling burst configuration for the client to host apiserver.")
 	rootCmd.PersistentFlags().IntVar(&port, "port", defaultPort, "The port on which health and prometheus metrics are exposed.")
+	rootCmd.PersistentFlags().StringVar(&emergencyStopConfigMap, "emergency-stop-configmap", "", "The name of the ConfigMap in the deployed namespace whose key \"suspended\" suspends all actions if it is true, e.g. "+maintenance.DefaultEmergencyStopConfigMapName+". It requires permissions to list and watch ConfigMaps in the deployed namespace. It is disabled if empty.")
 	rootCmd.Flags().StringVar(&strWatchDuration, "watch-duration", defaultWatchDuration, "The duration to watch dependencies after the service is ready.")
 
 	klog.InitFlags(nil)
@@ -116,6 +119,7 @@ func runRoot(cmd *cobra.Command, args []string) {
 	klog.V(2).Infoln("qps: ", qps)
 	klog.V(2).Infoln("burst: ", burst)
 	klog.V(2).Infoln("port: ", port)
+	klog.V(2).Infoln("emergency-stop-configmap: ", emergencyStopConfigMap)
 
 	watchDuration, err := time.ParseDuration(strWatchDuration)
 	if err != nil {
@@ -153,11 +157,12 @@ func runRoot(cmd *cobra.Command, args []string) {
 		clientset,
 		defaultSyncDuration,
 		opts...)
-	controller := restarter.NewController(clientset, factory, deps, watchDuration, stopCh)
 	leaderElectionClient := kubernetes.NewForConfigOrDie(rest.AddUserAgent(config, "dependency-watchdog-election"))
 	recorder := createRecorder(leaderElectionClient)
+	controller := restarter.NewController(clientset, factory, deps, watchDuration, recorder, stopCh)
+	controller.EmergencyStop = newEmergencyStop(clientset, recorder)
 	run := func(ctx context.Context) {
-		go serveMetrics()
+		go serveMetrics(controller.EmergencyStop)
 		klog.Info("Starting endpoint controller.")
 		if err = controller.Run(concurrentSyncs); err != nil {
 			klog.Fatalf("Error running controller: %s", err.Error())
@@ -228,7 +233,17 @@ func setupSignalHandler() (stopCh <-chan struct{}) {
 	return stop
 }
 
-func serveMetrics() error {
+// newEmergencyStop returns the emergency stop switch unless it is disabled.
+func newEmergencyStop(clientset kubernetes.Interface, recorder record.EventRecorder) *maintenance.EmergencyStop {
+	if emergencyStopConfigMap == "" {
+		return nil
+	}
+	return maintenance.NewEmergencyStop(clientset, deployedNamespace, emergencyStopConfigMap, defaultSyncDuration, recorder)
+}
+
+// serveMetrics serves the prometheus metrics and a readiness check failing while all actions are suspended.
+func serveMetrics(emergencyStop *maintenance.EmergencyStop) error {
 	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/readyz", emergencyStop)
 	return http.ListenAndServe(fmt.Sprintf("%s%d", ":", port), nil)
 }
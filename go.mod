@@ -4,6 +4,7 @@ go 1.17
 
 require (
 	github.com/gardener/gardener v1.6.5
+	github.com/gardener/hvpa-controller v0.0.0-20191014062307-fad3bdf06a25
 	github.com/ghodss/yaml v1.0.0
 	github.com/onsi/ginkgo v1.12.2
 	github.com/onsi/gomega v1.10.1
@@ -34,7 +35,6 @@ require (
 	github.com/gardener/etcd-druid v0.3.0 // indirect
 	github.com/gardener/external-dns-management v0.7.7 // indirect
 	github.com/gardener/gardener-resource-manager v0.10.0 // indirect
-	github.com/gardener/hvpa-controller v0.0.0-20191014062307-fad3bdf06a25 // indirect
 	github.com/go-logr/logr v0.1.0 // indirect
 	github.com/gobwas/glob v0.2.3 // indirect
 	github.com/gogo/protobuf v1.3.1 // indirect
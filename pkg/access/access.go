@@ -0,0 +1,27 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package access checks the permissions the dependency-watchdog needs at start-up.
+package access
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CheckNamespaces checks that the client may list and watch namespaces. The namespace informers retry forbidden
+// requests forever, so their caches would never sync without these permissions.
+func CheckNamespaces(client kubernetes.Interface) error {
+	if _, err := client.CoreV1().Namespaces().List(metav1.ListOptions{Limit: 1}); err != nil {
+		return fmt.Errorf("cannot list namespaces, the dependency-watchdog needs permissions to list and watch them: %v", err)
+	}
+	w, err := client.CoreV1().Namespaces().Watch(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("cannot watch namespaces, the dependency-watchdog needs permissions to list and watch them: %v", err)
+	}
+	w.Stop()
+	return nil
+}
@@ -0,0 +1,37 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package access
+
+import (
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCheckNamespaces(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	if err := CheckNamespaces(client); err != nil {
+		t.Errorf("expected access to namespaces but got %v", err)
+	}
+
+	client.PrependWatchReactor("namespaces", func(k8stesting.Action) (bool, watch.Interface, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "namespaces"}, "", nil)
+	})
+	if err := CheckNamespaces(client); err == nil {
+		t.Error("expected an error without permissions to watch namespaces")
+	}
+
+	client.PrependReactor("list", "namespaces", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "namespaces"}, "", nil)
+	})
+	if err := CheckNamespaces(client); err == nil {
+		t.Error("expected an error without permissions to list namespaces")
+	}
+}
@@ -0,0 +1,145 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package maintenance
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultEmergencyStopConfigMapName is the conventional name of the ConfigMap holding the emergency stop switch.
+	DefaultEmergencyStopConfigMapName = "dependency-watchdog-emergency-stop"
+	// EmergencyStopKey is the key of the emergency stop switch in the ConfigMap. All mutating actions are
+	// suspended while it is "true".
+	EmergencyStopKey = "suspended"
+
+	// ReasonActionsSuspended is the reason of the event recorded when the emergency stop is activated.
+	ReasonActionsSuspended = "ActionsSuspended"
+	// ReasonActionsResumed is the reason of the event recorded when the emergency stop is released.
+	ReasonActionsResumed = "ActionsResumed"
+)
+
+var dwdActionsSuspended = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: "dwd",
+		Name:      "actions_suspended",
+		Help:      "Indicates if all mutating actions of the dependency-watchdog are suspended by the emergency stop switch.",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(dwdActionsSuspended)
+}
+
+// EmergencyStop is a seed-wide switch suspending all mutating actions of the dependency-watchdog, i.e. scale
+// updates and pod deletions, without restarting it. It watches the key EmergencyStopKey of a single ConfigMap.
+// A nil EmergencyStop never suspends any action.
+type EmergencyStop struct {
+	informer  cache.SharedIndexInformer
+	recorder  record.EventRecorder
+	suspended int32
+}
+
+// NewEmergencyStop creates an EmergencyStop watching the ConfigMap with the given name in the given namespace.
+// It needs permissions to list and watch ConfigMaps in the namespace.
+func NewEmergencyStop(client kubernetes.Interface, namespace, name string, resyncPeriod time.Duration, recorder record.EventRecorder) *EmergencyStop {
+	e := &EmergencyStop{
+		informer: coreinformers.NewFilteredConfigMapInformer(client, namespace, resyncPeriod, cache.Indexers{}, func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}),
+		recorder: recorder,
+	}
+	e.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			e.update(obj.(*corev1.ConfigMap), false)
+		},
+		UpdateFunc: func(_, new interface{}) {
+			e.update(new.(*corev1.ConfigMap), false)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if cm, ok := obj.(*corev1.ConfigMap); ok {
+				e.update(cm, true)
+			}
+		},
+	})
+	return e
+}
+
+// Start starts watching the ConfigMap until stopCh is closed.
+func (e *EmergencyStop) Start(stopCh <-chan struct{}) {
+	if e == nil {
+		return
+	}
+	go e.informer.Run(stopCh)
+}
+
+// HasSynced checks if the state of the ConfigMap is known.
+func (e *EmergencyStop) HasSynced() bool {
+	return e == nil || e.informer.HasSynced()
+}
+
+// Suspended checks if all mutating actions are suspended.
+func (e *EmergencyStop) Suspended() bool {
+	return e != nil && atomic.LoadInt32(&e.suspended) == 1
+}
+
+// ServeHTTP reports if actions are suspended. It can be used as readiness check which fails while they are.
+func (e *EmergencyStop) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	if e.Suspended() {
+		http.Error(rw, "actions suspended", http.StatusServiceUnavailable)
+		return
+	}
+	if _, err := rw.Write([]byte("ok")); err != nil {
+		klog.Errorf("Failed to write the readiness response: %s", err)
+	}
+}
+
+func (e *EmergencyStop) update(cm *corev1.ConfigMap, deleted bool) {
+	suspended := false
+	if !deleted {
+		if value, ok := cm.Data[EmergencyStopKey]; ok {
+			var err error
+			if suspended, err = strconv.ParseBool(value); err != nil {
+				klog.Errorf("Invalid value %q of key %s in ConfigMap %s/%s. Suspending all actions.", value, EmergencyStopKey, cm.Namespace, cm.Name)
+				suspended = true
+			}
+		}
+	}
+
+	var value int32
+	if suspended {
+		value = 1
+	}
+	if atomic.SwapInt32(&e.suspended, value) == value {
+		return
+	}
+
+	dwdActionsSuspended.Set(float64(value))
+	if suspended {
+		klog.Warningf("Emergency stop activated by ConfigMap %s/%s. Suspending all actions.", cm.Namespace, cm.Name)
+		e.recorder.Event(cm, corev1.EventTypeWarning, ReasonActionsSuspended, "All actions of the dependency-watchdog are suspended")
+		return
+	}
+	klog.Infof("Emergency stop released by ConfigMap %s/%s. Resuming all actions.", cm.Namespace, cm.Name)
+	if !deleted {
+		e.recorder.Event(cm, corev1.EventTypeNormal, ReasonActionsResumed, "All actions of the dependency-watchdog are resumed")
+	}
+}
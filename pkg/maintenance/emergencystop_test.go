@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package maintenance
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestEmergencyStop(t *testing.T) {
+	const ns = "garden"
+
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: DefaultEmergencyStopConfigMapName}}
+	client := fake.NewSimpleClientset(cm)
+	recorder := record.NewFakeRecorder(10)
+	e := NewEmergencyStop(client, ns, DefaultEmergencyStopConfigMapName, time.Minute, recorder)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	e.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, e.HasSynced) {
+		t.Fatal("failed to wait for caches to sync")
+	}
+
+	expectSuspended := func(suspended bool, code int) {
+		t.Helper()
+		if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+			return e.Suspended() == suspended, nil
+		}); err != nil {
+			t.Fatalf("expected suspended=%t", suspended)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if rec.Code != code {
+			t.Errorf("expected readiness status %d but got %d", code, rec.Code)
+		}
+	}
+
+	expectSuspended(false, http.StatusOK)
+
+	cm = cm.DeepCopy()
+	cm.Data = map[string]string{EmergencyStopKey: "true"}
+	if _, err := client.CoreV1().ConfigMaps(ns).Update(cm); err != nil {
+		t.Fatal(err)
+	}
+	expectSuspended(true, http.StatusServiceUnavailable)
+	if e := <-recorder.Events; e != corev1.EventTypeWarning+" "+ReasonActionsSuspended+" All actions of the dependency-watchdog are suspended" {
+		t.Errorf("unexpected event %s", e)
+	}
+
+	if err := client.CoreV1().ConfigMaps(ns).Delete(cm.Name, &metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expectSuspended(false, http.StatusOK)
+
+	var nilStop *EmergencyStop
+	if nilStop.Suspended() || !nilStop.HasSynced() {
+		t.Error("expected nil emergency stop never to suspend actions")
+	}
+}
@@ -0,0 +1,188 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package maintenance lets operators pause the dependency-watchdog for individual namespaces, e.g. during
+// maintenance of a shoot control plane, or stop it seed-wide in emergencies.
+package maintenance
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	gardenerv1alpha1 "github.com/gardener/gardener/pkg/apis/extensions/v1alpha1"
+	gardenerlisterv1alpha1 "github.com/gardener/gardener/pkg/client/extensions/listers/extensions/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+const (
+	// PauseAnnotationKey pauses the dependency-watchdog in the namespace if it is set on the namespace or on the
+	// Gardener Cluster of the same name. Its value is either "true" to pause until the annotation is removed or
+	// the RFC 3339 timestamp at which the pause ends.
+	PauseAnnotationKey = "dependency-watchdog.gardener.cloud/paused"
+
+	// ReasonPauseStarted is the reason of the event recorded when a pause starts.
+	ReasonPauseStarted = "MaintenancePauseStarted"
+	// ReasonPauseEnded is the reason of the event recorded when a pause ends.
+	ReasonPauseEnded = "MaintenancePauseEnded"
+
+	clusterAPIVersion = "extensions.gardener.cloud/v1alpha1"
+	kindCluster       = "Cluster"
+	kindNamespace     = "Namespace"
+)
+
+// pausedUntil returns if the given annotations pause the dependency-watchdog at the given time and when the pause
+// ends. The end is zero for pauses without expiry. Invalid values pause the dependency-watchdog as the intention
+// to keep it away is clear.
+func pausedUntil(annotations map[string]string, now time.Time) (bool, time.Time, error) {
+	value, ok := annotations[PauseAnnotationKey]
+	if !ok {
+		return false, time.Time{}, nil
+	}
+	if value == "true" {
+		return true, time.Time{}, nil
+	}
+	until, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return true, time.Time{}, fmt.Errorf("invalid value %q of annotation %s: %v", value, PauseAnnotationKey, err)
+	}
+	return now.Before(until), until, nil
+}
+
+// Checker checks if the dependency-watchdog is paused in a namespace. It records an event on the namespace or
+// Cluster carrying the pause annotation when it observes a pause to start or end.
+// A nil Checker never reports a pause.
+type Checker struct {
+	namespaceLister listerv1.NamespaceLister
+	clusterLister   gardenerlisterv1alpha1.ClusterLister
+	recorder        record.EventRecorder
+	now             func() time.Time
+
+	mux    sync.Mutex
+	paused map[corev1.ObjectReference]bool
+}
+
+// NewChecker creates a Checker looking up the namespaces and, if clusterLister is not nil, the Clusters in the
+// given listers.
+func NewChecker(namespaceLister listerv1.NamespaceLister, clusterLister gardenerlisterv1alpha1.ClusterLister, recorder record.EventRecorder) *Checker {
+	return &Checker{
+		namespaceLister: namespaceLister,
+		clusterLister:   clusterLister,
+		recorder:        recorder,
+		now:             time.Now,
+		paused:          make(map[corev1.ObjectReference]bool),
+	}
+}
+
+// Paused checks if the dependency-watchdog is paused in the given namespace.
+func (c *Checker) Paused(namespace string) bool {
+	if c == nil {
+		return false
+	}
+
+	now := c.now()
+	paused := false
+	if c.namespaceLister != nil {
+		ns, err := c.namespaceLister.Get(namespace)
+		if err == nil {
+			ref := namespaceRef(namespace)
+			ref.UID = ns.UID
+			paused = c.observe(ref, ns.Annotations, now) || paused
+		} else if !apierrors.IsNotFound(err) {
+			klog.Errorf("Error getting namespace %s to check for a pause: %s", namespace, err)
+		}
+	}
+	if c.clusterLister != nil {
+		// The name of cluster is same as shoot's namespace
+		cluster, err := c.clusterLister.Get(namespace)
+		if err == nil {
+			ref := clusterRef(namespace)
+			ref.UID = cluster.UID
+			paused = c.observe(ref, cluster.Annotations, now) || paused
+		} else if !apierrors.IsNotFound(err) {
+			klog.Errorf("Error getting cluster %s to check for a pause: %s", namespace, err)
+		}
+	}
+	return paused
+}
+
+// ForgetDeleted returns an event handler for the namespace and Cluster informers which drops the pause state of
+// deleted namespaces and Clusters. The pause state must outlive restarts of the probers in the namespace as the
+// start of a pause would be recorded again otherwise.
+func (c *Checker) ForgetDeleted() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			switch o := obj.(type) {
+			case *corev1.Namespace:
+				c.forget(namespaceRef(o.Name))
+			case *gardenerv1alpha1.Cluster:
+				c.forget(clusterRef(o.Name))
+			}
+		},
+	}
+}
+
+func (c *Checker) forget(key corev1.ObjectReference) {
+	if c == nil {
+		return
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	delete(c.paused, key)
+}
+
+func namespaceRef(name string) corev1.ObjectReference {
+	return corev1.ObjectReference{APIVersion: "v1", Kind: kindNamespace, Name: name}
+}
+
+func clusterRef(name string) corev1.ObjectReference {
+	return corev1.ObjectReference{APIVersion: clusterAPIVersion, Kind: kindCluster, Name: name}
+}
+
+// observe records the start or the end of a pause of the object. The pause state is kept by the reference without
+// the UID, which is only needed to show the events on the object.
+func (c *Checker) observe(ref corev1.ObjectReference, annotations map[string]string, now time.Time) bool {
+	paused, until, err := pausedUntil(annotations, now)
+	if err != nil {
+		klog.Errorf("%s %s: %s. Treating it as paused.", ref.Kind, ref.Name, err)
+	}
+
+	key := corev1.ObjectReference{APIVersion: ref.APIVersion, Kind: ref.Kind, Name: ref.Name}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.paused[key] == paused {
+		return paused
+	}
+	if paused {
+		c.paused[key] = true
+		msg := "Paused by annotation " + PauseAnnotationKey
+		if !until.IsZero() {
+			msg += " until " + until.Format(time.RFC3339)
+		}
+		klog.Infof("%s %s: %s", ref.Kind, ref.Name, msg)
+		c.recorder.Event(&ref, corev1.EventTypeNormal, ReasonPauseStarted, msg)
+		return true
+	}
+
+	delete(c.paused, key)
+	msg := "Pause ended as annotation " + PauseAnnotationKey + " was removed"
+	if !until.IsZero() {
+		msg = "Pause expired at " + until.Format(time.RFC3339)
+	}
+	klog.Infof("%s %s: %s", ref.Kind, ref.Name, msg)
+	c.recorder.Event(&ref, corev1.EventTypeNormal, ReasonPauseEnded, msg)
+	return false
+}
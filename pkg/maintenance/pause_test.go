@@ -0,0 +1,154 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package maintenance
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	gardenerv1alpha1 "github.com/gardener/gardener/pkg/apis/extensions/v1alpha1"
+	gardenerlisterv1alpha1 "github.com/gardener/gardener/pkg/client/extensions/listers/extensions/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestPausedUntil(t *testing.T) {
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		paused      bool
+		until       time.Time
+		invalid     bool
+	}{
+		{name: "no annotation"},
+		{name: "no expiry", annotations: map[string]string{PauseAnnotationKey: "true"}, paused: true},
+		{name: "before expiry", annotations: map[string]string{PauseAnnotationKey: "2022-01-01T14:00:00Z"}, paused: true, until: now.Add(2 * time.Hour)},
+		{name: "after expiry", annotations: map[string]string{PauseAnnotationKey: "2022-01-01T11:00:00Z"}, until: now.Add(-time.Hour)},
+		{name: "invalid", annotations: map[string]string{PauseAnnotationKey: "tomorrow"}, paused: true, invalid: true},
+	} {
+		paused, until, err := pausedUntil(tc.annotations, now)
+		if paused != tc.paused || !until.Equal(tc.until) || (err != nil) != tc.invalid {
+			t.Errorf("%s: expected paused=%t until=%s invalid=%t but got paused=%t until=%s err=%v", tc.name, tc.paused, tc.until, tc.invalid, paused, until, err)
+		}
+	}
+}
+
+func TestCheckerRecordsPauses(t *testing.T) {
+	const ns = "shoot--foo--bar"
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	namespaces := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	clusters := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}}
+	cluster := &gardenerv1alpha1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: ns}}
+	if err := namespaces.Add(namespace); err != nil {
+		t.Fatal(err)
+	}
+	if err := clusters.Add(cluster); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	c := NewChecker(listerv1.NewNamespaceLister(namespaces), gardenerlisterv1alpha1.NewClusterLister(clusters), recorder)
+	c.now = func() time.Time { return now }
+
+	expectEvents := func(reasons ...string) {
+		t.Helper()
+		for _, reason := range reasons {
+			select {
+			case e := <-recorder.Events:
+				if want := corev1.EventTypeNormal + " " + reason; !strings.HasPrefix(e, want) {
+					t.Errorf("expected event %s but got %s", want, e)
+				}
+			default:
+				t.Errorf("expected event %s but got none", reason)
+			}
+		}
+		select {
+		case e := <-recorder.Events:
+			t.Errorf("unexpected event %s", e)
+		default:
+		}
+	}
+
+	if c.Paused(ns) {
+		t.Error("expected namespace without annotation not to be paused")
+	}
+	expectEvents()
+
+	cluster = cluster.DeepCopy()
+	cluster.Annotations = map[string]string{PauseAnnotationKey: "2022-01-01T13:00:00Z"}
+	if err := clusters.Update(cluster); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Paused(ns) || !c.Paused(ns) {
+		t.Error("expected namespace with paused cluster to be paused")
+	}
+	expectEvents(ReasonPauseStarted)
+
+	now = now.Add(2 * time.Hour)
+	if c.Paused(ns) {
+		t.Error("expected pause to expire")
+	}
+	expectEvents(ReasonPauseEnded)
+
+	namespace = namespace.DeepCopy()
+	namespace.Annotations = map[string]string{PauseAnnotationKey: "true"}
+	if err := namespaces.Update(namespace); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Paused(ns) {
+		t.Error("expected paused namespace to be paused")
+	}
+	expectEvents(ReasonPauseStarted)
+
+	if (*Checker)(nil).Paused(ns) {
+		t.Error("expected nil checker never to pause")
+	}
+}
+
+// refRecorder records the references of the objects of the events.
+type refRecorder struct {
+	record.FakeRecorder
+	refs []corev1.ObjectReference
+}
+
+func (r *refRecorder) Event(object runtime.Object, _, _, _ string) {
+	r.refs = append(r.refs, *object.(*corev1.ObjectReference))
+}
+
+func TestCheckerForgetsDeleted(t *testing.T) {
+	const ns = "shoot--foo--bar"
+
+	namespaces := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns, UID: types.UID("uid"), Annotations: map[string]string{PauseAnnotationKey: "true"}}}
+	if err := namespaces.Add(namespace); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &refRecorder{}
+	c := NewChecker(listerv1.NewNamespaceLister(namespaces), nil, recorder)
+	if !c.Paused(ns) || !c.Paused(ns) {
+		t.Error("expected paused namespace to be paused")
+	}
+	if len(recorder.refs) != 1 || recorder.refs[0].UID != namespace.UID {
+		t.Errorf("expected one event on the namespace with its UID but got %v", recorder.refs)
+	}
+
+	c.ForgetDeleted().OnDelete(cache.DeletedFinalStateUnknown{Key: ns, Obj: namespace})
+	if !c.Paused(ns) {
+		t.Error("expected paused namespace to be paused")
+	}
+	if len(recorder.refs) != 2 {
+		t.Errorf("expected the start of the pause to be recorded again after the namespace was deleted but got %v", recorder.refs)
+	}
+}
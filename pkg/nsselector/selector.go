@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package nsselector selects the namespaces watched by the dependency-watchdog by their labels.
+package nsselector
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// Config selects namespaces by their labels. A namespace is selected if its labels match Include, or Include
+// is not set, and they do not match Exclude.
+type Config struct {
+	Include *metav1.LabelSelector `json:"include,omitempty"`
+	Exclude *metav1.LabelSelector `json:"exclude,omitempty"`
+}
+
+// Validate checks that the label selectors of the config are valid.
+func Validate(config *Config) error {
+	_, _, err := selectorsOf(config)
+	return err
+}
+
+func selectorsOf(config *Config) (labels.Selector, labels.Selector, error) {
+	include, exclude := labels.Everything(), labels.Nothing()
+	if config == nil {
+		return include, exclude, nil
+	}
+	var err error
+	if config.Include != nil {
+		if include, err = metav1.LabelSelectorAsSelector(config.Include); err != nil {
+			return nil, nil, fmt.Errorf("invalid include selector: %v", err)
+		}
+	}
+	if config.Exclude != nil {
+		if exclude, err = metav1.LabelSelectorAsSelector(config.Exclude); err != nil {
+			return nil, nil, fmt.Errorf("invalid exclude selector: %v", err)
+		}
+	}
+	return include, exclude, nil
+}
+
+// Selector checks if namespaces are selected by a Config. A nil Selector selects all namespaces.
+type Selector struct {
+	informer cache.SharedIndexInformer
+	lister   listerv1.NamespaceLister
+	include  labels.Selector
+	exclude  labels.Selector
+}
+
+// New creates a Selector looking up the labels of the namespaces in the given informer. It returns nil if no
+// config is given.
+func New(config *Config, namespaces coreinformers.NamespaceInformer) (*Selector, error) {
+	if config == nil {
+		return nil, nil
+	}
+	include, exclude, err := selectorsOf(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Selector{
+		informer: namespaces.Informer(),
+		lister:   namespaces.Lister(),
+		include:  include,
+		exclude:  exclude,
+	}, nil
+}
+
+// Selects checks if the namespace with the given name is selected. Unknown namespaces are not selected.
+func (s *Selector) Selects(namespace string) bool {
+	if s == nil {
+		return true
+	}
+	ns, err := s.lister.Get(namespace)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			klog.Errorf("Error getting namespace %s to check if it is selected: %s", namespace, err)
+		}
+		return false
+	}
+	return s.matches(ns)
+}
+
+func (s *Selector) matches(ns *corev1.Namespace) bool {
+	set := labels.Set(ns.Labels)
+	return s.include.Matches(set) && !s.exclude.Matches(set)
+}
+
+// AddEventHandler calls selected for namespaces which start to be selected and released for namespaces which
+// stop to be selected as their labels change.
+func (s *Selector) AddEventHandler(selected, released func(namespace string)) {
+	if s == nil {
+		return
+	}
+	s.informer.AddEventHandler(s.handler(selected, released))
+}
+
+func (s *Selector) handler(selected, released func(namespace string)) cache.ResourceEventHandlerFuncs {
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			oldNs, newNs := old.(*corev1.Namespace), new.(*corev1.Namespace)
+			switch wasSelected, isSelected := s.matches(oldNs), s.matches(newNs); {
+			case !wasSelected && isSelected:
+				klog.Infof("Namespace %s is selected", newNs.Name)
+				selected(newNs.Name)
+			case wasSelected && !isSelected:
+				klog.Infof("Namespace %s is no longer selected", newNs.Name)
+				released(newNs.Name)
+			}
+		},
+	}
+}
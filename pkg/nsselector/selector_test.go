@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package nsselector
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSelects(t *testing.T) {
+	namespaces := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0).Core().V1().Namespaces()
+	for name, labels := range map[string]map[string]string{
+		"production":          {"purpose": "production"},
+		"production-excluded": {"purpose": "production", "dwd": "ignore"},
+		"evaluation":          {"purpose": "evaluation"},
+		"unlabelled":          nil,
+	} {
+		if err := namespaces.Informer().GetIndexer().Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := New(&Config{
+		Include: &metav1.LabelSelector{MatchLabels: map[string]string{"purpose": "production"}},
+		Exclude: &metav1.LabelSelector{MatchLabels: map[string]string{"dwd": "ignore"}},
+	}, namespaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ns, selected := range map[string]bool{
+		"production":          true,
+		"production-excluded": false,
+		"evaluation":          false,
+		"unlabelled":          false,
+		"unknown":             false,
+	} {
+		if s.Selects(ns) != selected {
+			t.Errorf("expected namespace %s to be selected=%t", ns, selected)
+		}
+	}
+
+	excludeOnly, err := New(&Config{Exclude: &metav1.LabelSelector{MatchLabels: map[string]string{"dwd": "ignore"}}}, namespaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !excludeOnly.Selects("unlabelled") || excludeOnly.Selects("production-excluded") {
+		t.Error("expected only the excluded namespace not to be selected")
+	}
+
+	var none *Selector
+	if !none.Selects("unknown") {
+		t.Error("expected a nil selector to select all namespaces")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(nil); err != nil {
+		t.Errorf("expected no config to be valid but got %v", err)
+	}
+	invalid := &Config{Include: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "purpose", Operator: "Unknown"}}}}
+	if err := Validate(invalid); err == nil {
+		t.Error("expected an unknown operator to be invalid")
+	}
+}
+
+func TestEventHandler(t *testing.T) {
+	namespaces := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0).Core().V1().Namespaces()
+	s, err := New(&Config{Include: &metav1.LabelSelector{MatchLabels: map[string]string{"purpose": "production"}}}, namespaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var selected, released []string
+	handler := s.handler(
+		func(ns string) { selected = append(selected, ns) },
+		func(ns string) { released = append(released, ns) },
+	)
+	unlabelled := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "foo", ResourceVersion: "1"}}
+	labelled := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "foo", ResourceVersion: "2", Labels: map[string]string{"purpose": "production"}}}
+
+	handler.OnUpdate(unlabelled, labelled)
+	handler.OnUpdate(labelled, labelled)
+	handler.OnUpdate(labelled, unlabelled)
+	if len(selected) != 1 || len(released) != 1 {
+		t.Errorf("expected the namespace to be selected and released once but got selected=%v released=%v", selected, released)
+	}
+}
@@ -5,14 +5,17 @@
 package api
 
 import (
+	"github.com/gardener/dependency-watchdog/pkg/nsselector"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // ServiceDependants holds the service and the label selectors of the pods which has to be restarted when
 // the service becomes ready and the pods are in CrashloopBackoff.
+// NamespaceSelector restricts the watched namespaces to those whose labels it selects.
 type ServiceDependants struct {
-	Services  map[string]Service `json:"services"`
-	Namespace string             `json:"namespace"`
+	Services          map[string]Service `json:"services"`
+	Namespace         string             `json:"namespace"`
+	NamespaceSelector *nsselector.Config `json:"namespaceSelector,omitempty"`
 }
 
 // Service struct defines the dependent pods of a service.
@@ -21,7 +24,11 @@ type Service struct {
 }
 
 // DependantPods struct captures the details needed to identify dependant pods.
+// If CrashCausePatterns are configured, only those pods are restarted whose crashing containers
+// report a termination message or previous logs matching at least one of the regular expressions.
 type DependantPods struct {
-	Name     string                `json:"name,omitempty"`
-	Selector *metav1.LabelSelector `json:"selector"`
+	Name               string                `json:"name,omitempty"`
+	Selector           *metav1.LabelSelector `json:"selector"`
+	CrashCausePatterns []string              `json:"crashCausePatterns,omitempty"`
+	LogTailLines       *int64                `json:"logTailLines,omitempty"`
 }
@@ -7,6 +7,7 @@ package restarter
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"time"
 
 	"github.com/gardener/dependency-watchdog/pkg/restarter/api"
@@ -18,13 +19,18 @@ import (
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	componentbaseconfigv1alpha1 "k8s.io/component-base/config/v1alpha1"
 
+	"github.com/gardener/dependency-watchdog/pkg/access"
+	"github.com/gardener/dependency-watchdog/pkg/maintenance"
 	"github.com/gardener/dependency-watchdog/pkg/multicontext"
+	"github.com/gardener/dependency-watchdog/pkg/nsselector"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog"
+	"k8s.io/utils/pointer"
 )
 
 // NewController initializes a new K8s dependency-watchdog controller with restarter.
@@ -32,12 +38,21 @@ func NewController(clientset kubernetes.Interface,
 	sharedInformerFactory informers.SharedInformerFactory,
 	serviceDependants *api.ServiceDependants,
 	watchDuration time.Duration,
+	recorder record.EventRecorder,
 	stopCh <-chan struct{}) *Controller {
+	namespaces := sharedInformerFactory.Core().V1().Namespaces()
+	namespaceSelector, err := nsselector.New(serviceDependants.NamespaceSelector, namespaces)
+	if err != nil {
+		klog.Fatalf("Invalid namespace selector: %s", err)
+	}
 	c := &Controller{
 		clientset:         clientset,
 		informerFactory:   sharedInformerFactory,
 		endpointInformer:  sharedInformerFactory.Core().V1().Endpoints().Informer(),
 		endpointLister:    sharedInformerFactory.Core().V1().Endpoints().Lister(),
+		namespaceInformer: namespaces.Informer(),
+		namespaceSelector: namespaceSelector,
+		maintenance:       maintenance.NewChecker(namespaces.Lister(), nil, recorder),
 		workqueue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Endpoints"),
 		stopCh:            stopCh,
 		serviceDependants: serviceDependants,
@@ -61,7 +76,11 @@ func NewController(clientset kubernetes.Interface,
 			c.enqueueEndpoint(new)
 		},
 	})
-	c.hasSynced = c.endpointInformer.HasSynced
+	c.namespaceSelector.AddEventHandler(c.enqueueEndpoints, c.releaseEndpoints)
+	c.namespaceInformer.AddEventHandler(c.maintenance.ForgetDeleted())
+	c.hasSynced = func() bool {
+		return c.endpointInformer.HasSynced() && c.namespaceInformer.HasSynced()
+	}
 	return c
 }
 
@@ -81,8 +100,9 @@ func (c *Controller) enqueueEndpoint(obj interface{}) {
 		return
 	}
 
-	// Skip resources from other namespaces if namespace is specified explicitly in the configuration.
-	if c.serviceDependants.Namespace != "" && c.serviceDependants.Namespace != namespace {
+	// Skip resources from other namespaces if namespace is specified explicitly in the configuration
+	// or from namespaces which are not selected.
+	if !c.selected(namespace) {
 		return
 	}
 
@@ -94,6 +114,39 @@ func (c *Controller) enqueueEndpoint(obj interface{}) {
 	c.workqueue.AddRateLimited(key)
 }
 
+// selected checks if the namespace is the configured one, if any, and is selected by the namespace selector.
+func (c *Controller) selected(namespace string) bool {
+	if c.serviceDependants.Namespace != "" && c.serviceDependants.Namespace != namespace {
+		return false
+	}
+	return c.namespaceSelector.Selects(namespace)
+}
+
+// enqueueEndpoints enqueues the endpoints of the configured services in a namespace which starts to be selected.
+func (c *Controller) enqueueEndpoints(namespace string) {
+	for name := range c.serviceDependants.Services {
+		ep, err := c.endpointLister.Endpoints(namespace).Get(name)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				klog.Errorf("Error getting endpoint %s/%s: %s", namespace, name, err)
+			}
+			continue
+		}
+		c.enqueueEndpoint(ep)
+	}
+}
+
+// releaseEndpoints cancels the watches of the dependant pods of all configured services in a namespace which
+// is no longer selected.
+func (c *Controller) releaseEndpoints(namespace string) {
+	for name := range c.serviceDependants.Services {
+		c.ContextCh <- &multicontext.ContextMessage{
+			Key:      namespace + "/" + name,
+			CancelFn: nil,
+		}
+	}
+}
+
 // Run will set up the event handlers for types we are interested in, as well
 // as syncing informer caches and starting workers. It will block until stopCh
 // is closed, at which point it will shutdown the workqueue and wait for
@@ -104,15 +157,19 @@ func (c *Controller) Run(threadiness int) error {
 
 	// Start the informer factories to begin populating the informer caches
 	klog.Info("Starting restarter controller")
+	if err := access.CheckNamespaces(c.clientset); err != nil {
+		return err
+	}
 
 	klog.Info("Starting informer factory.")
 	c.informerFactory.Start(c.stopCh)
+	c.EmergencyStop.Start(c.stopCh)
 
 	go c.Multicontext.Start(c.stopCh)
 
 	// Wait for the caches to be synced before starting workers
 	klog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(c.stopCh, c.hasSynced); !ok {
+	if ok := cache.WaitForCacheSync(c.stopCh, c.hasSynced, c.EmergencyStop.HasSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -182,7 +239,7 @@ func (c *Controller) processEndpoint(ctx context.Context, key string) error {
 		utilruntime.HandleError(fmt.Errorf("invalid resource key: %s", key))
 		return nil
 	}
-	if c.serviceDependants.Namespace != "" && namespace != c.serviceDependants.Namespace {
+	if !c.selected(namespace) {
 		return nil
 	}
 
@@ -258,6 +315,10 @@ func (c *Controller) shootDependentPodsIfNecessary(ctx context.Context, namespac
 	if err != nil {
 		return fmt.Errorf("error converting label selector to selector %s", depPods.Selector.String())
 	}
+	patterns, err := CompileCrashCausePatterns(depPods.CrashCausePatterns)
+	if err != nil {
+		return fmt.Errorf("error compiling crash cause patterns for dependant pods %s: %v", depPods.Name, err)
+	}
 
 	for {
 		retry, err := func() (bool, error) {
@@ -289,7 +350,7 @@ func (c *Controller) shootDependentPodsIfNecessary(ctx context.Context, namespac
 					}
 					switch pod := ev.Object.(type) {
 					case *v1.Pod:
-						err := c.processPod(ctx, pod)
+						err := c.processPod(ctx, pod, depPods, patterns)
 						if err != nil {
 							klog.Errorf("error processing pod %s: %v", pod.Name, err.Error())
 						}
@@ -305,7 +366,7 @@ func (c *Controller) shootDependentPodsIfNecessary(ctx context.Context, namespac
 	}
 }
 
-func (c *Controller) processPod(ctx context.Context, pod *v1.Pod) error {
+func (c *Controller) processPod(ctx context.Context, pod *v1.Pod, depPods *api.DependantPods, patterns []*regexp.Regexp) error {
 	// Validate pod status again before shoot it out.
 	po, err := c.clientset.CoreV1().Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{})
 	if err != nil {
@@ -314,6 +375,36 @@ func (c *Controller) processPod(ctx context.Context, pod *v1.Pod) error {
 	if !ShouldDeletePod(po) {
 		return nil
 	}
+	matched, err := IsCrashCauseMatching(po, patterns, func(container string) ([]byte, error) {
+		return c.getPreviousLogs(po, container, depPods.LogTailLines)
+	})
+	if err != nil {
+		return fmt.Errorf("error checking crash cause of pod %s: %v", po.Name, err)
+	}
+	if !matched {
+		klog.Infof("Skipping pod %s as its crash cause does not match any of the patterns of %s", po.Name, depPods.Name)
+		return nil
+	}
+	if c.EmergencyStop.Suspended() {
+		klog.Infof("Skipping pod %s as all actions are suspended", po.Name)
+		return nil
+	}
+	if c.maintenance.Paused(po.Namespace) {
+		klog.Infof("Skipping pod %s as namespace %s is paused", po.Name, po.Namespace)
+		return nil
+	}
 	klog.Infof("Deleting pod: %v", po.Name)
 	return c.clientset.CoreV1().Pods(po.Namespace).Delete(po.Name, &metav1.DeleteOptions{})
 }
+
+// getPreviousLogs fetches the tail of the logs of the previous instance of the given container.
+func (c *Controller) getPreviousLogs(pod *v1.Pod, container string, tailLines *int64) ([]byte, error) {
+	if tailLines == nil {
+		tailLines = pointer.Int64Ptr(defaultLogTailLines)
+	}
+	return c.clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{
+		Container: container,
+		Previous:  true,
+		TailLines: tailLines,
+	}).DoRaw()
+}
@@ -5,9 +5,14 @@
 package restarter
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"regexp"
 	"testing"
 	"time"
 
+	"github.com/gardener/dependency-watchdog/pkg/maintenance"
 	"github.com/gardener/dependency-watchdog/pkg/restarter/api"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -19,6 +24,7 @@ import (
 	"k8s.io/client-go/kubernetes/fake"
 	test "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 var (
@@ -173,7 +179,7 @@ func (f *fixture) newController(deps *api.ServiceDependants, stopCh chan struct{
 		informers.WithNamespace(deps.Namespace),
 		informers.WithTweakListOptions(func(options *metav1.ListOptions) {}))
 
-	c := NewController(f.client, informers, deps, watchDuration, stopCh)
+	c := NewController(f.client, informers, deps, watchDuration, record.NewFakeRecorder(10), stopCh)
 	for _, d := range f.endpoints {
 		informers.Apps().V1().Deployments().Informer().GetIndexer().Add(d)
 	}
@@ -332,3 +338,136 @@ func TestDeletePodTransitioningToCrashloopBackoff(t *testing.T) {
 		t.Errorf("Pod in CrashloopBackoff not deleted by the dependency-watchdog. Expected 0 pods but got %d", len(pl.Items))
 	}
 }
+
+func TestIsCrashCauseMatching(t *testing.T) {
+	patterns, err := CompileCrashCausePatterns([]string{"connection refused", "etcd.*unavailable"})
+	if err != nil {
+		t.Fatalf("error compiling patterns: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name               string
+		terminationMessage string
+		logs               string
+		patterns           []*regexp.Regexp
+		expected           bool
+	}{
+		{name: "no patterns", expected: true},
+		{name: "matching termination message", terminationMessage: "dial tcp 10.0.0.1:2379: connect: connection refused", patterns: patterns, expected: true},
+		{name: "matching logs", terminationMessage: "exit 1", logs: "etcd cluster is unavailable or misconfigured", patterns: patterns, expected: true},
+		{name: "no match", terminationMessage: "panic: nil pointer dereference", logs: "goroutine 1 [running]", patterns: patterns, expected: false},
+	} {
+		p := newPodInCrashloop("pod-c", nil)
+		p.Status.ContainerStatuses[0].LastTerminationState.Terminated = &v1.ContainerStateTerminated{
+			ExitCode: 1,
+			Message:  tc.terminationMessage,
+		}
+		matched, err := IsCrashCauseMatching(p, tc.patterns, func(container string) ([]byte, error) {
+			return []byte(tc.logs), nil
+		})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+		if matched != tc.expected {
+			t.Errorf("%s: expected match to be %t but was %t", tc.name, tc.expected, matched)
+		}
+	}
+
+	p := newPodInCrashloop("pod-c", nil)
+	p.Status.ContainerStatuses = append(p.Status.ContainerStatuses, v1.ContainerStatus{
+		Name:  "Container-1",
+		State: p.Status.ContainerStatuses[0].State,
+	})
+	getLogs := func(logs map[string]string) func(container string) ([]byte, error) {
+		return func(container string) ([]byte, error) {
+			l, ok := logs[container]
+			if !ok {
+				return nil, errors.New("container not found")
+			}
+			return []byte(l), nil
+		}
+	}
+	matched, err := IsCrashCauseMatching(p, patterns, getLogs(map[string]string{"Container-1": "connection refused"}))
+	if err != nil || !matched {
+		t.Errorf("expected the container with logs to match after skipping the one without logs but got %t, %v", matched, err)
+	}
+	matched, err = IsCrashCauseMatching(p, patterns, getLogs(map[string]string{"Container-1": "exit 1"}))
+	if err == nil || matched {
+		t.Errorf("expected the error of the container without logs if no other container matches but got %t, %v", matched, err)
+	}
+}
+
+func TestLoadServiceDependantsWithInvalidCrashCausePatterns(t *testing.T) {
+	f, err := ioutil.TempFile("", "service-dependants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(dep + `
+      crashCausePatterns:
+      - "etcd.*(unavailable"`); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadServiceDependants(f.Name()); err == nil {
+		t.Error("expected an error for the invalid crash cause pattern")
+	}
+}
+
+func TestSkipPodsInPausedNamespace(t *testing.T) {
+	f := newFixture(t)
+	deps, err := api.Decode([]byte(dep))
+	if err != nil {
+		t.Fatalf("error decoding file: %v", err)
+	}
+	deps.Namespace = metav1.NamespaceDefault
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	depMap, err := metav1.LabelSelectorAsMap(deps.Services["kube-apiserver"].Dependants[0].Selector)
+	if err != nil {
+		t.Fatalf("error creating map from selector: %v", err)
+	}
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        metav1.NamespaceDefault,
+		Annotations: map[string]string{maintenance.PauseAnnotationKey: "true"},
+	}}
+	e := newEndpoint("kube-apiserver", deps.Namespace, depMap)
+	pC := newPodInCrashloop("pod-c", map[string]string{
+		"garden.sapcloud.io/role": "controlplane",
+		"role":                    "NotEtcd",
+	})
+
+	f.endpoints = append(f.endpoints, e)
+	f.objects = append(f.objects, ns, e, pC)
+	watcher := watch.NewFakeWithChanSize(1, false)
+	client := fake.NewSimpleClientset(f.objects...)
+	client.PrependWatchReactor("pods", test.DefaultWatchReactor(watcher, nil))
+	f.client = client
+
+	c, _, err := f.newController(deps, stopCh)
+	if err != nil {
+		t.Fatalf("error creating Deployment controller: %v", err)
+	}
+
+	watcher.Add(pC)
+
+	go func() {
+		t.Logf("Starting dep watchdog.\n")
+		c.Run(1)
+	}()
+
+	// Wait for the dependency watchdog to take action.
+	time.Sleep(2 * time.Second)
+
+	pl, err := f.client.CoreV1().Pods(metav1.NamespaceDefault).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("error fetching pods: %v", err)
+	}
+	if len(pl.Items) != 1 {
+		t.Errorf("Pod in CrashloopBackoff deleted by the dependency-watchdog although the namespace is paused. Expected 1 pod but got %d", len(pl.Items))
+	}
+}
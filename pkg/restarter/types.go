@@ -7,7 +7,9 @@ package restarter
 import (
 	"time"
 
+	"github.com/gardener/dependency-watchdog/pkg/maintenance"
 	"github.com/gardener/dependency-watchdog/pkg/multicontext"
+	"github.com/gardener/dependency-watchdog/pkg/nsselector"
 	"github.com/gardener/dependency-watchdog/pkg/restarter/api"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
@@ -18,7 +20,8 @@ import (
 )
 
 const (
-	crashLoopBackOff = "CrashLoopBackOff"
+	crashLoopBackOff    = "CrashLoopBackOff"
+	defaultLogTailLines = 100
 )
 
 // Controller looks at ServiceDependants and reconciles the dependantPods once the service becomes available.
@@ -27,11 +30,16 @@ type Controller struct {
 	informerFactory   informers.SharedInformerFactory
 	endpointInformer  cache.SharedIndexInformer
 	endpointLister    listerv1.EndpointsLister
+	namespaceInformer cache.SharedIndexInformer
+	namespaceSelector *nsselector.Selector
+	maintenance       *maintenance.Checker
 	workqueue         workqueue.RateLimitingInterface
 	hasSynced         cache.InformerSynced
 	stopCh            <-chan struct{}
 	serviceDependants *api.ServiceDependants
 	watchDuration     time.Duration
+	// EmergencyStop suspends all pod deletions while it is active. It is optional.
+	EmergencyStop *maintenance.EmergencyStop
 	// LeaderElection defines the configuration of leader election client.
 	LeaderElection componentbaseconfig.LeaderElectionConfiguration
 	*multicontext.Multicontext
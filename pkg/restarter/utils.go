@@ -5,9 +5,12 @@
 package restarter
 
 import (
+	"fmt"
 	"io/ioutil"
+	"regexp"
 	"time"
 
+	"github.com/gardener/dependency-watchdog/pkg/nsselector"
 	"github.com/gardener/dependency-watchdog/pkg/restarter/api"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -19,7 +22,21 @@ func LoadServiceDependants(file string) (*api.ServiceDependants, error) {
 	if err != nil {
 		return nil, err
 	}
-	return api.Decode(data)
+	deps, err := api.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := nsselector.Validate(deps.NamespaceSelector); err != nil {
+		return nil, fmt.Errorf("invalid namespace selector: %v", err)
+	}
+	for name, service := range deps.Services {
+		for _, depPods := range service.Dependants {
+			if _, err := CompileCrashCausePatterns(depPods.CrashCausePatterns); err != nil {
+				return nil, fmt.Errorf("invalid crash cause patterns for dependant pods %s of service %s: %v", depPods.Name, name, err)
+			}
+		}
+	}
+	return deps, nil
 }
 
 // IsPodAvailable returns true if a pod is available; false otherwise.
@@ -102,6 +119,59 @@ func IsPodInCrashloopBackoff(status v1.PodStatus) bool {
 	return false
 }
 
+// CompileCrashCausePatterns compiles the crash cause patterns configured for the dependant pods.
+func CompileCrashCausePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var regexps []*regexp.Regexp
+	for _, pattern := range patterns {
+		r, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		regexps = append(regexps, r)
+	}
+	return regexps, nil
+}
+
+// IsCrashCauseMatching checks if any of the containers in CrashloopBackoff crashed for one of the causes
+// described by patterns. The termination message of the previous container instance is checked first
+// and the tail of its logs, as returned by getLogs, only if the termination message does not match.
+// Containers whose logs cannot be fetched are skipped. The first such error is returned if no other container
+// matches. It returns true if no patterns are given.
+func IsCrashCauseMatching(pod *v1.Pod, patterns []*regexp.Regexp, getLogs func(container string) ([]byte, error)) (bool, error) {
+	if len(patterns) == 0 {
+		return true, nil
+	}
+	var logsErr error
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		if !isContainerInCrashLoopBackOff(containerStatus.State) {
+			continue
+		}
+		if t := containerStatus.LastTerminationState.Terminated; t != nil && matchesAny(patterns, []byte(t.Message)) {
+			return true, nil
+		}
+		logs, err := getLogs(containerStatus.Name)
+		if err != nil {
+			if logsErr == nil {
+				logsErr = fmt.Errorf("error fetching the logs of container %s: %v", containerStatus.Name, err)
+			}
+			continue
+		}
+		if matchesAny(patterns, logs) {
+			return true, nil
+		}
+	}
+	return false, logsErr
+}
+
+func matchesAny(patterns []*regexp.Regexp, b []byte) bool {
+	for _, r := range patterns {
+		if r.Match(b) {
+			return true
+		}
+	}
+	return false
+}
+
 func isContainerInCrashLoopBackOff(containerState v1.ContainerState) bool {
 	if containerState.Waiting != nil {
 		return containerState.Waiting.Reason == crashLoopBackOff
@@ -5,6 +5,7 @@
 package api
 
 import (
+	"github.com/gardener/dependency-watchdog/pkg/nsselector"
 	autoscalingv1 "k8s.io/api/autoscaling/v1"
 )
 
@@ -12,9 +13,71 @@ import (
 // dependant Scales. If the external probe fails and the internal probe still succeeds, then the
 // corresponding dependant Scales are scaled down to `zero`. They are scaled back to their
 // original scale when the external probe succeeds again.
+// If PersistState is set, the state of the probers is persisted in a config map in the probed
+// namespaces so that it survives restarts and leader failovers of the dependency-watchdog.
+// ScalerOwnerKinds are the kinds of operators which manage the replicas of the targets they own.
+// Dependant scales owned by an object of one of these kinds are handled according to their ConflictPolicy.
+// ReadinessSource decides which namespaces are ready to be probed. It defaults to the Gardener Clusters.
+// NamespaceSelector restricts the probed namespaces to those whose labels it selects. Namespaces which start
+// or stop to be selected are picked up or released at runtime.
 type ProbeDependantsList struct {
-	Probes    []ProbeDependants `json:"probes"`
-	Namespace string            `json:"namespace"`
+	Probes            []ProbeDependants      `json:"probes"`
+	Namespace         string                 `json:"namespace"`
+	CircuitBreaker    *CircuitBreakerConfig  `json:"circuitBreaker,omitempty"`
+	SelfCheck         *SelfCheckConfig       `json:"selfCheck,omitempty"`
+	PersistState      bool                   `json:"persistState,omitempty"`
+	ScalerOwnerKinds  []string               `json:"scalerOwnerKinds,omitempty"`
+	ReadinessSource   *ReadinessSourceConfig `json:"readinessSource,omitempty"`
+	NamespaceSelector *nsselector.Config     `json:"namespaceSelector,omitempty"`
+}
+
+// ReadinessSourceConfig selects the source deciding if a namespace is ready to be probed.
+// For the Namespace type a namespace is ready if it carries the label Label or the annotation Annotation
+// with the value Value, or with any value if Value is empty. If neither Label nor Annotation is set, the label
+// dependency-watchdog.gardener.cloud/probe=true is used.
+type ReadinessSourceConfig struct {
+	Type       ReadinessSourceType `json:"type,omitempty"`
+	Label      string              `json:"label,omitempty"`
+	Annotation string              `json:"annotation,omitempty"`
+	Value      string              `json:"value,omitempty"`
+}
+
+// ReadinessSourceType is the type of the source deciding if a namespace is ready to be probed.
+type ReadinessSourceType string
+
+const (
+	// ReadinessSourceGardenerCluster probes the namespaces of the Gardener Clusters as long as their shoots
+	// are ready. This is the default.
+	ReadinessSourceGardenerCluster ReadinessSourceType = "GardenerCluster"
+	// ReadinessSourceNamespace probes the namespaces marked with a label or annotation.
+	ReadinessSourceNamespace ReadinessSourceType = "Namespace"
+	// ReadinessSourceAlwaysReady probes all namespaces.
+	ReadinessSourceAlwaysReady ReadinessSourceType = "AlwaysReady"
+)
+
+// SelfCheckConfig captures the reference endpoints the dependency-watchdog checks its own network path
+// against before it trusts a failed external probe. URLs are known-good endpoints, e.g. the external
+// apiserver domain of the seed, which are requested through the same route as the external probes.
+// Any response counts as reachable. If none of them is reachable, the observer itself is considered
+// unhealthy and the dependant scales are not scaled down. The URLs are requested every CacheSeconds and the
+// result is shared by all the probers in between.
+type SelfCheckConfig struct {
+	URLs           []string `json:"urls"`
+	TimeoutSeconds *int32   `json:"timeoutSeconds,omitempty"`
+	CacheSeconds   *int32   `json:"cacheSeconds,omitempty"`
+}
+
+// CircuitBreakerConfig captures the details of the seed-wide circuit breaker which suppresses the scale down
+// of dependant scales in all namespaces if more than MaxUnhealthyPercentage of the probed namespaces turned
+// externally unhealthy within the last WindowSeconds. This guards against probe failures caused by the
+// network of the dependency-watchdog itself. The circuit breaker stays tripped until no more than
+// MaxUnhealthyPercentage of the probed namespaces are still externally unhealthy, even if the outage lasts
+// longer than WindowSeconds. The circuit breaker is only considered once at least MinNamespaces namespaces
+// are probed.
+type CircuitBreakerConfig struct {
+	MaxUnhealthyPercentage *int32 `json:"maxUnhealthyPercentage,omitempty"`
+	WindowSeconds          *int32 `json:"windowSeconds,omitempty"`
+	MinNamespaces          *int32 `json:"minNamespaces,omitempty"`
 }
 
 // ProbeDependants struct captures the details about a probe and its dependant scale sub-resources.
@@ -25,27 +88,139 @@ type ProbeDependants struct {
 }
 
 // ProbeConfig struct captures the details for probing a Kubernetes apiserver.
+// MinPeriodSeconds and MaxPeriodSeconds make the period adaptive. The probes run every MinPeriodSeconds while
+// the results are transitioning, while the internal and external results disagree and right after a scale
+// action. While both probes are steadily healthy, the period doubles from PeriodSeconds up to MaxPeriodSeconds.
+// Both default to PeriodSeconds, i.e. a fixed period.
+// Evaluation selects how the results of the probes are evaluated. By default a probe is healthy after
+// SuccessThreshold and unhealthy after FailureThreshold consecutive identical results.
+// Cooldown damps the scale transitions of the dependants if the external endpoint flaps.
+// FailureClasses are the classes of probe failures which count towards the FailureThreshold, respectively the
+// evaluation window. Failures of the other classes are logged and counted in the metrics but otherwise ignored.
+// By default all classes but Throttled count. The class of a failure is logged with it, labels the
+// probe_failures_total metric, is shown in the status of the prober served at /status and is kept in the
+// persisted state of the probe.
 type ProbeConfig struct {
-	External            *ProbeDetails `json:"external,omitempty"`
-	Internal            *ProbeDetails `json:"internal,omitempty"`
-	InitialDelaySeconds *int32        `json:"initialDelaySeconds,omitempty"`
-	TimeoutSeconds      *int32        `json:"timeoutSeconds,omitempty"`
-	ProbeTimeoutSeconds *int32        `json:"probeTimeoutSeconds,omitempty"`
-	PeriodSeconds       *int32        `json:"periodSeconds,omitempty"`
-	SuccessThreshold    *int32        `json:"successThreshold,omitempty"`
-	FailureThreshold    *int32        `json:"failureThreshold,omitempty"`
+	External            *ProbeDetails     `json:"external,omitempty"`
+	Internal            *ProbeDetails     `json:"internal,omitempty"`
+	InitialDelaySeconds *int32            `json:"initialDelaySeconds,omitempty"`
+	TimeoutSeconds      *int32            `json:"timeoutSeconds,omitempty"`
+	ProbeTimeoutSeconds *int32            `json:"probeTimeoutSeconds,omitempty"`
+	PeriodSeconds       *int32            `json:"periodSeconds,omitempty"`
+	MinPeriodSeconds    *int32            `json:"minPeriodSeconds,omitempty"`
+	MaxPeriodSeconds    *int32            `json:"maxPeriodSeconds,omitempty"`
+	SuccessThreshold    *int32            `json:"successThreshold,omitempty"`
+	FailureThreshold    *int32            `json:"failureThreshold,omitempty"`
+	Evaluation          *EvaluationConfig `json:"evaluation,omitempty"`
+	Cooldown            *CooldownConfig   `json:"cooldown,omitempty"`
+	FailureClasses      []FailureClass    `json:"failureClasses,omitempty"`
+}
+
+// FailureClass classifies why a probe failed.
+type FailureClass string
+
+const (
+	// FailureClassDNS is a failure to resolve the host of the endpoint.
+	FailureClassDNS FailureClass = "DNS"
+	// FailureClassConnect is a TCP connection to the endpoint which was refused or timed out.
+	FailureClassConnect FailureClass = "Connect"
+	// FailureClassTLS is a failed TLS handshake or an invalid certificate of the endpoint.
+	FailureClassTLS FailureClass = "TLS"
+	// FailureClassServerError is an HTTP 5xx response of the endpoint.
+	FailureClassServerError FailureClass = "ServerError"
+	// FailureClassAuth is a request which was not authenticated or not authorized by the endpoint.
+	FailureClassAuth FailureClass = "Auth"
+	// FailureClassThrottled is a request which was throttled by the endpoint.
+	FailureClassThrottled FailureClass = "Throttled"
+	// FailureClassTimeout is a request which timed out on the client side after it was sent.
+	FailureClassTimeout FailureClass = "Timeout"
+	// FailureClassOther is any other failure.
+	FailureClassOther FailureClass = "Other"
+)
+
+// CooldownConfig limits how often the dependants of a probe are scaled down and up again.
+// MinDownSeconds is the minimum time the dependants stay scaled down before they are scaled up again and
+// MinUpSeconds the minimum time after a scale up before they are scaled down again.
+// Once the dependants transitioned more than MaxTransitionsPerHour times within the last hour, the probe is
+// flapping and takes no further scale actions until its external probe kept the same health for
+// StablePeriodSeconds, which defaults to 600. No cooldown applies to the fields which are not set.
+// The transitions and the flapping state are kept across restarts if PersistState is set.
+type CooldownConfig struct {
+	MinDownSeconds        *int32 `json:"minDownSeconds,omitempty"`
+	MinUpSeconds          *int32 `json:"minUpSeconds,omitempty"`
+	MaxTransitionsPerHour *int32 `json:"maxTransitionsPerHour,omitempty"`
+	StablePeriodSeconds   *int32 `json:"stablePeriodSeconds,omitempty"`
 }
 
+// EvaluationConfig evaluates the results of a probe over a sliding window instead of counting consecutive
+// identical results, so that intermittent failures are not hidden by occasional successes.
+// In the Window mode the window holds the last WindowSize results. The probe turns unhealthy once at least
+// EnterFailures of them failed and healthy once the window is full and at most LeaveFailures of them failed.
+// In the Ratio mode the window holds the results of the last WindowSeconds. The probe turns unhealthy once at
+// least EnterFailurePercentage percent of them failed and healthy again once at most LeaveFailurePercentage
+// percent of them failed. It is only evaluated once the window holds at least MinProbes results, so
+// WindowSeconds must be at least MinProbes times MaxPeriodSeconds.
+// In between the thresholds the probe keeps its state.
+type EvaluationConfig struct {
+	Mode                   EvaluationMode `json:"mode,omitempty"`
+	WindowSize             *int32         `json:"windowSize,omitempty"`
+	EnterFailures          *int32         `json:"enterFailures,omitempty"`
+	LeaveFailures          *int32         `json:"leaveFailures,omitempty"`
+	WindowSeconds          *int32         `json:"windowSeconds,omitempty"`
+	EnterFailurePercentage *int32         `json:"enterFailurePercentage,omitempty"`
+	LeaveFailurePercentage *int32         `json:"leaveFailurePercentage,omitempty"`
+	MinProbes              *int32         `json:"minProbes,omitempty"`
+}
+
+// EvaluationMode is the mode of evaluating the results of a probe.
+type EvaluationMode string
+
+const (
+	// EvaluationModeConsecutive evaluates the consecutive identical results. This is the default.
+	EvaluationModeConsecutive EvaluationMode = "Consecutive"
+	// EvaluationModeWindow evaluates the number of failures among the last results.
+	EvaluationModeWindow EvaluationMode = "Window"
+	// EvaluationModeRatio evaluates the ratio of failures among the results within a time window.
+	EvaluationModeRatio EvaluationMode = "Ratio"
+)
+
 // ProbeDetails captures the kubeconfig secret details to probe a Kubernetes apiserver.
 type ProbeDetails struct {
 	KubeconfigSecretName string `json:"kubeconfigSecretName"`
 }
 
 // DependantScaleDetails has the details about the dependant scale sub-resource.
+// ScaleRefDependsOnTimeoutSeconds is the maximum time to wait for the targets the scale sub-resource
+// depends on to reach the desired availability before its scaling is skipped.
+// Custom resources without a scale sub-resource can be scaled by setting ReplicasPath to the field
+// holding their replicas, e.g. `spec.replicas`, which is then patched directly. AvailableReplicasPath,
+// e.g. `status.readyReplicas`, is the field reporting their availability. All the dependant scales of
+// the same kind must use the same paths.
+// ConflictPolicy decides how to deal with other scalers managing the replicas of the target, i.e.
+// HorizontalPodAutoscalers or Hvpas targeting it or an owner of one of the ScalerOwnerKinds.
 type DependantScaleDetails struct {
-	ScaleRef              autoscalingv1.CrossVersionObjectReference   `json:"scaleRef"`
-	Replicas              *int32                                      `json:"replicas"`
-	ScaleUpDelaySeconds   *int32                                      `json:"scaleUpDelaySeconds,omitempty"`
-	ScaleDownDelaySeconds *int32                                      `json:"scaleDownDelaySeconds,omitempty"`
-	ScaleRefDependsOn     []autoscalingv1.CrossVersionObjectReference `json:"scaleRefDependsOn,omitempty"`
+	ScaleRef                        autoscalingv1.CrossVersionObjectReference   `json:"scaleRef"`
+	Replicas                        *int32                                      `json:"replicas"`
+	ScaleUpDelaySeconds             *int32                                      `json:"scaleUpDelaySeconds,omitempty"`
+	ScaleDownDelaySeconds           *int32                                      `json:"scaleDownDelaySeconds,omitempty"`
+	ScaleRefDependsOn               []autoscalingv1.CrossVersionObjectReference `json:"scaleRefDependsOn,omitempty"`
+	ScaleRefDependsOnTimeoutSeconds *int32                                      `json:"scaleRefDependsOnTimeoutSeconds,omitempty"`
+	ReplicasPath                    string                                      `json:"replicasPath,omitempty"`
+	AvailableReplicasPath           string                                      `json:"availableReplicasPath,omitempty"`
+	ConflictPolicy                  ConflictPolicy                              `json:"conflictPolicy,omitempty"`
 }
+
+// ConflictPolicy is the policy for dependant scales whose replicas are managed by other scalers as well.
+type ConflictPolicy string
+
+const (
+	// ConflictPolicySkip skips scaling the target.
+	ConflictPolicySkip ConflictPolicy = "Skip"
+	// ConflictPolicyPause pauses the competing scalers while the target is scaled down and resumes them
+	// when it is scaled up. Hvpas are paused by switching off their horizontal scaling. HorizontalPodAutoscalers
+	// need no pausing as they do not scale targets with zero replicas. Targets owned by other scalers are skipped
+	// as those cannot be paused.
+	ConflictPolicyPause ConflictPolicy = "Pause"
+	// ConflictPolicyProceedAndWarn scales the target anyway and logs a warning. This is the default.
+	ConflictPolicyProceedAndWarn ConflictPolicy = "ProceedAndWarn"
+)
@@ -0,0 +1,182 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+)
+
+const (
+	defaultMaxUnhealthyPercentage = 50
+	defaultWindowSeconds          = 300
+	defaultMinNamespaces          = 5
+
+	reasonCircuitBreakerTripped = "CircuitBreakerTripped"
+	reasonScaleDownSuppressed   = "ScaleDownSuppressed"
+)
+
+// circuitBreaker tracks the external probe results of all the probers and trips if too many namespaces
+// turn unhealthy at once, which rather points to a problem with the network of the dependency-watchdog
+// than with the individual shoots. Scale downs are suppressed while it is tripped. It only resets once
+// few enough namespaces are still unhealthy, however long ago they turned unhealthy.
+// A nil circuitBreaker never trips.
+type circuitBreaker struct {
+	mux                  sync.Mutex
+	maxUnhealthyFraction float64
+	window               time.Duration
+	minNamespaces        int
+	// unhealthySince holds per namespace and probe the time the external probe turned unhealthy
+	// or the zero time if it is not unhealthy.
+	unhealthySince map[string]map[string]time.Time
+	tripped        bool
+	// notified holds the namespaces which were told about a suppressed scale down since the last trip.
+	notified map[string]bool
+	recorder record.EventRecorder
+	now      func() time.Time
+}
+
+// newCircuitBreaker returns a circuit breaker for the given configuration or nil if it is not configured.
+func newCircuitBreaker(config *api.CircuitBreakerConfig, recorder record.EventRecorder) *circuitBreaker {
+	if config == nil {
+		return nil
+	}
+
+	cb := &circuitBreaker{
+		maxUnhealthyFraction: float64(defaultMaxUnhealthyPercentage) / 100,
+		window:               toDuration(config.WindowSeconds, defaultWindowSeconds),
+		minNamespaces:        defaultMinNamespaces,
+		unhealthySince:       make(map[string]map[string]time.Time),
+		recorder:             recorder,
+		now:                  time.Now,
+	}
+	if config.MaxUnhealthyPercentage != nil {
+		cb.maxUnhealthyFraction = float64(*config.MaxUnhealthyPercentage) / 100
+	}
+	if config.MinNamespaces != nil {
+		cb.minNamespaces = int(*config.MinNamespaces)
+	}
+	return cb
+}
+
+// recordExternalResult records whether the external probe of the given namespace and probe is healthy.
+func (cb *circuitBreaker) recordExternalResult(namespace, probe string, healthy bool) {
+	if cb == nil {
+		return
+	}
+
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	if cb.unhealthySince[namespace] == nil {
+		cb.unhealthySince[namespace] = make(map[string]time.Time)
+	}
+	since, ok := cb.unhealthySince[namespace][probe]
+	switch {
+	case healthy:
+		cb.unhealthySince[namespace][probe] = time.Time{}
+	case !ok || since.IsZero():
+		cb.unhealthySince[namespace][probe] = cb.now()
+	}
+	cb.evaluate(namespace)
+}
+
+// forget stops tracking the given namespace and probe.
+func (cb *circuitBreaker) forget(namespace, probe string) {
+	if cb == nil {
+		return
+	}
+
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	delete(cb.unhealthySince[namespace], probe)
+	if len(cb.unhealthySince[namespace]) == 0 {
+		delete(cb.unhealthySince, namespace)
+	}
+	cb.evaluate(namespace)
+}
+
+// allowScaleDown checks if the dependants in the given namespace may be scaled down.
+// If not, an event is recorded for the namespace.
+func (cb *circuitBreaker) allowScaleDown(namespace string) bool {
+	if cb == nil {
+		return true
+	}
+
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	cb.evaluate(namespace)
+	if !cb.tripped {
+		return true
+	}
+
+	dwdSuppressedScaleDownsTotal.With(nil).Inc()
+	if !cb.notified[namespace] {
+		cb.notified[namespace] = true
+		cb.recorder.Eventf(namespaceRef(namespace), corev1.EventTypeWarning, reasonScaleDownSuppressed,
+			"Scale down suppressed as the circuit breaker is tripped by too many externally unhealthy namespaces")
+	}
+	return false
+}
+
+// evaluate trips the circuit breaker if the fraction of namespaces that turned unhealthy within the window
+// exceeds the maximum and resets it once the fraction of namespaces that are still unhealthy no longer does.
+// The namespace is the one whose state changed last. It must be called with the lock held.
+func (cb *circuitBreaker) evaluate(namespace string) {
+	var (
+		total           = len(cb.unhealthySince)
+		unhealthy       int
+		recentUnhealthy int
+		cutoff          = cb.now().Add(-cb.window)
+	)
+	for _, probes := range cb.unhealthySince {
+		first := time.Time{}
+		for _, since := range probes {
+			if !since.IsZero() && (first.IsZero() || since.Before(first)) {
+				first = since
+			}
+		}
+		if first.IsZero() {
+			continue
+		}
+		unhealthy++
+		if first.After(cutoff) {
+			recentUnhealthy++
+		}
+	}
+
+	exceeds := func(n int) bool {
+		return total >= cb.minNamespaces && total > 0 && float64(n)/float64(total) > cb.maxUnhealthyFraction
+	}
+	switch {
+	case !cb.tripped && exceeds(recentUnhealthy):
+		cb.tripped = true
+		cb.notified = make(map[string]bool)
+		klog.Warningf("Circuit breaker tripped as %d out of %d namespaces turned externally unhealthy within %s. Suppressing scale downs.", recentUnhealthy, total, cb.window)
+		dwdCircuitBreakerTripped.With(nil).Set(1)
+		dwdCircuitBreakerTripsTotal.With(nil).Inc()
+		cb.recorder.Eventf(namespaceRef(namespace), corev1.EventTypeWarning, reasonCircuitBreakerTripped,
+			"Circuit breaker tripped as %d out of %d namespaces turned externally unhealthy within %s", recentUnhealthy, total, cb.window)
+	case cb.tripped && !exceeds(unhealthy):
+		cb.tripped = false
+		klog.Infof("Circuit breaker reset as %d out of %d namespaces are still externally unhealthy. Allowing scale downs.", unhealthy, total)
+		dwdCircuitBreakerTripped.With(nil).Set(0)
+	}
+}
+
+func namespaceRef(namespace string) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+		Name:       namespace,
+	}
+}
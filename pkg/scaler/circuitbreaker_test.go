@@ -0,0 +1,107 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+)
+
+var _ = Describe("circuitBreaker", func() {
+	const probe = "kube-apiserver"
+
+	var (
+		now      time.Time
+		recorder *record.FakeRecorder
+		cb       *circuitBreaker
+	)
+
+	BeforeEach(func() {
+		now = time.Now()
+		recorder = record.NewFakeRecorder(10)
+		cb = newCircuitBreaker(&api.CircuitBreakerConfig{
+			MaxUnhealthyPercentage: pointer.Int32Ptr(50),
+			WindowSeconds:          pointer.Int32Ptr(60),
+			MinNamespaces:          pointer.Int32Ptr(4),
+		}, recorder)
+		cb.now = func() time.Time { return now }
+
+		for i := 0; i < 4; i++ {
+			cb.recordExternalResult(fmt.Sprintf("shoot--%d", i), probe, true)
+		}
+	})
+
+	It("should allow scale downs if only a few namespaces are unhealthy", func() {
+		cb.recordExternalResult("shoot--0", probe, false)
+		cb.recordExternalResult("shoot--1", probe, false)
+		Expect(cb.allowScaleDown("shoot--0")).To(BeTrue())
+	})
+
+	It("should suppress scale downs if too many namespaces turn unhealthy within the window", func() {
+		for i := 0; i < 3; i++ {
+			cb.recordExternalResult(fmt.Sprintf("shoot--%d", i), probe, false)
+		}
+		Expect(cb.allowScaleDown("shoot--0")).To(BeFalse())
+		Expect(recorder.Events).To(Receive(ContainSubstring(reasonCircuitBreakerTripped)))
+		Expect(recorder.Events).To(Receive(ContainSubstring(reasonScaleDownSuppressed)))
+
+		By("resetting once the namespaces recover")
+		cb.recordExternalResult("shoot--1", probe, true)
+		cb.recordExternalResult("shoot--2", probe, true)
+		Expect(cb.allowScaleDown("shoot--0")).To(BeTrue())
+	})
+
+	It("should stay tripped while the namespaces are still unhealthy after the window", func() {
+		for i := 0; i < 3; i++ {
+			cb.recordExternalResult(fmt.Sprintf("shoot--%d", i), probe, false)
+		}
+		Expect(cb.allowScaleDown("shoot--0")).To(BeFalse())
+
+		now = now.Add(10 * time.Minute)
+		cb.recordExternalResult("shoot--0", probe, false)
+		Expect(cb.allowScaleDown("shoot--0")).To(BeFalse())
+		Expect(cb.allowScaleDown("shoot--1")).To(BeFalse())
+	})
+
+	It("should record a suppressed scale down once per trip and namespace", func() {
+		for i := 0; i < 3; i++ {
+			cb.recordExternalResult(fmt.Sprintf("shoot--%d", i), probe, false)
+		}
+		Expect(recorder.Events).To(Receive(ContainSubstring(reasonCircuitBreakerTripped)))
+		for i := 0; i < 3; i++ {
+			Expect(cb.allowScaleDown("shoot--0")).To(BeFalse())
+		}
+		Expect(recorder.Events).To(Receive(ContainSubstring(reasonScaleDownSuppressed)))
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	It("should not count namespaces that turned unhealthy before the window", func() {
+		cb.recordExternalResult("shoot--0", probe, false)
+		cb.recordExternalResult("shoot--1", probe, false)
+		now = now.Add(2 * time.Minute)
+		cb.recordExternalResult("shoot--2", probe, false)
+		Expect(cb.allowScaleDown("shoot--2")).To(BeTrue())
+	})
+
+	It("should not trip for less than the minimum number of namespaces", func() {
+		cb.forget("shoot--3", probe)
+		for i := 0; i < 3; i++ {
+			cb.recordExternalResult(fmt.Sprintf("shoot--%d", i), probe, false)
+		}
+		Expect(cb.allowScaleDown("shoot--0")).To(BeTrue())
+	})
+
+	It("should never trip if it is not configured", func() {
+		disabled := newCircuitBreaker(nil, recorder)
+		disabled.recordExternalResult("shoot--0", probe, false)
+		Expect(disabled.allowScaleDown("shoot--0")).To(BeTrue())
+	})
+})
@@ -0,0 +1,286 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	hvpav1alpha1 "github.com/gardener/hvpa-controller/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	autoscalingapi "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	listerautoscalingv1 "k8s.io/client-go/listers/autoscaling/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	kindHorizontalPodAutoscaler = "HorizontalPodAutoscaler"
+	kindHvpa                    = "Hvpa"
+
+	// pausedUpdateModeAnnotationKey marks the Hvpas paused by the dependency-watchdog. It holds the
+	// update mode of their horizontal scaling before they were paused.
+	pausedUpdateModeAnnotationKey = "dependency-watchdog.gardener.cloud/paused-hpa-update-mode"
+)
+
+var (
+	hvpaGroupKind            = schema.GroupKind{Group: hvpav1alpha1.GroupName, Kind: kindHvpa}
+	hvpaGroupVersionResource = hvpav1alpha1.SchemeGroupVersionHvpa.WithResource("hvpas")
+)
+
+// conflict is another scaler managing the replicas of a target.
+type conflict struct {
+	kind string
+	name string
+	// hvpa is set if the scaler is an Hvpa.
+	hvpa *hvpav1alpha1.Hvpa
+}
+
+func (c conflict) String() string {
+	return c.kind + "/" + c.name
+}
+
+// conflictDetector finds the other scalers managing the replicas of a target in the informer caches.
+// A nil conflictDetector finds none.
+type conflictDetector struct {
+	hpaLister  listerautoscalingv1.HorizontalPodAutoscalerLister
+	hvpaLister cache.GenericLister
+	ownerKinds sets.String
+}
+
+// detect returns the HorizontalPodAutoscalers and Hvpas targeting the given target and its owners of the
+// configured scaler kinds.
+func (d *conflictDetector) detect(namespace string, ref autoscalingapi.CrossVersionObjectReference, ownerReferences []metav1.OwnerReference) ([]conflict, error) {
+	if d == nil {
+		return nil, nil
+	}
+
+	var conflicts []conflict
+	for _, o := range ownerReferences {
+		if d.ownerKinds.Has(o.Kind) {
+			conflicts = append(conflicts, conflict{kind: o.Kind, name: o.Name})
+		}
+	}
+
+	if d.hpaLister != nil {
+		hpas, err := d.hpaLister.HorizontalPodAutoscalers(namespace).List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, hpa := range hpas {
+			if t := hpa.Spec.ScaleTargetRef; refersTo(t.APIVersion, t.Kind, t.Name, ref) {
+				conflicts = append(conflicts, conflict{kind: kindHorizontalPodAutoscaler, name: hpa.Name})
+			}
+		}
+	}
+
+	if d.hvpaLister != nil {
+		objs, err := d.hvpaLister.ByNamespace(namespace).List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			u, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				return nil, fmt.Errorf("unexpected object of type %T in hvpa informer cache", obj)
+			}
+			hvpa := &hvpav1alpha1.Hvpa{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, hvpa); err != nil {
+				return nil, err
+			}
+			if t := hvpa.Spec.TargetRef; t != nil && refersTo(t.APIVersion, t.Kind, t.Name, ref) {
+				conflicts = append(conflicts, conflict{kind: kindHvpa, name: hvpa.Name, hvpa: hvpa})
+			}
+		}
+	}
+	return conflicts, nil
+}
+
+// refersTo checks if the target reference of another scaler refers to the target. The API groups are compared but
+// not the versions, as the same target can be referenced by any version of its group, see targetGroupKind.
+func refersTo(apiVersion, kind, name string, ref autoscalingapi.CrossVersionObjectReference) bool {
+	if name != ref.Name {
+		return false
+	}
+	gk, err := targetGroupKind(autoscalingapi.CrossVersionObjectReference{APIVersion: apiVersion, Kind: kind})
+	if err != nil {
+		return false
+	}
+	refGK, err := targetGroupKind(ref)
+	return err == nil && gk == refGK
+}
+
+// hasConflictPolicies checks if a conflict policy is configured for any of the dependant scales.
+func hasConflictPolicies(probeDependantsList *api.ProbeDependantsList) bool {
+	for _, pd := range probeDependantsList.Probes {
+		for _, dsd := range pd.DependantScales {
+			if dsd != nil && dsd.ConflictPolicy != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveConflicts applies the conflict policy of the dependant scale if other scalers manage its replicas.
+// It returns false if the target must not be scaled, and the Hvpas to pause right before it is scaled down,
+// see pauseScalers.
+func (p *prober) resolveConflicts(prefix string, dsd *api.DependantScaleDetails, t *targetInfo, replicas int32) ([]*hvpav1alpha1.Hvpa, bool) {
+	if dsd.ConflictPolicy == "" {
+		return nil, true
+	}
+
+	var ownerReferences []metav1.OwnerReference
+	if t != nil {
+		ownerReferences = t.ownerReferences
+	}
+	conflicts, err := p.conflicts.detect(p.namespace, dsd.ScaleRef, ownerReferences)
+	if err != nil {
+		klog.Errorf("%s: Could not detect conflicting scalers: %s", prefix, err)
+		return nil, dsd.ConflictPolicy == api.ConflictPolicyProceedAndWarn
+	}
+	if len(conflicts) == 0 {
+		return nil, true
+	}
+
+	names := make([]string, 0, len(conflicts))
+	for _, c := range conflicts {
+		names = append(names, c.String())
+	}
+	dwdScaleConflictsTotal.With(prometheus.Labels{labelPolicy: string(dsd.ConflictPolicy)}).Inc()
+
+	switch dsd.ConflictPolicy {
+	case api.ConflictPolicySkip:
+		klog.Warningf("%s: skipped because its replicas are also managed by %s", prefix, strings.Join(names, ", "))
+		return nil, false
+	case api.ConflictPolicyPause:
+		for _, c := range conflicts {
+			if c.kind != kindHorizontalPodAutoscaler && c.hvpa == nil {
+				klog.Warningf("%s: skipped because its replicas are also managed by %s which cannot be paused", prefix, c)
+				return nil, false
+			}
+		}
+		if replicas > 0 {
+			// paused scalers are resumed by resumeScalers
+			return nil, true
+		}
+		var hvpas []*hvpav1alpha1.Hvpa
+		for _, c := range conflicts {
+			// HorizontalPodAutoscalers do not scale targets with zero replicas
+			if c.hvpa != nil {
+				hvpas = append(hvpas, c.hvpa)
+			}
+		}
+		return hvpas, true
+	default:
+		klog.Warningf("%s: proceeding although its replicas are also managed by %s", prefix, strings.Join(names, ", "))
+		return nil, true
+	}
+}
+
+// pauseScalers pauses the Hvpas returned by resolveConflicts. It returns false if the target must not be scaled
+// down as any of them could not be paused.
+func (p *prober) pauseScalers(prefix string, hvpas []*hvpav1alpha1.Hvpa) bool {
+	if len(hvpas) == 0 {
+		return true
+	}
+	names := make([]string, 0, len(hvpas))
+	for _, hvpa := range hvpas {
+		if err := p.pauseHvpa(hvpa); err != nil {
+			klog.Errorf("%s: skipped because %s/%s could not be paused: %s", prefix, kindHvpa, hvpa.Name, err)
+			return false
+		}
+		names = append(names, kindHvpa+"/"+hvpa.Name)
+	}
+	klog.Infof("%s: paused conflicting scalers %s", prefix, strings.Join(names, ", "))
+	return true
+}
+
+// resumeScalers resumes the Hvpas of the target which were paused by the dependency-watchdog. It is called once the
+// target is scaled up again or released.
+func (p *prober) resumeScalers(prefix string, dsd *api.DependantScaleDetails) {
+	if dsd.ConflictPolicy != api.ConflictPolicyPause {
+		return
+	}
+
+	conflicts, err := p.conflicts.detect(p.namespace, dsd.ScaleRef, nil)
+	if err != nil {
+		klog.Errorf("%s: Could not detect paused scalers: %s", prefix, err)
+		return
+	}
+	for _, c := range conflicts {
+		if c.hvpa == nil {
+			continue
+		}
+		if _, ok := c.hvpa.Annotations[pausedUpdateModeAnnotationKey]; !ok {
+			continue
+		}
+		if err := p.resumeHvpa(c.hvpa); err != nil {
+			klog.Errorf("%s: Could not resume %s: %s", prefix, c, err)
+			continue
+		}
+		klog.Infof("%s: resumed %s", prefix, c)
+	}
+}
+
+// pauseHvpa switches off the horizontal scaling of the Hvpa and remembers its previous update mode.
+func (p *prober) pauseHvpa(hvpa *hvpav1alpha1.Hvpa) error {
+	if _, ok := hvpa.Annotations[pausedUpdateModeAnnotationKey]; ok {
+		return nil
+	}
+
+	var updateMode string
+	if up := hvpa.Spec.Hpa.UpdatePolicy; up != nil && up.UpdateMode != nil {
+		updateMode = *up.UpdateMode
+	}
+	return p.patchHvpa(hvpa.Name, updateMode, hvpav1alpha1.UpdateModeOff)
+}
+
+// resumeHvpa restores the update mode of the horizontal scaling of the Hvpa from before it was paused.
+func (p *prober) resumeHvpa(hvpa *hvpav1alpha1.Hvpa) error {
+	var updateMode interface{}
+	if previous := hvpa.Annotations[pausedUpdateModeAnnotationKey]; previous != "" {
+		updateMode = previous
+	}
+	return p.patchHvpa(hvpa.Name, nil, updateMode)
+}
+
+func (p *prober) patchHvpa(name string, annotation, updateMode interface{}) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				pausedUpdateModeAnnotationKey: annotation,
+			},
+		},
+		"spec": map[string]interface{}{
+			"hpa": map[string]interface{}{
+				"updatePolicy": map[string]interface{}{
+					"updateMode": updateMode,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	dwdScaleRequestsTotal.With(prometheus.Labels{labelVerb: verbPatch}).Inc()
+
+	_, err = p.dynamicClient.Resource(hvpaGroupVersionResource).Namespace(p.namespace).Patch(name, types.MergePatchType, data, metav1.PatchOptions{})
+
+	if err != nil && isRateLimited(err) {
+		dwdThrottledScaleRequestsTotal.With(prometheus.Labels{labelVerb: verbPatch}).Inc()
+	}
+	return err
+}
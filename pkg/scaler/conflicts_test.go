@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	hvpav1alpha1 "github.com/gardener/hvpa-controller/api/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/pointer"
+)
+
+var _ = Describe("conflictDetector", func() {
+	const ns = "test"
+
+	var (
+		kcmRef   = autoscalingv1.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: kindDeployment, Name: "kube-controller-manager"}
+		kasRef   = autoscalingv1.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: kindDeployment, Name: "kube-apiserver"}
+		etcdRef  = autoscalingv1.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: kindStatefulSet, Name: "etcd-main"}
+		hvpas    cache.Indexer
+		client   *dynamicfake.FakeDynamicClient
+		detector *conflictDetector
+		p        *prober
+	)
+
+	newHvpa := func(name string, target autoscalingv1.CrossVersionObjectReference, annotations map[string]string) *unstructured.Unstructured {
+		hvpa := &hvpav1alpha1.Hvpa{
+			TypeMeta:   metav1.TypeMeta{APIVersion: hvpav1alpha1.SchemeGroupVersionHvpa.String(), Kind: kindHvpa},
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name, Annotations: annotations},
+			Spec: hvpav1alpha1.HvpaSpec{
+				TargetRef: &autoscalingv2beta1.CrossVersionObjectReference{APIVersion: target.APIVersion, Kind: target.Kind, Name: target.Name},
+				Hpa: hvpav1alpha1.HpaSpec{
+					UpdatePolicy: &hvpav1alpha1.UpdatePolicy{UpdateMode: pointer.StringPtr(hvpav1alpha1.UpdateModeAuto)},
+				},
+			},
+		}
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(hvpa)
+		Expect(err).ToNot(HaveOccurred())
+		return &unstructured.Unstructured{Object: obj}
+	}
+
+	getHvpa := func(name string) *unstructured.Unstructured {
+		u, err := client.Resource(hvpaGroupVersionResource).Namespace(ns).Get(name, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		return u
+	}
+
+	getUpdateMode := func(hvpa *unstructured.Unstructured) string {
+		updateMode, _, err := unstructured.NestedString(hvpa.Object, "spec", "hpa", "updatePolicy", "updateMode")
+		Expect(err).ToNot(HaveOccurred())
+		return updateMode
+	}
+
+	BeforeEach(func() {
+		factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+		hpas := factory.Autoscaling().V1().HorizontalPodAutoscalers()
+		Expect(hpas.Informer().GetIndexer().Add(&autoscalingv1.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "kube-apiserver"},
+			Spec:       autoscalingv1.HorizontalPodAutoscalerSpec{ScaleTargetRef: kasRef},
+		})).To(Succeed())
+
+		kcmHvpa := newHvpa("kube-controller-manager", kcmRef, nil)
+		hvpas = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+		Expect(hvpas.Add(kcmHvpa)).To(Succeed())
+		client = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), kcmHvpa.DeepCopy())
+
+		detector = &conflictDetector{
+			hpaLister:  hpas.Lister(),
+			hvpaLister: cache.NewGenericLister(hvpas, hvpaGroupVersionResource.GroupResource()),
+			ownerKinds: sets.NewString("Etcd"),
+		}
+		p = &prober{
+			namespace:     ns,
+			conflicts:     detector,
+			dynamicClient: client,
+		}
+	})
+
+	It("should detect the scalers managing the replicas of a target", func() {
+		conflicts, err := detector.detect(ns, kasRef, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conflicts).To(ConsistOf(conflict{kind: kindHorizontalPodAutoscaler, name: "kube-apiserver"}))
+
+		conflicts, err = detector.detect(ns, kcmRef, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conflicts).To(HaveLen(1))
+		Expect(conflicts[0].String()).To(Equal("Hvpa/kube-controller-manager"))
+
+		By("comparing the API groups of the targets but not their versions")
+		conflicts, err = detector.detect(ns, autoscalingv1.CrossVersionObjectReference{APIVersion: "apps/v1beta2", Kind: kindDeployment, Name: kasRef.Name}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conflicts).To(HaveLen(1))
+		conflicts, err = detector.detect(ns, autoscalingv1.CrossVersionObjectReference{APIVersion: "example.com/v1", Kind: kindDeployment, Name: kasRef.Name}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conflicts).To(BeEmpty())
+		conflicts, err = detector.detect(ns, autoscalingv1.CrossVersionObjectReference{APIVersion: "example.com/v1", Kind: kindDeployment, Name: kcmRef.Name}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conflicts).To(BeEmpty())
+
+		conflicts, err = detector.detect(ns, etcdRef, []metav1.OwnerReference{{Kind: "Etcd", Name: "etcd-main"}, {Kind: "Other", Name: "other"}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conflicts).To(ConsistOf(conflict{kind: "Etcd", name: "etcd-main"}))
+	})
+
+	It("should apply the conflict policy", func() {
+		resolve := func(dsd *api.DependantScaleDetails, t *targetInfo) bool {
+			_, ok := p.resolveConflicts("test", dsd, t, 0)
+			return ok
+		}
+		dsd := &api.DependantScaleDetails{ScaleRef: kasRef}
+		Expect(resolve(dsd, nil)).To(BeTrue())
+
+		dsd.ConflictPolicy = api.ConflictPolicySkip
+		Expect(resolve(dsd, nil)).To(BeFalse())
+
+		dsd.ConflictPolicy = api.ConflictPolicyProceedAndWarn
+		Expect(resolve(dsd, nil)).To(BeTrue())
+
+		dsd.ConflictPolicy = api.ConflictPolicyPause
+		Expect(resolve(dsd, nil)).To(BeTrue())
+
+		dsd.ScaleRef = etcdRef
+		Expect(resolve(dsd, &targetInfo{
+			ownerReferences: []metav1.OwnerReference{{Kind: "Etcd", Name: "etcd-main"}},
+		})).To(BeFalse())
+	})
+
+	It("should pause and resume hvpas", func() {
+		dsd := &api.DependantScaleDetails{ScaleRef: kcmRef, ConflictPolicy: api.ConflictPolicyPause}
+		pause, ok := p.resolveConflicts("test", dsd, nil, 0)
+		Expect(ok).To(BeTrue())
+		Expect(pause).To(HaveLen(1))
+		Expect(getHvpa("kube-controller-manager").GetAnnotations()).ToNot(HaveKey(pausedUpdateModeAnnotationKey))
+
+		Expect(p.pauseScalers("test", pause)).To(BeTrue())
+		paused := getHvpa("kube-controller-manager")
+		Expect(paused.GetAnnotations()).To(HaveKeyWithValue(pausedUpdateModeAnnotationKey, hvpav1alpha1.UpdateModeAuto))
+		Expect(getUpdateMode(paused)).To(Equal(hvpav1alpha1.UpdateModeOff))
+
+		Expect(hvpas.Update(paused)).To(Succeed())
+		p.resumeScalers("test", dsd)
+
+		resumed := getHvpa("kube-controller-manager")
+		Expect(resumed.GetAnnotations()).ToNot(HaveKey(pausedUpdateModeAnnotationKey))
+		Expect(getUpdateMode(resumed)).To(Equal(hvpav1alpha1.UpdateModeAuto))
+	})
+})
@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog"
+)
+
+const (
+	defaultStablePeriodSeconds = 600
+	transitionsWindow          = time.Hour
+)
+
+// cooldown damps the scale transitions of the dependants of a prober as configured by an api.CooldownConfig.
+// A transition is a scale action other than the last one. Once there were more transitions within the last hour
+// than allowed, the cooldown latches into flapping and suppresses all transitions until the external probe kept
+// the same health for the stable period. A nil cooldown allows every transition.
+type cooldown struct {
+	minDown        time.Duration
+	minUp          time.Duration
+	maxTransitions int
+	stablePeriod   time.Duration
+	transitions    []time.Time
+	flappingSince  time.Time
+	wantedAction   string
+	wantedSince    time.Time
+}
+
+// newCooldown returns the cooldown of the config or nil if there is none.
+func newCooldown(config *api.CooldownConfig) *cooldown {
+	if config == nil {
+		return nil
+	}
+	return &cooldown{
+		minDown:        toDuration(config.MinDownSeconds, 0),
+		minUp:          toDuration(config.MinUpSeconds, 0),
+		maxTransitions: int(int32Or(config.MaxTransitionsPerHour, 0)),
+		stablePeriod:   toDuration(config.StablePeriodSeconds, defaultStablePeriodSeconds),
+	}
+}
+
+// validateCooldown checks that the cooldowns are not negative.
+func validateCooldown(config *api.CooldownConfig) error {
+	c := newCooldown(config)
+	if c == nil {
+		return nil
+	}
+	if c.minDown < 0 || c.minUp < 0 || c.maxTransitions < 0 || c.stablePeriod < 0 {
+		return fmt.Errorf("the cooldowns must not be negative")
+	}
+	return nil
+}
+
+// observe records the scale action wanted by the current health of the external probe.
+func (c *cooldown) observe(action string, now time.Time) {
+	if c == nil {
+		return
+	}
+	if c.wantedAction != action {
+		c.wantedAction = action
+		c.wantedSince = now
+	}
+	c.flapping(now)
+}
+
+// flapping returns whether the cooldown is latched into flapping. It clears the latch once the wanted action
+// was stable for the stable period.
+func (c *cooldown) flapping(now time.Time) bool {
+	if c.flappingSince.IsZero() {
+		return false
+	}
+	stableSince := c.wantedSince
+	if c.flappingSince.After(stableSince) {
+		stableSince = c.flappingSince
+	}
+	if now.Sub(stableSince) < c.stablePeriod {
+		return true
+	}
+	c.setFlapping(time.Time{})
+	c.transitions = nil
+	return false
+}
+
+// suppressReason returns the reason and a message why the transition from the last action to the given action
+// must be suppressed or empty strings if it is allowed. Repeating the last action is always allowed.
+func (c *cooldown) suppressReason(action, lastAction string, lastActionTime, now time.Time) (string, string) {
+	if c == nil || lastAction == "" || lastAction == action {
+		return "", ""
+	}
+
+	if c.flapping(now) {
+		return reasonFlapping, fmt.Sprintf("the dependants are flapping since %s", c.flappingSince.Format(time.RFC3339))
+	}
+
+	switch {
+	case action == actionScaleUp && now.Sub(lastActionTime) < c.minDown:
+		return reasonMinDown, fmt.Sprintf("the dependants are down only since %s", lastActionTime.Format(time.RFC3339))
+	case action == actionScaleDown && now.Sub(lastActionTime) < c.minUp:
+		return reasonMinUp, fmt.Sprintf("the dependants are up only since %s", lastActionTime.Format(time.RFC3339))
+	}
+	return "", ""
+}
+
+// recordTransition records a transition of the dependants and latches into flapping once there were too many.
+// It returns whether it latched.
+func (c *cooldown) recordTransition(now time.Time) bool {
+	if c == nil || c.maxTransitions == 0 {
+		return false
+	}
+	i := 0
+	for i < len(c.transitions) && now.Sub(c.transitions[i]) >= transitionsWindow {
+		i++
+	}
+	c.transitions = append(c.transitions[i:], now)
+	if len(c.transitions) <= c.maxTransitions || !c.flappingSince.IsZero() {
+		return false
+	}
+	c.setFlapping(now)
+	return true
+}
+
+// setFlapping latches into or clears the flapping state and keeps the number of flapping probes up to date.
+func (c *cooldown) setFlapping(since time.Time) {
+	switch {
+	case c.flappingSince.IsZero() && !since.IsZero():
+		dwdFlappingProbes.With(nil).Inc()
+	case !c.flappingSince.IsZero() && since.IsZero():
+		dwdFlappingProbes.With(nil).Dec()
+	}
+	c.flappingSince = since
+}
+
+// release clears the flapping state once the prober stopped so that it is no longer counted.
+func (c *cooldown) release() {
+	if c == nil {
+		return
+	}
+	c.setFlapping(time.Time{})
+}
+
+// cooldownAllows checks whether the cooldown allows the dependants to transition to the action.
+func (p *prober) cooldownAllows(action string) bool {
+	reason, msg := p.cooldown.suppressReason(action, p.lastAction, p.lastActionTime, time.Now())
+	if reason == "" {
+		return true
+	}
+	dwdSuppressedScaleTransitionsTotal.With(prometheus.Labels{labelReason: reason}).Inc()
+	klog.Warningf("%s/%s: skipping the %s as %s.", p.probeDeps.Name, p.namespace, action, msg)
+	return false
+}
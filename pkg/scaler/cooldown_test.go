@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"time"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/pointer"
+)
+
+var _ = Describe("cooldown", func() {
+	var now time.Time
+
+	BeforeEach(func() {
+		now = time.Now()
+	})
+
+	It("should allow every transition without a config", func() {
+		c := newCooldown(nil)
+		reason, _ := c.suppressReason(actionScaleUp, actionScaleDown, now, now)
+		Expect(reason).To(BeEmpty())
+		Expect(c.recordTransition(now)).To(BeFalse())
+	})
+
+	DescribeTable("should keep the dependants in their state for the minimum time", func(action, lastAction string, since time.Duration, expectedReason string) {
+		c := newCooldown(&api.CooldownConfig{MinDownSeconds: pointer.Int32Ptr(300), MinUpSeconds: pointer.Int32Ptr(60)})
+		reason, _ := c.suppressReason(action, lastAction, now.Add(-since), now)
+		Expect(reason).To(Equal(expectedReason))
+	},
+		Entry("scale up too early", actionScaleUp, actionScaleDown, 2*time.Minute, reasonMinDown),
+		Entry("scale up after the minimum down time", actionScaleUp, actionScaleDown, 5*time.Minute, ""),
+		Entry("scale down too early", actionScaleDown, actionScaleUp, 30*time.Second, reasonMinUp),
+		Entry("scale down after the minimum up time", actionScaleDown, actionScaleUp, 2*time.Minute, ""),
+		Entry("repeated scale up", actionScaleUp, actionScaleUp, time.Duration(0), ""),
+		Entry("first action", actionScaleDown, "", time.Duration(0), ""),
+	)
+
+	It("should latch into flapping until the wanted action is stable", func() {
+		c := newCooldown(&api.CooldownConfig{MaxTransitionsPerHour: pointer.Int32Ptr(2), StablePeriodSeconds: pointer.Int32Ptr(600)})
+		defer c.release()
+
+		Expect(c.recordTransition(now.Add(-70 * time.Minute))).To(BeFalse())
+		Expect(c.recordTransition(now.Add(-20 * time.Minute))).To(BeFalse())
+		Expect(c.recordTransition(now.Add(-10 * time.Minute))).To(BeFalse())
+		Expect(c.recordTransition(now)).To(BeTrue())
+
+		c.observe(actionScaleUp, now)
+		reason, _ := c.suppressReason(actionScaleUp, actionScaleDown, now, now)
+		Expect(reason).To(Equal(reasonFlapping))
+
+		now = now.Add(5 * time.Minute)
+		c.observe(actionScaleDown, now)
+		now = now.Add(9 * time.Minute)
+		c.observe(actionScaleDown, now)
+		reason, _ = c.suppressReason(actionScaleDown, actionScaleUp, now.Add(-time.Hour), now)
+		Expect(reason).To(Equal(reasonFlapping))
+
+		now = now.Add(time.Minute)
+		c.observe(actionScaleDown, now)
+		Expect(c.flappingSince.IsZero()).To(BeTrue())
+		Expect(c.transitions).To(BeEmpty())
+		reason, _ = c.suppressReason(actionScaleDown, actionScaleUp, now.Add(-time.Hour), now)
+		Expect(reason).To(BeEmpty())
+	})
+
+	It("should keep the flapping state across restarts", func() {
+		p := &prober{
+			namespace: "shoot--foo",
+			probeDeps: &api.ProbeDependants{Name: "kube-apiserver"},
+			cooldown:  newCooldown(&api.CooldownConfig{MaxTransitionsPerHour: pointer.Int32Ptr(1)}),
+		}
+		defer p.cooldown.release()
+
+		p.recordAction(actionScaleUp)
+		p.recordAction(actionScaleDown)
+		p.recordAction(actionScaleUp)
+		state := p.snapshotState()
+		Expect(state.FlappingSince).NotTo(BeNil())
+		Expect(state.FlappingSince.Time).To(Equal(p.cooldown.flappingSince))
+	})
+})
+
+var _ = DescribeTable("validateCooldown", func(config *api.CooldownConfig, expectErr bool) {
+	err := validateCooldown(config)
+	if expectErr {
+		Expect(err).To(HaveOccurred())
+	} else {
+		Expect(err).NotTo(HaveOccurred())
+	}
+},
+	Entry("no cooldown", nil, false),
+	Entry("all cooldowns", &api.CooldownConfig{MinDownSeconds: pointer.Int32Ptr(300), MinUpSeconds: pointer.Int32Ptr(60), MaxTransitionsPerHour: pointer.Int32Ptr(4)}, false),
+	Entry("negative minimum down time", &api.CooldownConfig{MinDownSeconds: pointer.Int32Ptr(-1)}, true),
+	Entry("negative number of transitions", &api.CooldownConfig{MaxTransitionsPerHour: pointer.Int32Ptr(-1)}, true),
+)
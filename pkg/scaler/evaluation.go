@@ -0,0 +1,183 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+)
+
+const (
+	defaultEvaluationWindowSize      = 10
+	defaultEvaluationEnterFailures   = 5
+	defaultEvaluationLeaveFailures   = 1
+	defaultEvaluationWindowSeconds   = 120
+	defaultEvaluationEnterPercentage = 50
+	defaultEvaluationLeavePercentage = 10
+	defaultEvaluationMinProbes       = 5
+	maxRatioWindowResults            = 1000
+)
+
+// healthState is the health of a probe latched by the windowEvaluator.
+type healthState int
+
+const (
+	healthUnknown healthState = iota
+	healthHealthy
+	healthUnhealthy
+)
+
+// windowedResult is a single result in the sliding window of a probe.
+type windowedResult struct {
+	at     time.Time
+	failed bool
+}
+
+// windowEvaluator evaluates the results of a probe over a sliding window as configured by an
+// api.EvaluationConfig. It latches the health state of the probe in between its enter and leave thresholds.
+// A nil windowEvaluator leaves the evaluation to the consecutive results.
+type windowEvaluator struct {
+	byRatio   bool
+	size      int
+	window    time.Duration
+	enter     int32
+	leave     int32
+	minProbes int
+}
+
+// newWindowEvaluator returns the windowEvaluator of the config or nil for the consecutive evaluation.
+func newWindowEvaluator(config *api.EvaluationConfig) *windowEvaluator {
+	if config == nil {
+		return nil
+	}
+	switch config.Mode {
+	case api.EvaluationModeWindow:
+		return &windowEvaluator{
+			size:  int(int32Or(config.WindowSize, defaultEvaluationWindowSize)),
+			enter: int32Or(config.EnterFailures, defaultEvaluationEnterFailures),
+			leave: int32Or(config.LeaveFailures, defaultEvaluationLeaveFailures),
+		}
+	case api.EvaluationModeRatio:
+		return &windowEvaluator{
+			byRatio:   true,
+			size:      maxRatioWindowResults,
+			window:    toDuration(config.WindowSeconds, defaultEvaluationWindowSeconds),
+			enter:     int32Or(config.EnterFailurePercentage, defaultEvaluationEnterPercentage),
+			leave:     int32Or(config.LeaveFailurePercentage, defaultEvaluationLeavePercentage),
+			minProbes: int(int32Or(config.MinProbes, defaultEvaluationMinProbes)),
+		}
+	default:
+		return nil
+	}
+}
+
+// validateEvaluation checks that the mode of the evaluation is known, that its thresholds are consistent and
+// that the time window of the Ratio mode holds enough results even when probing at the maximum period.
+func validateEvaluation(config *api.EvaluationConfig, maxPeriod time.Duration) error {
+	if config == nil {
+		return nil
+	}
+	switch config.Mode {
+	case "", api.EvaluationModeConsecutive:
+		return nil
+	case api.EvaluationModeWindow, api.EvaluationModeRatio:
+	default:
+		return fmt.Errorf("unknown mode %q", config.Mode)
+	}
+
+	e := newWindowEvaluator(config)
+	if e.size <= 0 || e.window < 0 || e.minProbes < 0 {
+		return fmt.Errorf("the window must not be empty")
+	}
+	if e.leave < 0 || e.leave >= e.enter {
+		return fmt.Errorf("the threshold for leaving the unhealthy state %d must be lower than the one for entering it %d", e.leave, e.enter)
+	}
+	if !e.byRatio && int(e.enter) > e.size {
+		return fmt.Errorf("enterFailures %d must not exceed windowSize %d", e.enter, e.size)
+	}
+	if e.byRatio && (e.window == 0 || e.enter > 100) {
+		return fmt.Errorf("windowSeconds must be positive and enterFailurePercentage %d must not exceed 100", e.enter)
+	}
+	if e.byRatio && maxPeriod*time.Duration(e.minProbes) > e.window {
+		return fmt.Errorf("windowSeconds %s cannot hold minProbes %d results at maxPeriodSeconds %s", e.window, e.minProbes, maxPeriod)
+	}
+	return nil
+}
+
+// unsettled checks whether the latest results of the probe disagree with its latched state, i.e. whether a
+// healthy probe has failures in its window or an unhealthy probe just succeeded, so that it may turn soon.
+func (e *windowEvaluator) unsettled(pr *probeResult) bool {
+	if e == nil {
+		return false
+	}
+	switch pr.state {
+	case healthHealthy:
+		for _, r := range pr.window {
+			if r.failed {
+				return true
+			}
+		}
+		return false
+	case healthUnhealthy:
+		return pr.lastError == nil
+	default:
+		return true
+	}
+}
+
+// record adds the result to the window of the probe and updates its health state.
+func (e *windowEvaluator) record(pr *probeResult, failed bool, now time.Time) {
+	if e == nil {
+		return
+	}
+	pr.window = append(pr.window, windowedResult{at: now, failed: failed})
+	if len(pr.window) > e.size {
+		pr.window = pr.window[len(pr.window)-e.size:]
+	}
+	if e.byRatio {
+		i := 0
+		for i < len(pr.window) && now.Sub(pr.window[i].at) > e.window {
+			i++
+		}
+		pr.window = pr.window[i:]
+	}
+
+	failures := 0
+	for _, r := range pr.window {
+		if r.failed {
+			failures++
+		}
+	}
+
+	if e.byRatio {
+		if len(pr.window) < e.minProbes {
+			return
+		}
+		percentage := int32(failures * 100 / len(pr.window))
+		switch {
+		case percentage >= e.enter:
+			pr.state = healthUnhealthy
+		case percentage <= e.leave:
+			pr.state = healthHealthy
+		}
+		return
+	}
+
+	switch {
+	case int32(failures) >= e.enter:
+		pr.state = healthUnhealthy
+	case len(pr.window) == e.size && int32(failures) <= e.leave:
+		pr.state = healthHealthy
+	}
+}
+
+func int32Or(v *int32, def int32) int32 {
+	if v == nil {
+		return def
+	}
+	return *v
+}
@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/pointer"
+)
+
+var _ = Describe("windowEvaluator", func() {
+	var (
+		now time.Time
+		pr  probeResult
+	)
+
+	BeforeEach(func() {
+		now = time.Now()
+		pr = probeResult{}
+	})
+
+	record := func(e *windowEvaluator, results ...bool) {
+		for _, failed := range results {
+			now = now.Add(10 * time.Second)
+			e.record(&pr, failed, now)
+		}
+	}
+
+	It("should latch the state between the thresholds of the window", func() {
+		e := newWindowEvaluator(&api.EvaluationConfig{
+			Mode:          api.EvaluationModeWindow,
+			WindowSize:    pointer.Int32Ptr(5),
+			EnterFailures: pointer.Int32Ptr(3),
+			LeaveFailures: pointer.Int32Ptr(0),
+		})
+
+		record(e, false, false, false, false)
+		Expect(pr.state).To(Equal(healthUnknown))
+		record(e, false)
+		Expect(pr.state).To(Equal(healthHealthy))
+
+		record(e, true, false, true)
+		Expect(pr.state).To(Equal(healthHealthy))
+		record(e, true)
+		Expect(pr.state).To(Equal(healthUnhealthy))
+
+		record(e, false, false, false, false)
+		Expect(pr.state).To(Equal(healthUnhealthy))
+		record(e, false)
+		Expect(pr.state).To(Equal(healthHealthy))
+	})
+
+	It("should evaluate the ratio of the failures within the time window", func() {
+		e := newWindowEvaluator(&api.EvaluationConfig{
+			Mode:                   api.EvaluationModeRatio,
+			WindowSeconds:          pointer.Int32Ptr(60),
+			EnterFailurePercentage: pointer.Int32Ptr(50),
+			LeaveFailurePercentage: pointer.Int32Ptr(20),
+			MinProbes:              pointer.Int32Ptr(4),
+		})
+
+		record(e, true, true, true)
+		Expect(pr.state).To(Equal(healthUnknown))
+		record(e, false)
+		Expect(pr.state).To(Equal(healthUnhealthy))
+
+		record(e, false, false, false)
+		Expect(pr.state).To(Equal(healthUnhealthy))
+		record(e, false, false, false)
+		Expect(pr.window).To(HaveLen(7))
+		Expect(pr.state).To(Equal(healthHealthy))
+	})
+
+	It("should be used for the health of the probes", func() {
+		p := &prober{
+			successThreshold: defaultSuccessThreshold,
+			failureThreshold: defaultFailureThreshold,
+			evaluator:        newWindowEvaluator(&api.EvaluationConfig{Mode: api.EvaluationModeWindow}),
+		}
+		for i := 0; i < defaultEvaluationEnterFailures; i++ {
+			p.handleError(&p.externalResult, errors.New("connection refused"), "test")
+			if i == 0 {
+				p.handleError(&p.externalResult, nil, "test")
+			}
+		}
+		Expect(p.externalResult.resultRun).To(BeNumerically("<", defaultEvaluationEnterFailures))
+		Expect(p.isUnhealthy(&p.externalResult)).To(BeTrue())
+		Expect(p.isHealthy(&p.externalResult)).To(BeFalse())
+	})
+
+	It("should need attention while the window disagrees with the latched state", func() {
+		p := &prober{
+			successThreshold: defaultSuccessThreshold,
+			failureThreshold: defaultFailureThreshold,
+			evaluator:        newWindowEvaluator(&api.EvaluationConfig{Mode: api.EvaluationModeWindow}),
+		}
+		for i := 0; i < defaultEvaluationWindowSize; i++ {
+			p.handleError(&p.internalResult, nil, "test")
+			p.handleError(&p.externalResult, nil, "test")
+		}
+		Expect(p.isHealthy(&p.externalResult)).To(BeTrue())
+		Expect(p.needsAttention()).To(BeFalse())
+
+		p.handleError(&p.externalResult, errors.New("connection refused"), "test")
+		Expect(p.isHealthy(&p.externalResult)).To(BeTrue())
+		Expect(p.needsAttention()).To(BeTrue())
+	})
+
+	It("should keep the latched state across restarts", func() {
+		pr = probeResult{lastError: errors.New("connection refused"), resultRun: 1, state: healthUnhealthy}
+		restored := newPersistedResult(&pr).toProbeResult()
+		Expect(restored.state).To(Equal(healthUnhealthy))
+		Expect(restored.window).To(BeEmpty())
+	})
+})
+
+var _ = DescribeTable("validateEvaluation", func(config *api.EvaluationConfig, expectErr bool) {
+	err := validateEvaluation(config, defaultPeriodSeconds*time.Second)
+	if expectErr {
+		Expect(err).To(HaveOccurred())
+	} else {
+		Expect(err).NotTo(HaveOccurred())
+	}
+},
+	Entry("no evaluation", nil, false),
+	Entry("consecutive", &api.EvaluationConfig{Mode: api.EvaluationModeConsecutive}, false),
+	Entry("window with defaults", &api.EvaluationConfig{Mode: api.EvaluationModeWindow}, false),
+	Entry("ratio with defaults", &api.EvaluationConfig{Mode: api.EvaluationModeRatio}, false),
+	Entry("unknown mode", &api.EvaluationConfig{Mode: "Sometimes"}, true),
+	Entry("more failures than the window holds", &api.EvaluationConfig{Mode: api.EvaluationModeWindow, WindowSize: pointer.Int32Ptr(3)}, true),
+	Entry("leave threshold not lower than enter threshold", &api.EvaluationConfig{Mode: api.EvaluationModeWindow, EnterFailures: pointer.Int32Ptr(2), LeaveFailures: pointer.Int32Ptr(2)}, true),
+	Entry("percentage above 100", &api.EvaluationConfig{Mode: api.EvaluationModeRatio, EnterFailurePercentage: pointer.Int32Ptr(120)}, true),
+	Entry("empty time window", &api.EvaluationConfig{Mode: api.EvaluationModeRatio, WindowSeconds: pointer.Int32Ptr(0)}, true),
+	Entry("time window too short for the minimum number of probes", &api.EvaluationConfig{Mode: api.EvaluationModeRatio, WindowSeconds: pointer.Int32Ptr(40)}, true),
+)
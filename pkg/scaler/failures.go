@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// failureClasses are all known failure classes.
+var failureClasses = []api.FailureClass{
+	api.FailureClassDNS,
+	api.FailureClassConnect,
+	api.FailureClassTLS,
+	api.FailureClassServerError,
+	api.FailureClassAuth,
+	api.FailureClassThrottled,
+	api.FailureClassTimeout,
+	api.FailureClassOther,
+}
+
+// classifyProbeError returns the class of the failure of a probe or an empty class if it did not fail.
+// The errors are classified by their type where possible and by their message otherwise, as not every layer
+// between the probe and the network keeps the original errors.
+func classifyProbeError(err error) api.FailureClass {
+	if err == nil {
+		return ""
+	}
+
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		code := status.Status().Code
+		switch {
+		case code == http.StatusTooManyRequests:
+			return api.FailureClassThrottled
+		case code == http.StatusUnauthorized || code == http.StatusForbidden:
+			return api.FailureClassAuth
+		case code >= http.StatusInternalServerError:
+			return api.FailureClassServerError
+		default:
+			return api.FailureClassOther
+		}
+	}
+
+	var (
+		dnsErr       *net.DNSError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+		recordErr    tls.RecordHeaderError
+		opErr        *net.OpError
+		netErr       net.Error
+	)
+	msg := err.Error()
+	switch {
+	case errors.As(err, &dnsErr) || strings.Contains(msg, "no such host"):
+		return api.FailureClassDNS
+	case errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) || errors.As(err, &recordErr),
+		strings.Contains(msg, "x509: ") || strings.Contains(msg, "tls: ") || strings.Contains(msg, "TLS handshake"):
+		return api.FailureClassTLS
+	case errors.As(err, &opErr) && opErr.Op == "dial", strings.Contains(msg, "connection refused"):
+		return api.FailureClassConnect
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()),
+		strings.Contains(msg, "Client.Timeout exceeded") || strings.Contains(msg, "i/o timeout"):
+		return api.FailureClassTimeout
+	default:
+		return api.FailureClassOther
+	}
+}
+
+// countedFailureClassesOf returns the failure classes which count towards the failure threshold of the probe
+// or nil for the default classes.
+func countedFailureClassesOf(probe *api.ProbeConfig) map[api.FailureClass]bool {
+	if len(probe.FailureClasses) == 0 {
+		return nil
+	}
+	counted := make(map[api.FailureClass]bool)
+	for _, class := range probe.FailureClasses {
+		counted[class] = true
+	}
+	return counted
+}
+
+// countsFailure returns whether failures of the class count towards the failure threshold of the probe.
+// By default all classes but throttling count, as a throttled request does not mean that the endpoint is down.
+func (p *prober) countsFailure(class api.FailureClass) bool {
+	if p.countedFailureClasses == nil {
+		return class != api.FailureClassThrottled
+	}
+	return p.countedFailureClasses[class]
+}
+
+// validateFailureClasses checks that the failure classes of the probe are known.
+func validateFailureClasses(probe *api.ProbeConfig) error {
+	for _, class := range probe.FailureClasses {
+		known := false
+		for _, c := range failureClasses {
+			known = known || class == c
+		}
+		if !known {
+			return fmt.Errorf("unknown failure class %q", class)
+		}
+	}
+	return nil
+}
+
+// countProbeFailure counts the failure of the internal or external probe by its class. It is only called for
+// probes which actually ran, not for the results shared by the probeCache, so that every failure is counted once.
+func countProbeFailure(probeType string, err error) {
+	if err == nil {
+		return
+	}
+	dwdProbeFailuresTotal.With(prometheus.Labels{labelProbeType: probeType, labelClass: string(classifyProbeError(err))}).Inc()
+}
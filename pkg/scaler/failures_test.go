@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// timeoutError is a net.Error which timed out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "net/http: request canceled" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func urlError(err error) error {
+	return &url.Error{Op: "Get", URL: "https://api.foo.example.com/version", Err: err}
+}
+
+var _ = DescribeTable("classifyProbeError", func(err error, expected api.FailureClass) {
+	Expect(classifyProbeError(err)).To(Equal(expected))
+},
+	Entry("no failure", nil, api.FailureClass("")),
+	Entry("unresolvable host", urlError(&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "api.foo.example.com"}}), api.FailureClassDNS),
+	Entry("refused connection", urlError(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), api.FailureClassConnect),
+	Entry("connect timeout", urlError(&net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}), api.FailureClassConnect),
+	Entry("unknown certificate authority", urlError(x509.UnknownAuthorityError{}), api.FailureClassTLS),
+	Entry("failed handshake", urlError(errors.New("remote error: tls: bad certificate")), api.FailureClassTLS),
+	Entry("server error", apierrors.NewInternalError(errors.New("etcdserver: request timed out")), api.FailureClassServerError),
+	Entry("unavailable server", apierrors.NewServiceUnavailable("the server is shutting down"), api.FailureClassServerError),
+	Entry("unauthorized", apierrors.NewUnauthorized("invalid token"), api.FailureClassAuth),
+	Entry("forbidden", apierrors.NewForbidden(schema.GroupResource{}, "version", errors.New("denied")), api.FailureClassAuth),
+	Entry("throttled", apierrors.NewTooManyRequests("slow down", 1), api.FailureClassThrottled),
+	Entry("client timeout", urlError(timeoutError{}), api.FailureClassTimeout),
+	Entry("deadline exceeded", fmt.Errorf("probe failed: %w", context.DeadlineExceeded), api.FailureClassTimeout),
+	Entry("stringified timeout", errors.New("Get https://api.foo.example.com/version: net/http: request canceled (Client.Timeout exceeded while awaiting headers)"), api.FailureClassTimeout),
+	Entry("other", errors.New("unexpected EOF"), api.FailureClassOther),
+)
+
+var _ = Describe("failure classes", func() {
+	It("should count all classes but throttling by default", func() {
+		p := &prober{countedFailureClasses: countedFailureClassesOf(&api.ProbeConfig{})}
+		for _, class := range failureClasses {
+			Expect(p.countsFailure(class)).To(Equal(class != api.FailureClassThrottled), string(class))
+		}
+	})
+
+	It("should only count the configured classes", func() {
+		p := &prober{
+			successThreshold:      defaultSuccessThreshold,
+			failureThreshold:      defaultFailureThreshold,
+			countedFailureClasses: countedFailureClassesOf(&api.ProbeConfig{FailureClasses: []api.FailureClass{api.FailureClassConnect, api.FailureClassTimeout}}),
+		}
+		p.handleError(&p.externalResult, urlError(x509.UnknownAuthorityError{}), "test")
+		Expect(p.externalResult.lastError).NotTo(HaveOccurred())
+		Expect(p.externalResult.resultRun).To(BeZero())
+
+		p.handleError(&p.externalResult, urlError(timeoutError{}), "test")
+		Expect(p.externalResult.lastError).To(HaveOccurred())
+		Expect(p.externalResult.failureClass).To(Equal(api.FailureClassTimeout))
+		Expect(p.externalResult.resultRun).To(Equal(int32(1)))
+
+		restored := newPersistedResult(&p.externalResult).toProbeResult()
+		Expect(restored.failureClass).To(Equal(api.FailureClassTimeout))
+	})
+
+	It("should reject unknown classes", func() {
+		Expect(validateFailureClasses(&api.ProbeConfig{FailureClasses: []api.FailureClass{api.FailureClassTLS}})).To(Succeed())
+		Expect(validateFailureClasses(&api.ProbeConfig{FailureClasses: []api.FailureClass{"Cosmic"}})).NotTo(Succeed())
+	})
+})
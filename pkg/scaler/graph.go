@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	autoscalingapi "k8s.io/api/autoscaling/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// scaleGraph is the dependency graph of the dependant scales of a probe.
+// The nodes are the dependant scales and there is an edge from a node to every other node
+// that is referenced in its ScaleRefDependsOn. References to targets that are not dependant
+// scales themselves are not part of the graph.
+type scaleGraph struct {
+	nodes []*api.DependantScaleDetails
+	// dependencies holds, for every node, the indices of the nodes it depends on.
+	dependencies [][]int
+	// dependants holds, for every node, the indices of the nodes that depend on it.
+	dependants [][]int
+}
+
+// newScaleGraph builds the dependency graph of the given dependant scales.
+// It returns an error if the dependencies contain a cycle.
+func newScaleGraph(dependantScales []*api.DependantScaleDetails) (*scaleGraph, error) {
+	g := &scaleGraph{}
+	index := make(map[string]int)
+	for _, dsd := range dependantScales {
+		if dsd == nil {
+			continue
+		}
+		key := scaleRefKey(dsd.ScaleRef)
+		if _, ok := index[key]; ok {
+			return nil, fmt.Errorf("duplicate dependant scale %s", key)
+		}
+		index[key] = len(g.nodes)
+		g.nodes = append(g.nodes, dsd)
+	}
+
+	g.dependencies = make([][]int, len(g.nodes))
+	g.dependants = make([][]int, len(g.nodes))
+	for i, dsd := range g.nodes {
+		for _, ref := range dsd.ScaleRefDependsOn {
+			j, ok := index[scaleRefKey(ref)]
+			if !ok {
+				continue
+			}
+			g.dependencies[i] = append(g.dependencies[i], j)
+			g.dependants[j] = append(g.dependants[j], i)
+		}
+	}
+
+	if cycle := g.findCycle(); cycle != nil {
+		var names []string
+		for _, i := range cycle {
+			names = append(names, scaleRefKey(g.nodes[i].ScaleRef))
+		}
+		return nil, fmt.Errorf("cyclic dependency between dependant scales: %s", strings.Join(names, " -> "))
+	}
+	return g, nil
+}
+
+// findCycle returns the indices of the nodes forming a cycle or nil if the graph is acyclic.
+func (g *scaleGraph) findCycle() []int {
+	const (
+		unvisited = iota
+		inProgress
+		visited
+	)
+	var (
+		state = make([]int, len(g.nodes))
+		path  []int
+		visit func(i int) []int
+	)
+	visit = func(i int) []int {
+		state[i] = inProgress
+		path = append(path, i)
+		for _, j := range g.dependencies[i] {
+			switch state[j] {
+			case inProgress:
+				for k := range path {
+					if path[k] == j {
+						return append(append([]int{}, path[k:]...), j)
+					}
+				}
+			case unvisited:
+				if cycle := visit(j); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		return nil
+	}
+
+	for i := range g.nodes {
+		if state[i] == unvisited {
+			if cycle := visit(i); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// predecessors returns the indices of the nodes that have to be scaled before the given node.
+// While scaling up, these are the nodes it depends on. While scaling down, the order is reversed
+// and these are the nodes that depend on it.
+func (g *scaleGraph) predecessors(i int, scaleUp bool) []int {
+	if scaleUp {
+		return g.dependencies[i]
+	}
+	return g.dependants[i]
+}
+
+// scaleRefKey identifies the target of the reference by its group, kind and name. The version is left out as
+// it does not change the target, and deprecated groups are normalized, see targetGroupKind.
+func scaleRefKey(ref autoscalingapi.CrossVersionObjectReference) string {
+	gk, err := targetGroupKind(ref)
+	if err != nil {
+		// invalid references fail once they are scaled
+		gk = schema.GroupKind{Group: ref.APIVersion, Kind: ref.Kind}
+	}
+	return gk.String() + "/" + ref.Name
+}
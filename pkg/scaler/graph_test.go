@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+)
+
+func newDependantScale(name string, dependsOn ...string) *api.DependantScaleDetails {
+	dsd := &api.DependantScaleDetails{
+		ScaleRef: autoscalingv1.CrossVersionObjectReference{
+			APIVersion: "apps/v1",
+			Kind:       kindDeployment,
+			Name:       name,
+		},
+	}
+	for _, d := range dependsOn {
+		dsd.ScaleRefDependsOn = append(dsd.ScaleRefDependsOn, autoscalingv1.CrossVersionObjectReference{
+			APIVersion: "apps/v1",
+			Kind:       kindDeployment,
+			Name:       d,
+		})
+	}
+	return dsd
+}
+
+var _ = Describe("scaleGraph", func() {
+	It("should build the dependencies and dependants of every node", func() {
+		g, err := newScaleGraph([]*api.DependantScaleDetails{
+			newDependantScale("kube-controller-manager"),
+			newDependantScale("machine-controller-manager", "kube-controller-manager", "external"),
+			nil,
+			newDependantScale("cluster-autoscaler", "kube-controller-manager", "machine-controller-manager"),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(g.nodes).To(HaveLen(3))
+		Expect(g.dependencies).To(Equal([][]int{nil, {0}, {0, 1}}))
+		Expect(g.dependants).To(Equal([][]int{{1, 2}, {2}, nil}))
+		Expect(g.predecessors(1, true)).To(Equal([]int{0}))
+		Expect(g.predecessors(1, false)).To(Equal([]int{2}))
+	})
+
+	It("should reject duplicate dependant scales", func() {
+		_, err := newScaleGraph([]*api.DependantScaleDetails{
+			newDependantScale("kube-controller-manager"),
+			newDependantScale("kube-controller-manager"),
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should tell targets apart by their group but not by their version", func() {
+		custom := newDependantScale("kube-controller-manager")
+		custom.ScaleRef.APIVersion = "example.com/v1"
+		_, err := newScaleGraph([]*api.DependantScaleDetails{newDependantScale("kube-controller-manager"), custom})
+		Expect(err).ToNot(HaveOccurred())
+
+		beta := newDependantScale("kube-controller-manager")
+		beta.ScaleRef.APIVersion = "apps/v1beta2"
+		_, err = newScaleGraph([]*api.DependantScaleDetails{newDependantScale("kube-controller-manager"), beta})
+		Expect(err).To(MatchError(ContainSubstring("duplicate dependant scale Deployment.apps/kube-controller-manager")))
+
+		extensions := newDependantScale("kube-controller-manager")
+		extensions.ScaleRef.APIVersion = "extensions/v1beta1"
+		_, err = newScaleGraph([]*api.DependantScaleDetails{newDependantScale("kube-controller-manager"), extensions})
+		Expect(err).To(MatchError(ContainSubstring("duplicate dependant scale Deployment.apps/kube-controller-manager")))
+	})
+
+	It("should resolve dependencies on deployments of the deprecated extensions group", func() {
+		mcm := newDependantScale("machine-controller-manager", "kube-controller-manager")
+		mcm.ScaleRefDependsOn[0].APIVersion = "extensions/v1beta1"
+		g, err := newScaleGraph([]*api.DependantScaleDetails{newDependantScale("kube-controller-manager"), mcm})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(g.dependencies).To(Equal([][]int{nil, {0}}))
+	})
+
+	It("should reject cyclic dependencies", func() {
+		_, err := newScaleGraph([]*api.DependantScaleDetails{
+			newDependantScale("a", "c"),
+			newDependantScale("b", "a"),
+			newDependantScale("c", "b"),
+		})
+		Expect(err).To(MatchError(ContainSubstring("Deployment.apps/a -> Deployment.apps/c -> Deployment.apps/b -> Deployment.apps/a")))
+	})
+
+	It("should reject self dependencies", func() {
+		_, err := newScaleGraph([]*api.DependantScaleDetails{
+			newDependantScale("a", "a"),
+		})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,336 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// scopedResyncPeriod is the resync period of the informers created by the controller itself rather than
+// taken from the shared informer factory.
+const scopedResyncPeriod = 30 * time.Second
+
+// kubeconfigSecretNamesOf returns the sorted names of the kubeconfig secrets of all probes.
+func kubeconfigSecretNamesOf(probeDependantsList *api.ProbeDependantsList) []string {
+	names := sets.NewString()
+	for _, pd := range probeDependantsList.Probes {
+		if pd.Probe == nil {
+			continue
+		}
+		if pd.Probe.External != nil && pd.Probe.External.KubeconfigSecretName != "" {
+			names.Insert(pd.Probe.External.KubeconfigSecretName)
+		}
+		if pd.Probe.Internal != nil && pd.Probe.Internal.KubeconfigSecretName != "" {
+			names.Insert(pd.Probe.Internal.KubeconfigSecretName)
+		}
+	}
+	return names.List()
+}
+
+// kubeconfigSecrets caches only the kubeconfig secrets of the probes instead of every secret on the seed.
+// There is one informer per secret name as field selectors cannot select several names at once.
+type kubeconfigSecrets struct {
+	factories []informers.SharedInformerFactory
+	informers []cache.SharedIndexInformer
+	listers   map[string]listerv1.SecretLister
+}
+
+func newKubeconfigSecrets(client kubernetes.Interface, namespace string, names []string) *kubeconfigSecrets {
+	s := &kubeconfigSecrets{listers: make(map[string]listerv1.SecretLister)}
+	for _, name := range names {
+		selector := fields.OneTermEqualSelector("metadata.name", name).String()
+		factory := informers.NewSharedInformerFactoryWithOptions(client, scopedResyncPeriod,
+			informers.WithNamespace(namespace),
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.FieldSelector = selector
+			}))
+		secrets := factory.Core().V1().Secrets()
+		s.factories = append(s.factories, factory)
+		s.informers = append(s.informers, secrets.Informer())
+		s.listers[name] = secrets.Lister()
+	}
+	return s
+}
+
+func (s *kubeconfigSecrets) addEventHandler(handler cache.ResourceEventHandler) {
+	for _, informer := range s.informers {
+		informer.AddEventHandler(handler)
+	}
+}
+
+func (s *kubeconfigSecrets) start(stopCh <-chan struct{}) {
+	for _, factory := range s.factories {
+		factory.Start(stopCh)
+	}
+}
+
+func (s *kubeconfigSecrets) hasSynced() bool {
+	for _, informer := range s.informers {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// List lists the kubeconfig secrets of all names. It implements listerv1.SecretLister.
+func (s *kubeconfigSecrets) List(selector labels.Selector) ([]*corev1.Secret, error) {
+	var secrets []*corev1.Secret
+	for _, lister := range s.listers {
+		l, err := lister.List(selector)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, l...)
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		return secrets[i].Namespace+"/"+secrets[i].Name < secrets[j].Namespace+"/"+secrets[j].Name
+	})
+	return secrets, nil
+}
+
+// Secrets returns a lister for the kubeconfig secrets in the namespace. It implements listerv1.SecretLister.
+func (s *kubeconfigSecrets) Secrets(namespace string) listerv1.SecretNamespaceLister {
+	return &kubeconfigSecretsInNamespace{secrets: s, namespace: namespace}
+}
+
+type kubeconfigSecretsInNamespace struct {
+	secrets   *kubeconfigSecrets
+	namespace string
+}
+
+func (n *kubeconfigSecretsInNamespace) List(selector labels.Selector) ([]*corev1.Secret, error) {
+	all, err := n.secrets.List(selector)
+	if err != nil {
+		return nil, err
+	}
+	var secrets []*corev1.Secret
+	for _, secret := range all {
+		if secret.Namespace == n.namespace {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets, nil
+}
+
+func (n *kubeconfigSecretsInNamespace) Get(name string) (*corev1.Secret, error) {
+	lister, ok := n.secrets.listers[name]
+	if !ok {
+		return nil, apierrors.NewNotFound(corev1.Resource("secret"), name)
+	}
+	return lister.Secrets(n.namespace).Get(name)
+}
+
+// newTrimmedInformer creates an informer which only keeps the parts of the objects returned by trim in its cache.
+func newTrimmedInformer(lw *cache.ListWatch, objType runtime.Object, trim func(runtime.Object) runtime.Object) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			list, err := lw.List(options)
+			if err != nil {
+				return nil, err
+			}
+			items, err := meta.ExtractList(list)
+			if err != nil {
+				return nil, err
+			}
+			for i := range items {
+				items[i] = trim(items[i])
+			}
+			return list, meta.SetList(list, items)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			w, err := lw.Watch(options)
+			if err != nil {
+				return nil, err
+			}
+			return watch.Filter(w, func(e watch.Event) (watch.Event, bool) {
+				if e.Type != watch.Error && e.Type != watch.Bookmark {
+					e.Object = trim(e.Object)
+				}
+				return e, true
+			}), nil
+		},
+	}, objType, scopedResyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+}
+
+// targetAnnotationKeys are the annotations of the scale targets read by the dependency-watchdog. All other
+// annotations, e.g. the last applied configuration, are dropped from the informer caches.
+var targetAnnotationKeys = []string{ignoreScalingAnnotationKey, scaledDownByAnnotationKey, bypassHoldDownAnnotationKey}
+
+// trimObjectMeta keeps the metadata needed to identify a scale target and to find its owners and conflicting scalers.
+// Only the annotations with the given keys are kept.
+func trimObjectMeta(m metav1.ObjectMeta, annotationKeys []string) metav1.ObjectMeta {
+	var annotations map[string]string
+	for _, key := range annotationKeys {
+		if value, ok := m.Annotations[key]; ok {
+			if annotations == nil {
+				annotations = make(map[string]string)
+			}
+			annotations[key] = value
+		}
+	}
+	return metav1.ObjectMeta{
+		Name:              m.Name,
+		Namespace:         m.Namespace,
+		UID:               m.UID,
+		ResourceVersion:   m.ResourceVersion,
+		Generation:        m.Generation,
+		Labels:            m.Labels,
+		Annotations:       annotations,
+		OwnerReferences:   m.OwnerReferences,
+		DeletionTimestamp: m.DeletionTimestamp,
+	}
+}
+
+// newDeploymentInformer creates a deployment informer which only caches the metadata, the replicas and the
+// replica counts of the status of the deployments.
+func newDeploymentInformer(client kubernetes.Interface, namespace string) cache.SharedIndexInformer {
+	return newTrimmedInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.AppsV1().Deployments(namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.AppsV1().Deployments(namespace).Watch(options)
+		},
+	}, &appsv1.Deployment{}, func(obj runtime.Object) runtime.Object {
+		d, ok := obj.(*appsv1.Deployment)
+		if !ok {
+			return obj
+		}
+		return &appsv1.Deployment{
+			TypeMeta:   d.TypeMeta,
+			ObjectMeta: trimObjectMeta(d.ObjectMeta, targetAnnotationKeys),
+			Spec:       appsv1.DeploymentSpec{Replicas: d.Spec.Replicas},
+			Status: appsv1.DeploymentStatus{
+				ObservedGeneration: d.Status.ObservedGeneration,
+				Replicas:           d.Status.Replicas,
+				ReadyReplicas:      d.Status.ReadyReplicas,
+				AvailableReplicas:  d.Status.AvailableReplicas,
+			},
+		}
+	})
+}
+
+// newStatefulSetInformer creates a statefulset informer which only caches the metadata, the replicas and the
+// replica counts of the status of the statefulsets.
+func newStatefulSetInformer(client kubernetes.Interface, namespace string) cache.SharedIndexInformer {
+	return newTrimmedInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.AppsV1().StatefulSets(namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.AppsV1().StatefulSets(namespace).Watch(options)
+		},
+	}, &appsv1.StatefulSet{}, func(obj runtime.Object) runtime.Object {
+		s, ok := obj.(*appsv1.StatefulSet)
+		if !ok {
+			return obj
+		}
+		return &appsv1.StatefulSet{
+			TypeMeta:   s.TypeMeta,
+			ObjectMeta: trimObjectMeta(s.ObjectMeta, targetAnnotationKeys),
+			Spec:       appsv1.StatefulSetSpec{Replicas: s.Spec.Replicas},
+			Status: appsv1.StatefulSetStatus{
+				ObservedGeneration: s.Status.ObservedGeneration,
+				Replicas:           s.Status.Replicas,
+				ReadyReplicas:      s.Status.ReadyReplicas,
+			},
+		}
+	})
+}
+
+// newHPAInformer creates a HorizontalPodAutoscaler informer which only caches the metadata and the scale target
+// references of the HorizontalPodAutoscalers.
+func newHPAInformer(client kubernetes.Interface, namespace string) cache.SharedIndexInformer {
+	return newTrimmedInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.AutoscalingV1().HorizontalPodAutoscalers(namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.AutoscalingV1().HorizontalPodAutoscalers(namespace).Watch(options)
+		},
+	}, &autoscalingv1.HorizontalPodAutoscaler{}, func(obj runtime.Object) runtime.Object {
+		h, ok := obj.(*autoscalingv1.HorizontalPodAutoscaler)
+		if !ok {
+			return obj
+		}
+		return &autoscalingv1.HorizontalPodAutoscaler{
+			TypeMeta:   h.TypeMeta,
+			ObjectMeta: trimObjectMeta(h.ObjectMeta, nil),
+			Spec:       autoscalingv1.HorizontalPodAutoscalerSpec{ScaleTargetRef: h.Spec.ScaleTargetRef},
+		}
+	})
+}
+
+// newDynamicInformer creates an informer for the resource which only caches the metadata with the annotations of
+// the given keys and the fields at the given paths of the objects.
+func newDynamicInformer(client dynamic.Interface, gvr schema.GroupVersionResource, namespace string, annotationKeys []string, paths ...[]string) cache.SharedIndexInformer {
+	return newTrimmedInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.Resource(gvr).Namespace(namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.Resource(gvr).Namespace(namespace).Watch(options)
+		},
+	}, &unstructured.Unstructured{}, func(obj runtime.Object) runtime.Object {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return obj
+		}
+		m := trimObjectMeta(metav1.ObjectMeta{
+			Name:              u.GetName(),
+			Namespace:         u.GetNamespace(),
+			UID:               u.GetUID(),
+			ResourceVersion:   u.GetResourceVersion(),
+			Generation:        u.GetGeneration(),
+			Labels:            u.GetLabels(),
+			Annotations:       u.GetAnnotations(),
+			OwnerReferences:   u.GetOwnerReferences(),
+			DeletionTimestamp: u.GetDeletionTimestamp(),
+		}, annotationKeys)
+
+		trimmed := &unstructured.Unstructured{Object: make(map[string]interface{})}
+		trimmed.SetAPIVersion(u.GetAPIVersion())
+		trimmed.SetKind(u.GetKind())
+		trimmed.SetName(m.Name)
+		trimmed.SetNamespace(m.Namespace)
+		trimmed.SetUID(m.UID)
+		trimmed.SetResourceVersion(m.ResourceVersion)
+		trimmed.SetGeneration(m.Generation)
+		trimmed.SetLabels(m.Labels)
+		trimmed.SetAnnotations(m.Annotations)
+		trimmed.SetOwnerReferences(m.OwnerReferences)
+		trimmed.SetDeletionTimestamp(m.DeletionTimestamp)
+		for _, path := range paths {
+			if value, found, err := unstructured.NestedFieldNoCopy(u.Object, path...); err == nil && found {
+				if err := unstructured.SetNestedField(trimmed.Object, value, path...); err != nil {
+					return obj
+				}
+			}
+		}
+		return trimmed
+	})
+}
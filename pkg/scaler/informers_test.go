@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	listerappsv1 "k8s.io/client-go/listers/apps/v1"
+	listerautoscalingv1 "k8s.io/client-go/listers/autoscaling/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/pointer"
+)
+
+var _ = Describe("scoped informers", func() {
+	var stopCh chan struct{}
+
+	BeforeEach(func() {
+		stopCh = make(chan struct{})
+	})
+
+	AfterEach(func() {
+		close(stopCh)
+	})
+
+	It("should collect the kubeconfig secret names of all probes", func() {
+		list := &api.ProbeDependantsList{Probes: []api.ProbeDependants{
+			{Name: "kube-apiserver", Probe: &api.ProbeConfig{
+				External: &api.ProbeDetails{KubeconfigSecretName: "kubeconfig-external"},
+				Internal: &api.ProbeDetails{KubeconfigSecretName: "kubeconfig-internal"},
+			}},
+			{Name: "etcd", Probe: &api.ProbeConfig{
+				Internal: &api.ProbeDetails{KubeconfigSecretName: "kubeconfig-internal"},
+			}},
+			{Name: "none"},
+		}}
+		Expect(kubeconfigSecretNamesOf(list)).To(Equal([]string{"kubeconfig-external", "kubeconfig-internal"}))
+	})
+
+	It("should only look up the kubeconfig secrets", func() {
+		client := fake.NewSimpleClientset(
+			&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "shoot--foo", Name: "kubeconfig-internal"}},
+			&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "shoot--foo", Name: "other"}},
+		)
+		secrets := newKubeconfigSecrets(client, "", []string{"kubeconfig-internal"})
+		secrets.start(stopCh)
+		Expect(cache.WaitForCacheSync(stopCh, secrets.hasSynced)).To(BeTrue())
+
+		secret, err := secrets.Secrets("shoot--foo").Get("kubeconfig-internal")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secret.Name).To(Equal("kubeconfig-internal"))
+
+		_, err = secrets.Secrets("shoot--foo").Get("other")
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		_, err = secrets.Secrets("shoot--bar").Get("kubeconfig-internal")
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+		// the fake clientset ignores field selectors, so check that they are sent instead of what is cached
+		var selectors []string
+		for _, action := range client.Actions() {
+			switch a := action.(type) {
+			case k8stesting.ListAction:
+				selectors = append(selectors, a.GetListRestrictions().Fields.String())
+			case k8stesting.WatchAction:
+				selectors = append(selectors, a.GetWatchRestrictions().Fields.String())
+			}
+		}
+		Expect(selectors).To(ConsistOf("metadata.name=kubeconfig-internal", "metadata.name=kubeconfig-internal"))
+	})
+
+	It("should only cache the fields of the deployments which are used", func() {
+		client := fake.NewSimpleClientset(&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shoot--foo", Name: "kube-controller-manager", Annotations: map[string]string{
+				"foo":                      "bar",
+				ignoreScalingAnnotationKey: "true",
+			}},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: pointer.Int32Ptr(2),
+				Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "kcm", Image: "kcm"}}}},
+			},
+			Status: appsv1.DeploymentStatus{AvailableReplicas: 1},
+		})
+		informer := newDeploymentInformer(client, "")
+		go informer.Run(stopCh)
+		Expect(cache.WaitForCacheSync(stopCh, informer.HasSynced)).To(BeTrue())
+
+		d, err := listerappsv1.NewDeploymentLister(informer.GetIndexer()).Deployments("shoot--foo").Get("kube-controller-manager")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(d.Annotations).To(Equal(map[string]string{ignoreScalingAnnotationKey: "true"}))
+		Expect(*d.Spec.Replicas).To(Equal(int32(2)))
+		Expect(d.Status.AvailableReplicas).To(Equal(int32(1)))
+		Expect(d.Spec.Template.Spec.Containers).To(BeEmpty())
+	})
+
+	It("should only cache the scale target references of the HorizontalPodAutoscalers", func() {
+		ref := autoscalingv1.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: kindDeployment, Name: "kube-apiserver"}
+		client := fake.NewSimpleClientset(&autoscalingv1.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shoot--foo", Name: "kube-apiserver", Annotations: map[string]string{"foo": "bar"}},
+			Spec:       autoscalingv1.HorizontalPodAutoscalerSpec{ScaleTargetRef: ref, MaxReplicas: 3},
+		})
+		informer := newHPAInformer(client, "")
+		go informer.Run(stopCh)
+		Expect(cache.WaitForCacheSync(stopCh, informer.HasSynced)).To(BeTrue())
+
+		hpa, err := listerautoscalingv1.NewHorizontalPodAutoscalerLister(informer.GetIndexer()).HorizontalPodAutoscalers("shoot--foo").Get("kube-apiserver")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hpa.Annotations).To(BeEmpty())
+		Expect(hpa.Spec).To(Equal(autoscalingv1.HorizontalPodAutoscalerSpec{ScaleTargetRef: ref}))
+	})
+
+	It("should only cache the given fields of the objects of dynamic kinds", func() {
+		gvr := schema.GroupVersionResource{Group: "druid.gardener.cloud", Version: "v1alpha1", Resource: "etcds"}
+		etcd := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "druid.gardener.cloud/v1alpha1",
+			"kind":       "Etcd",
+			"spec":       map[string]interface{}{"replicas": int64(1), "foo": "bar"},
+			"status":     map[string]interface{}{"readyReplicas": int64(1), "conditions": []interface{}{}},
+		}}
+		etcd.SetNamespace("shoot--foo")
+		etcd.SetName("etcd-main")
+		etcd.SetAnnotations(map[string]string{"foo": "bar", scaledDownByAnnotationKey: "{}"})
+		etcd.SetOwnerReferences([]metav1.OwnerReference{{APIVersion: "v1", Kind: "Foo", Name: "foo", UID: "uid"}})
+
+		informer := newDynamicInformer(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), etcd), gvr, "", targetAnnotationKeys,
+			[]string{"spec", "replicas"}, []string{"status", "readyReplicas"}, []string{"status", "availableReplicas"})
+		go informer.Run(stopCh)
+		Expect(cache.WaitForCacheSync(stopCh, informer.HasSynced)).To(BeTrue())
+
+		obj, err := cache.NewGenericLister(informer.GetIndexer(), gvr.GroupResource()).ByNamespace("shoot--foo").Get("etcd-main")
+		Expect(err).NotTo(HaveOccurred())
+		u := obj.(*unstructured.Unstructured)
+		Expect(u.GetAnnotations()).To(Equal(map[string]string{scaledDownByAnnotationKey: "{}"}))
+		Expect(u.GetOwnerReferences()).To(Equal(etcd.GetOwnerReferences()))
+		Expect(u.Object["spec"]).To(Equal(map[string]interface{}{"replicas": int64(1)}))
+		Expect(u.Object["status"]).To(Equal(map[string]interface{}{"readyReplicas": int64(1)}))
+	})
+})
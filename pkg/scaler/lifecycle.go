@@ -0,0 +1,195 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"context"
+	"time"
+
+	"github.com/gardener/dependency-watchdog/pkg/multicontext"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// fullResyncPeriod is the period of the full reconciliation catching up on missed cluster and namespace events.
+const fullResyncPeriod = 10 * time.Minute
+
+// registerLifecycleHandlers starts the probers of namespaces turning ready or selected and stops the probers of
+// namespaces which are deleted, no longer selected or no longer known to the readiness source, e.g. as their
+// cluster is deleted.
+func (c *Controller) registerLifecycleHandlers() {
+	c.readiness.addEventHandlers(c.enqueueNamespace, c.enqueueStop)
+	c.namespaceSelector.AddEventHandler(c.enqueueNamespace, c.enqueueStop)
+	c.namespacesInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(_, new interface{}) {
+			if ns := new.(*v1.Namespace); ns.DeletionTimestamp != nil {
+				klog.V(4).Infof("Namespace %v is being deleted", ns.Name)
+				c.enqueueStop(ns.Name)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if ns, ok := obj.(*v1.Namespace); ok {
+				klog.V(4).Infof("Delete event on namespace: %v", ns.Name)
+				c.enqueueStop(ns.Name)
+			}
+		},
+	})
+}
+
+// enqueueNamespace enqueues the namespace for reconciliation if it is selected.
+func (c *Controller) enqueueNamespace(ns string) {
+	if !c.selected(ns) {
+		// skip reconciling namespaces which are not configured or selected
+		return
+	}
+	c.workqueue.Add(ns)
+}
+
+// enqueueStop enqueues the namespace whose probers should be stopped. The informer handlers must not stop the probers
+// themselves as that blocks on the Multicontext and delays all other handlers. processNamespace stops the probers
+// of namespaces which are not selected, gone or not ready.
+func (c *Controller) enqueueStop(ns string) {
+	c.workqueue.Add(ns)
+}
+
+// selected checks if the namespace is the configured one, if any, and is selected by the namespace selector.
+func (c *Controller) selected(ns string) bool {
+	if c.probeDependantsList.Namespace != "" && ns != c.probeDependantsList.Namespace {
+		return false
+	}
+	return c.namespaceSelector.Selects(ns)
+}
+
+// namespaceGone checks if the namespace is deleted or being deleted or no longer known to the readiness source.
+func (c *Controller) namespaceGone(ns string) bool {
+	if !c.readiness.exists(ns) {
+		return true
+	}
+	obj, exists, err := c.namespacesInformer.GetIndexer().GetByKey(ns)
+	if err != nil || !exists {
+		return err == nil
+	}
+	return obj.(*v1.Namespace).DeletionTimestamp != nil
+}
+
+// stopProbers cancels the probers of the namespace and releases their dependants, see prober.release. The targets
+// scaled down by the probers are scaled up again unless the namespace is gone or its dependants are not meant to
+// run. Such a release is registered like a prober, so that it is cancelled once a prober is started again.
+// Probes without a prober, a running release or targets marked by them are skipped as there is nothing to release.
+func (c *Controller) stopProbers(ns string) {
+	keepUp := !c.namespaceGone(ns) && c.readiness.keepsDependantsUp(ns)
+	for i := range c.probeDependantsList.Probes {
+		probeDeps := &c.probeDependantsList.Probes[i]
+		key := c.getKey(ns, probeDeps)
+		p := c.newProber(ns, probeDeps)
+		if c.proberRegistered(key) {
+			klog.Infof("Stopping the probe %s", key)
+		} else if !c.releaseRegistered(key) && !p.ownsAnyTarget("Stopping the probe") {
+			continue
+		}
+
+		ctx, cancelFn := context.Background(), context.CancelFunc(nil)
+		if keepUp {
+			ctx, cancelFn = context.WithCancel(ctx)
+			c.registerRelease(key, ctx)
+		}
+		// This cancels the running prober or release, if any.
+		c.Multicontext.ContextCh <- &multicontext.ContextMessage{
+			Key:      key,
+			CancelFn: cancelFn,
+		}
+		go func(key string) {
+			p.release(ctx, keepUp)
+			if keepUp {
+				c.unregisterRelease(key, ctx)
+			}
+		}(key)
+	}
+}
+
+func (c *Controller) proberRegistered(key string) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	_, ok := c.probers[key]
+	return ok
+}
+
+// registerRelease records the context of the release of the dependants of a stopped prober.
+func (c *Controller) registerRelease(key string, ctx context.Context) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.releases == nil {
+		c.releases = make(map[string]context.Context)
+	}
+	c.releases[key] = ctx
+}
+
+// unregisterRelease removes the release with the given context unless another one was registered since.
+func (c *Controller) unregisterRelease(key string, ctx context.Context) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.releases[key] == ctx {
+		delete(c.releases, key)
+	}
+}
+
+func (c *Controller) releaseRegistered(key string) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	_, ok := c.releases[key]
+	return ok
+}
+
+// resync catches up on missed events. It enqueues the selected and ready namespaces which may be probed but miss any
+// of their probers and stops the probers of all other namespaces. Namespaces whose probers are all running are
+// not enqueued as that would restart their probers.
+func (c *Controller) resync() {
+	namespaces, err := c.readiness.namespaces()
+	if err != nil {
+		klog.Errorf("Error listing namespaces for the full reconciliation: %s", err)
+		return
+	}
+
+	existing := sets.NewString()
+	for _, ns := range namespaces {
+		if !c.selected(ns) || c.namespaceGone(ns) {
+			continue
+		}
+		if reason, err := c.readiness.notReadyReason(ns); err == nil && reason != "" {
+			// e.g. hibernated or failed shoots, whose probers are started once they turn ready
+			continue
+		}
+		existing.Insert(ns)
+		for i := range c.probeDependantsList.Probes {
+			if !c.proberRegistered(c.getKey(ns, &c.probeDependantsList.Probes[i])) {
+				klog.V(4).Infof("Full reconciliation enqueues namespace %s", ns)
+				c.enqueueNamespace(ns)
+				break
+			}
+		}
+	}
+
+	c.mux.Lock()
+	running := sets.NewString()
+	for _, p := range c.probers {
+		running.Insert(p.namespace)
+	}
+	c.mux.Unlock()
+
+	for _, ns := range running.List() {
+		if !existing.Has(ns) {
+			c.stopProbers(ns)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"encoding/json"
+
+	"github.com/gardener/dependency-watchdog/pkg/multicontext"
+	"github.com/gardener/dependency-watchdog/pkg/nsselector"
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	gardencorev1beta1 "github.com/gardener/gardener/pkg/apis/core/v1beta1"
+	gardenerv1alpha1 "github.com/gardener/gardener/pkg/apis/extensions/v1alpha1"
+	gardenerlisterv1alpha1 "github.com/gardener/gardener/pkg/client/extensions/listers/extensions/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	scalefake "k8s.io/client-go/scale/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+var _ = Describe("cluster lifecycle", func() {
+	var (
+		c        *Controller
+		clusters cache.Indexer
+		stopped  chan string
+		released chan string
+		stopCh   chan struct{}
+	)
+
+	addCluster := func(name string) {
+		Expect(clusters.Add(&gardenerv1alpha1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: name}})).To(Succeed())
+		Expect(c.namespacesInformer.GetIndexer().Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})).To(Succeed())
+	}
+
+	addProbers := func(ns string) {
+		for i := range c.probeDependantsList.Probes {
+			probeDeps := &c.probeDependantsList.Probes[i]
+			c.probers[c.getKey(ns, probeDeps)] = &prober{namespace: ns, probeDeps: probeDeps}
+		}
+	}
+
+	BeforeEach(func() {
+		clusters = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+		c = &Controller{
+			readiness:          &clusterReadiness{lister: gardenerlisterv1alpha1.NewClusterLister(clusters)},
+			namespacesInformer: factory.Core().V1().Namespaces().Informer(),
+			workqueue:          workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Namespaces"),
+			probeDependantsList: &api.ProbeDependantsList{
+				Probes: []api.ProbeDependants{{Name: "kube-apiserver"}, {Name: "etcd"}},
+			},
+			probers:      make(map[string]*prober),
+			scalesGetter: &scalefake.FakeScaleClient{},
+			Multicontext: multicontext.New(),
+		}
+
+		// record the stopped probers and the registered releases instead of cancelling their contexts
+		stopped = make(chan string, 10)
+		released = make(chan string, 10)
+		stopCh = make(chan struct{})
+		go func(contextCh <-chan *multicontext.ContextMessage, stopped, released chan<- string, stopCh <-chan struct{}) {
+			for {
+				select {
+				case msg := <-contextCh:
+					if msg.CancelFn == nil {
+						stopped <- msg.Key
+					} else {
+						released <- msg.Key
+					}
+				case <-stopCh:
+					return
+				}
+			}
+		}(c.ContextCh, stopped, released, stopCh)
+	})
+
+	AfterEach(func() {
+		close(stopCh)
+		c.workqueue.ShutDown()
+	})
+
+	It("should only enqueue the namespaces missing probers", func() {
+		addCluster("shoot--running")
+		addProbers("shoot--running")
+		addCluster("shoot--new")
+
+		c.resync()
+		Expect(c.workqueue.Len()).To(Equal(1))
+		key, _ := c.workqueue.Get()
+		Expect(key).To(Equal("shoot--new"))
+		Expect(stopped).To(BeEmpty())
+	})
+
+	It("should stop the probers of deleted clusters and namespaces", func() {
+		addProbers("shoot--deleted")
+		addCluster("shoot--terminating")
+		addProbers("shoot--terminating")
+		now := metav1.Now()
+		Expect(c.namespacesInformer.GetIndexer().Update(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shoot--terminating", DeletionTimestamp: &now}})).To(Succeed())
+
+		c.resync()
+		Expect(c.workqueue.Len()).To(Equal(0))
+
+		var keys []string
+		for i := 0; i < 4; i++ {
+			var key string
+			Eventually(stopped).Should(Receive(&key))
+			keys = append(keys, key)
+		}
+		Expect(keys).To(ConsistOf("shoot--deleted/kube-apiserver", "shoot--deleted/etcd", "shoot--terminating/kube-apiserver", "shoot--terminating/etcd"))
+	})
+
+	It("should stop the probers of a namespace once its cluster is deleted", func() {
+		addCluster("shoot--foo")
+		addProbers("shoot--foo")
+		Expect(c.namespaceGone("shoot--foo")).To(BeFalse())
+
+		Expect(clusters.Delete(&gardenerv1alpha1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "shoot--foo"}})).To(Succeed())
+		Expect(c.namespaceGone("shoot--foo")).To(BeTrue())
+		Expect(c.processNamespace("shoot--foo")).To(Succeed())
+		Eventually(stopped).Should(Receive(Equal("shoot--foo/kube-apiserver")))
+		Eventually(stopped).Should(Receive(Equal("shoot--foo/etcd")))
+	})
+
+	It("should skip probes without anything to release", func() {
+		addCluster("shoot--idle")
+		c.stopProbers("shoot--idle")
+		Consistently(stopped).ShouldNot(Receive())
+		Expect(released).To(BeEmpty())
+	})
+
+	It("should hand the stop of the probers off to the workqueue", func() {
+		addProbers("shoot--foo")
+		Expect(c.namespaceGone("shoot--foo")).To(BeTrue())
+
+		c.enqueueStop("shoot--foo")
+		Expect(stopped).To(BeEmpty())
+		Expect(c.workqueue.Len()).To(Equal(1))
+		key, _ := c.workqueue.Get()
+		Expect(c.processNamespace(key.(string))).To(Succeed())
+		Eventually(stopped).Should(Receive(Equal("shoot--foo/kube-apiserver")))
+		Eventually(stopped).Should(Receive(Equal("shoot--foo/etcd")))
+	})
+
+	It("should only probe the selected namespaces", func() {
+		namespaces := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0).Core().V1().Namespaces()
+		c.namespacesInformer = namespaces.Informer()
+		c.namespaceSelector, _ = nsselector.New(&nsselector.Config{
+			Include: &metav1.LabelSelector{MatchLabels: map[string]string{"purpose": "production"}},
+		}, namespaces)
+		addSelectedCluster := func(name string, labels map[string]string) {
+			Expect(clusters.Add(&gardenerv1alpha1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: name}})).To(Succeed())
+			Expect(c.namespacesInformer.GetIndexer().Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}})).To(Succeed())
+		}
+		addSelectedCluster("shoot--production", map[string]string{"purpose": "production"})
+		addSelectedCluster("shoot--evaluation", map[string]string{"purpose": "evaluation"})
+		addProbers("shoot--evaluation")
+
+		c.resync()
+		Expect(c.workqueue.Len()).To(Equal(1))
+		key, _ := c.workqueue.Get()
+		Expect(key).To(Equal("shoot--production"))
+		Eventually(stopped).Should(Receive(Equal("shoot--evaluation/kube-apiserver")))
+		Eventually(stopped).Should(Receive(Equal("shoot--evaluation/etcd")))
+	})
+
+	Describe("standing down", func() {
+		addShootCluster := func(name string, lastOperationState gardencorev1beta1.LastOperationState, hibernated bool) {
+			raw, err := json.Marshal(&gardencorev1beta1.Shoot{
+				TypeMeta: metav1.TypeMeta{APIVersion: gardencorev1beta1.SchemeGroupVersion.String(), Kind: "Shoot"},
+				Status: gardencorev1beta1.ShootStatus{
+					IsHibernated: hibernated,
+					LastOperation: &gardencorev1beta1.LastOperation{
+						Type:  gardencorev1beta1.LastOperationTypeReconcile,
+						State: lastOperationState,
+					},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(clusters.Add(&gardenerv1alpha1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: name, ResourceVersion: "1"},
+				Spec:       gardenerv1alpha1.ClusterSpec{Shoot: runtime.RawExtension{Raw: raw}},
+			})).To(Succeed())
+			Expect(c.namespacesInformer.GetIndexer().Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})).To(Succeed())
+		}
+
+		It("should scale the dependants up before standing down for a failed shoot", func() {
+			addShootCluster("shoot--failed", gardencorev1beta1.LastOperationStateFailed, false)
+			addProbers("shoot--failed")
+
+			Expect(c.processNamespace("shoot--failed")).To(Succeed())
+			Eventually(released).Should(Receive(Equal("shoot--failed/kube-apiserver")))
+			Eventually(released).Should(Receive(Equal("shoot--failed/etcd")))
+			Expect(stopped).To(BeEmpty())
+		})
+
+		It("should only release the dependants of a hibernated shoot", func() {
+			addShootCluster("shoot--hibernated", gardencorev1beta1.LastOperationStateSucceeded, true)
+			addProbers("shoot--hibernated")
+
+			Expect(c.processNamespace("shoot--hibernated")).To(Succeed())
+			Eventually(stopped).Should(Receive(Equal("shoot--hibernated/kube-apiserver")))
+			Eventually(stopped).Should(Receive(Equal("shoot--hibernated/etcd")))
+			Expect(released).To(BeEmpty())
+		})
+
+		It("should not enqueue namespaces which are not ready during the full reconciliation", func() {
+			addShootCluster("shoot--hibernated", gardencorev1beta1.LastOperationStateSucceeded, true)
+
+			c.resync()
+			Expect(c.workqueue.Len()).To(Equal(0))
+			Consistently(stopped).ShouldNot(Receive())
+			Expect(released).To(BeEmpty())
+
+			By("stopping the probers still running")
+			addProbers("shoot--hibernated")
+			c.resync()
+			Eventually(stopped).Should(Receive(Equal("shoot--hibernated/kube-apiserver")))
+			Eventually(stopped).Should(Receive(Equal("shoot--hibernated/etcd")))
+		})
+
+		It("should decode the shoot once per resource version", func() {
+			addShootCluster("shoot--failed", gardencorev1beta1.LastOperationStateFailed, false)
+			r := c.readiness.(*clusterReadiness)
+
+			shoot, err := r.shootOf("shoot--failed")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.shootOf("shoot--failed")).To(BeIdenticalTo(shoot))
+
+			cluster, err := r.lister.Get("shoot--failed")
+			Expect(err).ToNot(HaveOccurred())
+			cluster = cluster.DeepCopy()
+			cluster.ResourceVersion = "2"
+			Expect(clusters.Update(cluster)).To(Succeed())
+			Expect(r.shootOf("shoot--failed")).ToNot(BeIdenticalTo(shoot))
+		})
+	})
+})
@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"sync"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+// changeNotifier notifies the registered waiters whenever the objects they wait for are changed in an informer cache.
+type changeNotifier struct {
+	mux     sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+func newChangeNotifier() *changeNotifier {
+	return &changeNotifier{
+		waiters: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+func notifierKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// watch registers a waiter for the object of the given kind, namespace and name.
+// The returned channel receives a value whenever the object changes. Changes are coalesced if the
+// waiter does not keep up. The returned function must be called to unregister the waiter.
+// A nil notifier never notifies.
+func (n *changeNotifier) watch(kind, namespace, name string) (<-chan struct{}, func()) {
+	if n == nil {
+		return nil, func() {}
+	}
+
+	key := notifierKey(kind, namespace, name)
+	ch := make(chan struct{}, 1)
+
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if n.waiters[key] == nil {
+		n.waiters[key] = make(map[chan struct{}]struct{})
+	}
+	n.waiters[key][ch] = struct{}{}
+
+	return ch, func() {
+		n.mux.Lock()
+		defer n.mux.Unlock()
+		delete(n.waiters[key], ch)
+		if len(n.waiters[key]) == 0 {
+			delete(n.waiters, key)
+		}
+	}
+}
+
+// notify notifies all the waiters of the given object.
+func (n *changeNotifier) notify(kind string, obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return
+	}
+
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	for ch := range n.waiters[notifierKey(kind, namespace, name)] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// handlerFor returns the informer event handler notifying the waiters of objects of the given kind.
+func (n *changeNotifier) handlerFor(kind string) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			n.notify(kind, obj)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			n.notify(kind, new)
+		},
+		DeleteFunc: func(obj interface{}) {
+			n.notify(kind, obj)
+		},
+	}
+}
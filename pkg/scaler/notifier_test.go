@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newDeployment(namespace, name string, availableReplicas int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Status: appsv1.DeploymentStatus{
+			AvailableReplicas: availableReplicas,
+		},
+	}
+}
+
+var _ = Describe("changeNotifier", func() {
+	const ns = "test"
+
+	It("should notify only the waiters of the changed object", func() {
+		n := newChangeNotifier()
+		kcm, cancelKCM := n.watch(kindDeployment, ns, "kube-controller-manager")
+		defer cancelKCM()
+		mcm, cancelMCM := n.watch(kindDeployment, ns, "machine-controller-manager")
+		defer cancelMCM()
+
+		h := n.handlerFor(kindDeployment)
+		h.OnAdd(newDeployment(ns, "kube-controller-manager", 0))
+		h.OnUpdate(newDeployment(ns, "kube-controller-manager", 0), newDeployment(ns, "kube-controller-manager", 1))
+
+		Eventually(kcm).Should(Receive())
+		Consistently(kcm).ShouldNot(Receive())
+		Consistently(mcm).ShouldNot(Receive())
+	})
+
+	It("should unregister cancelled waiters", func() {
+		n := newChangeNotifier()
+		_, cancelFn := n.watch(kindDeployment, ns, "kube-controller-manager")
+		cancelFn()
+		Expect(n.waiters).To(BeEmpty())
+	})
+})
@@ -0,0 +1,223 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	autoscalingapi "k8s.io/api/autoscaling/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+// scaledDownByAnnotationKey marks the targets scaled down by the dependency-watchdog. Only targets carrying
+// this mark are scaled up again so that targets scaled down on purpose by others, e.g. for hibernation or
+// maintenance, are left alone.
+const scaledDownByAnnotationKey = "dependency-watchdog.gardener.cloud/scaled-down-by"
+
+// scaledDownMark is the value of the scaledDownByAnnotationKey annotation.
+type scaledDownMark struct {
+	Probe     string      `json:"probe"`
+	Timestamp metav1.Time `json:"timestamp"`
+}
+
+// scaledDownMarkOf returns the mark of the dependency-watchdog in the given annotations or nil if there is none.
+func scaledDownMarkOf(annotations map[string]string) *scaledDownMark {
+	value, ok := annotations[scaledDownByAnnotationKey]
+	if !ok {
+		return nil
+	}
+	mark := &scaledDownMark{}
+	if err := json.Unmarshal([]byte(value), mark); err != nil {
+		return nil
+	}
+	return mark
+}
+
+// ownsTarget checks if the target with the given annotations and replicas was scaled down by this prober.
+// Targets scaled down by versions of the dependency-watchdog which did not mark them yet carry no mark. They are
+// adopted if adoptUnmarked is set, i.e. if the prober last scaled its dependants down, and they are still at 0.
+func (p *prober) ownsTarget(annotations map[string]string, replicas int32, adoptUnmarked bool) bool {
+	if _, ok := annotations[scaledDownByAnnotationKey]; !ok {
+		return adoptUnmarked && replicas == 0
+	}
+	mark := scaledDownMarkOf(annotations)
+	return mark != nil && mark.Probe == p.probeDeps.Name
+}
+
+// getAnnotations returns the annotations of the target from the dynamic client.
+// It is used for targets without an informer cache.
+func (p *prober) getAnnotations(gvr schema.GroupVersionResource, name string) (map[string]string, error) {
+	dwdScaleRequestsTotal.With(prometheus.Labels{labelVerb: verbGet}).Inc()
+
+	u, err := p.dynamicClient.Resource(gvr).Namespace(p.namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if isRateLimited(err) {
+			dwdThrottledScaleRequestsTotal.With(prometheus.Labels{labelVerb: verbGet}).Inc()
+		}
+		return nil, err
+	}
+	return u.GetAnnotations(), nil
+}
+
+// mark sets the mark of this prober on the target.
+func (p *prober) mark(gvr schema.GroupVersionResource, name string) error {
+	value, err := json.Marshal(&scaledDownMark{
+		Probe:     p.probeDeps.Name,
+		Timestamp: metav1.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return p.patchAnnotation(gvr, name, string(value))
+}
+
+// unmark removes the mark of the dependency-watchdog from the target.
+func (p *prober) unmark(gvr schema.GroupVersionResource, name string) error {
+	return p.patchAnnotation(gvr, name, nil)
+}
+
+func (p *prober) patchAnnotation(gvr schema.GroupVersionResource, name string, value interface{}) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				scaledDownByAnnotationKey: value,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	dwdScaleRequestsTotal.With(prometheus.Labels{labelVerb: verbPatch}).Inc()
+
+	_, err = p.dynamicClient.Resource(gvr).Namespace(p.namespace).Patch(name, types.MergePatchType, data, metav1.PatchOptions{})
+
+	if err != nil && isRateLimited(err) {
+		dwdThrottledScaleRequestsTotal.With(prometheus.Labels{labelVerb: verbPatch}).Inc()
+	}
+	return err
+}
+
+// unmarkTarget removes the mark of the dependency-watchdog from a target which is already scaled up.
+// Failures are only logged as the removal is retried with the next scale up.
+func (p *prober) unmarkTarget(gk schema.GroupKind, version, name, prefix string) error {
+	dwdScaleRequestsTotal.With(prometheus.Labels{labelVerb: verbDiscovery}).Inc()
+	m, err := p.mapper.RESTMapping(gk, version)
+	if err != nil {
+		if isRateLimited(err) {
+			dwdThrottledScaleRequestsTotal.With(prometheus.Labels{labelVerb: verbDiscovery}).Inc()
+		}
+		klog.Errorf("%s: Could not remove the annotation %s: %s", prefix, scaledDownByAnnotationKey, err)
+		return nil
+	}
+	if err := p.unmark(m.Resource, name); err != nil {
+		klog.Errorf("%s: Could not remove the annotation %s: %s", prefix, scaledDownByAnnotationKey, err)
+	}
+	return nil
+}
+
+// unmarkOwnedTargets removes the mark of this prober from the targets it owns and resumes their paused scalers.
+// Failures are only logged.
+func (p *prober) unmarkOwnedTargets() {
+	for _, dsd := range p.probeDeps.DependantScales {
+		if dsd == nil {
+			continue
+		}
+		ref := dsd.ScaleRef
+		prefix := fmt.Sprintf("Releasing dependents of %s/%s: %s.%s/%s", p.probeDeps.Name, p.namespace, ref.APIVersion, ref.Kind, ref.Name)
+		gk, version, annotations, ok := p.targetAnnotations(prefix, ref)
+		if ok && p.ownsTarget(annotations, 0, false) {
+			p.resumeScalers(prefix, dsd)
+			p.unmarkTarget(gk, version, ref.Name, prefix)
+		}
+	}
+}
+
+// restoreHoldDown holds the dependant scales down again if any of them carries the mark of this prober, so that the
+// hold survives restarts of the prober while its targets are still scaled down.
+func (p *prober) restoreHoldDown() {
+	if p.ownsAnyTarget("Restoring the hold of") {
+		klog.Infof("%s/%s: holding the dependants down as they were scaled down by the dependency-watchdog", p.probeDeps.Name, p.namespace)
+		p.holdDowns.set(p.namespace, p.probeDeps, true)
+	}
+}
+
+// ownsAnyTarget checks if any of the dependant scales carries the mark of this prober. msg prefixes the logs.
+func (p *prober) ownsAnyTarget(msg string) bool {
+	for _, dsd := range p.probeDeps.DependantScales {
+		if dsd == nil {
+			continue
+		}
+		ref := dsd.ScaleRef
+		prefix := fmt.Sprintf("%s %s/%s: %s.%s/%s", msg, p.probeDeps.Name, p.namespace, ref.APIVersion, ref.Kind, ref.Name)
+		if _, _, annotations, ok := p.targetAnnotations(prefix, ref); ok && p.ownsTarget(annotations, 0, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// targetAnnotations returns the annotations of the target from its informer cache or, if there is none, from the
+// dynamic client along with its group kind and version. Failures are only logged and reported by ok.
+func (p *prober) targetAnnotations(prefix string, ref autoscalingapi.CrossVersionObjectReference) (gk schema.GroupKind, version string, annotations map[string]string, ok bool) {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		klog.Errorf("%s: %s", prefix, err)
+		return gk, "", nil, false
+	}
+	gk = schema.GroupKind{Group: gv.Group, Kind: ref.Kind}
+
+	t, err := p.targets.get(p.namespace, ref)
+	if err == errNoTargetCache {
+		var m *meta.RESTMapping
+		if m, err = p.mapper.RESTMapping(gk, gv.Version); err == nil {
+			annotations, err = p.getAnnotations(m.Resource, ref.Name)
+		}
+	} else if err == nil {
+		annotations = t.annotations
+	}
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			klog.V(4).Infof("%s: skipped as the target is gone", prefix)
+		} else {
+			klog.Errorf("%s: Could not get target: %s", prefix, err)
+		}
+		return gk, gv.Version, nil, false
+	}
+	return gk, gv.Version, annotations, true
+}
+
+// withOwnership wraps the scaling function of the target which reports whether it changed the target. The target is
+// marked before it is scaled down so that the mark is never missing on a target scaled down by the
+// dependency-watchdog. The mark is removed again if the target turns out to be scaled down already, e.g. by an
+// operator, so that it is not scaled up later. The mark is also removed after the target is scaled up.
+func (p *prober) withOwnership(gvr schema.GroupVersionResource, name string, replicas int32, scalingFn func() (bool, error)) func() (bool, error) {
+	if replicas == 0 {
+		return func() (bool, error) {
+			if err := p.mark(gvr, name); err != nil {
+				return false, err
+			}
+			changed, err := scalingFn()
+			if err != nil || changed {
+				return changed, err
+			}
+			return false, p.unmark(gvr, name)
+		}
+	}
+	return func() (bool, error) {
+		changed, err := scalingFn()
+		if err != nil {
+			return false, err
+		}
+		return changed, p.unmark(gvr, name)
+	}
+}
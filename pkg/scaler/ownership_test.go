@@ -0,0 +1,156 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+var _ = Describe("ownership", func() {
+	const (
+		ns   = "test"
+		name = "kube-controller-manager"
+	)
+
+	var (
+		gvr    = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+		client *dynamicfake.FakeDynamicClient
+		p      *prober
+	)
+
+	BeforeEach(func() {
+		d := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       kindDeployment,
+		}}
+		d.SetNamespace(ns)
+		d.SetName(name)
+		d.SetAnnotations(map[string]string{"foo": "bar"})
+
+		client = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), d)
+		p = &prober{
+			namespace:     ns,
+			dynamicClient: client,
+			probeDeps:     &api.ProbeDependants{Name: "kube-apiserver"},
+		}
+	})
+
+	getAnnotations := func() map[string]string {
+		d, err := client.Resource(gvr).Namespace(ns).Get(name, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		return d.GetAnnotations()
+	}
+
+	It("should mark the target before scaling it down", func() {
+		scalingFn := p.withOwnership(gvr, name, 0, func() (bool, error) {
+			Expect(p.ownsTarget(getAnnotations(), 0, false)).To(BeTrue())
+			return true, nil
+		})
+		Expect(scalingFn()).To(BeTrue())
+
+		annotations := getAnnotations()
+		Expect(annotations).To(HaveKeyWithValue("foo", "bar"))
+		mark := scaledDownMarkOf(annotations)
+		Expect(mark).ToNot(BeNil())
+		Expect(mark.Probe).To(Equal("kube-apiserver"))
+		Expect(mark.Timestamp.IsZero()).To(BeFalse())
+	})
+
+	It("should remove the mark if the target was already scaled down", func() {
+		scalingFn := p.withOwnership(gvr, name, 0, func() (bool, error) {
+			return false, nil
+		})
+		Expect(scalingFn()).To(BeFalse())
+		Expect(getAnnotations()).To(Equal(map[string]string{"foo": "bar"}))
+	})
+
+	It("should remove the mark after scaling the target up", func() {
+		Expect(p.mark(gvr, name)).To(Succeed())
+
+		scalingFn := p.withOwnership(gvr, name, 1, func() (bool, error) {
+			Expect(p.ownsTarget(getAnnotations(), 0, false)).To(BeTrue())
+			return true, nil
+		})
+		Expect(scalingFn()).To(BeTrue())
+		Expect(getAnnotations()).To(Equal(map[string]string{"foo": "bar"}))
+	})
+
+	It("should keep the mark if scaling the target up fails", func() {
+		Expect(p.mark(gvr, name)).To(Succeed())
+
+		scalingFn := p.withOwnership(gvr, name, 1, func() (bool, error) {
+			return false, errors.New("conflict")
+		})
+		_, err := scalingFn()
+		Expect(err).To(HaveOccurred())
+		Expect(p.ownsTarget(getAnnotations(), 0, false)).To(BeTrue())
+	})
+
+	It("should only own targets marked by the same probe", func() {
+		Expect(p.ownsTarget(nil, 0, false)).To(BeFalse())
+		Expect(p.ownsTarget(map[string]string{scaledDownByAnnotationKey: "invalid"}, 0, true)).To(BeFalse())
+		Expect(p.ownsTarget(map[string]string{scaledDownByAnnotationKey: `{"probe":"etcd"}`}, 0, true)).To(BeFalse())
+		Expect(p.ownsTarget(map[string]string{scaledDownByAnnotationKey: `{"probe":"kube-apiserver"}`}, 0, false)).To(BeTrue())
+	})
+
+	It("should adopt unmarked targets at 0 only if the dependants were last scaled down", func() {
+		Expect(p.ownsTarget(map[string]string{"foo": "bar"}, 0, true)).To(BeTrue())
+		Expect(p.ownsTarget(map[string]string{"foo": "bar"}, 1, true)).To(BeFalse())
+		Expect(p.ownsTarget(map[string]string{"foo": "bar"}, 0, false)).To(BeFalse())
+	})
+
+	It("should restore the hold on the dependants from its marks", func() {
+		mapper := meta.NewDefaultRESTMapper(nil)
+		mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: kindDeployment}, meta.RESTScopeNamespace)
+		p.mapper = mapper
+		p.targets = newTargetCaches()
+		p.holdDowns = newHoldDowns()
+		p.probeDeps.DependantScales = []*api.DependantScaleDetails{{
+			ScaleRef: autoscalingv1.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: kindDeployment, Name: name},
+		}}
+
+		p.restoreHoldDown()
+		Expect(p.holdDowns.holds(ns, p.probeDeps.Name)).To(BeFalse())
+
+		Expect(p.mark(gvr, name)).To(Succeed())
+		p.restoreHoldDown()
+		Expect(p.holdDowns.holds(ns, p.probeDeps.Name)).To(BeTrue())
+	})
+
+	It("should remove its marks when releasing dependants which are not meant to run", func() {
+		mapper := meta.NewDefaultRESTMapper(nil)
+		mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: kindDeployment}, meta.RESTScopeNamespace)
+		p.mapper = mapper
+		p.targets = newTargetCaches()
+		p.holdDowns = newHoldDowns()
+		p.probeDeps.DependantScales = []*api.DependantScaleDetails{{
+			ScaleRef: autoscalingv1.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: kindDeployment, Name: name},
+		}}
+		p.holdDowns.set(ns, p.probeDeps, true)
+		Expect(p.mark(gvr, name)).To(Succeed())
+
+		p.release(context.Background(), false)
+		Expect(getAnnotations()).To(Equal(map[string]string{"foo": "bar"}))
+		Expect(p.holdDowns.holds(ns, p.probeDeps.Name)).To(BeFalse())
+
+		By("keeping the marks of other probes")
+		etcd := &prober{namespace: ns, dynamicClient: client, probeDeps: &api.ProbeDependants{Name: "etcd"}}
+		Expect(etcd.mark(gvr, name)).To(Succeed())
+		p.release(context.Background(), false)
+		Expect(scaledDownMarkOf(getAnnotations())).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"sync"
+	"time"
+)
+
+// probeResultMaxAge is the age after which unused probe results are dropped from the probeCache.
+const probeResultMaxAge = 10 * time.Minute
+
+// probeCache shares the results of the probes of the same endpoint between the probers. The endpoints are
+// identified by the SHA256 checksum of their kubeconfig, so probers referencing the same kubeconfig secret,
+// or secrets with the same content, probe the endpoint once per period and act on the same result.
+// A prober is never served its own result, so that every result is acted on only once per prober.
+// A nil probeCache does not share any results.
+type probeCache struct {
+	now     func() time.Time
+	mux     sync.Mutex
+	entries map[string]*probeCacheEntry
+}
+
+type probeCacheEntry struct {
+	probing  sync.Mutex // held while the endpoint is probed so that concurrent probers wait for the result
+	err      error      // guarded by the mutex of the probeCache
+	probedAt time.Time  // the time the probe started, guarded by the mutex of the probeCache
+	probedBy string     // the prober which probed, guarded by the mutex of the probeCache
+}
+
+func newProbeCache() *probeCache {
+	return &probeCache{
+		now:     time.Now,
+		entries: make(map[string]*probeCacheEntry),
+	}
+}
+
+// probe returns the result of the last probe of the endpoint with the given kubeconfig checksum if it was
+// started less than the period ago by another prober. Otherwise it probes the endpoint with fn on behalf of the
+// given prober and shares the result. It returns whether the result was shared.
+func (c *probeCache) probe(sha []byte, prober string, period time.Duration, fn func() error) (bool, error) {
+	if c == nil || len(sha) == 0 {
+		return false, fn()
+	}
+
+	c.mux.Lock()
+	e, ok := c.entries[string(sha)]
+	if !ok {
+		e = &probeCacheEntry{}
+		c.entries[string(sha)] = e
+	}
+	c.mux.Unlock()
+
+	e.probing.Lock()
+	defer e.probing.Unlock()
+
+	c.mux.Lock()
+	fresh := !e.probedAt.IsZero() && e.probedBy != prober && c.now().Sub(e.probedAt) < period
+	err, startedAt := e.err, c.now()
+	c.mux.Unlock()
+	if fresh {
+		dwdSharedProbeResultsTotal.With(nil).Inc()
+		return true, err
+	}
+
+	err = fn()
+	c.mux.Lock()
+	e.err, e.probedAt, e.probedBy = err, startedAt, prober
+	c.mux.Unlock()
+	return false, err
+}
+
+// gc drops the results which were not refreshed for probeResultMaxAge, e.g. as their kubeconfig was rotated.
+func (c *probeCache) gc() {
+	if c == nil {
+		return
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for sha, e := range c.entries {
+		if !e.probedAt.IsZero() && c.now().Sub(e.probedAt) > probeResultMaxAge {
+			delete(c.entries, sha)
+		}
+	}
+}
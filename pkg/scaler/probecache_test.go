@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("probeCache", func() {
+	var (
+		c      *probeCache
+		now    time.Time
+		probes int32
+		period = 10 * time.Second
+		sha    = []byte("sha")
+	)
+
+	probe := func(err error) func() error {
+		return func() error {
+			atomic.AddInt32(&probes, 1)
+			return err
+		}
+	}
+
+	BeforeEach(func() {
+		now = time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+		c = newProbeCache()
+		c.now = func() time.Time { return now }
+		probes = 0
+	})
+
+	It("should share the result within the period", func() {
+		failure := errors.New("connection refused")
+		shared, err := c.probe(sha, "shoot--foo/kube-apiserver", period, probe(failure))
+		Expect(shared).To(BeFalse())
+		Expect(err).To(Equal(failure))
+		now = now.Add(5 * time.Second)
+		shared, err = c.probe(sha, "shoot--bar/kube-apiserver", period, probe(nil))
+		Expect(shared).To(BeTrue())
+		Expect(err).To(Equal(failure))
+		Expect(probes).To(Equal(int32(1)))
+
+		now = now.Add(5 * time.Second)
+		shared, err = c.probe(sha, "shoot--bar/kube-apiserver", period, probe(nil))
+		Expect(shared).To(BeFalse())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(probes).To(Equal(int32(2)))
+	})
+
+	It("should not serve a prober its own result", func() {
+		Expect(c.probe(sha, "shoot--foo/kube-apiserver", period, probe(nil))).To(BeFalse())
+		now = now.Add(time.Second)
+		Expect(c.probe(sha, "shoot--foo/kube-apiserver", period, probe(nil))).To(BeFalse())
+		Expect(probes).To(Equal(int32(2)))
+	})
+
+	It("should probe endpoints with different kubeconfigs separately", func() {
+		Expect(c.probe([]byte("internal"), "shoot--foo/kube-apiserver", period, probe(nil))).To(BeFalse())
+		Expect(c.probe([]byte("external"), "shoot--bar/kube-apiserver", period, probe(nil))).To(BeFalse())
+		Expect(probes).To(Equal(int32(2)))
+	})
+
+	It("should not share results without a kubeconfig checksum", func() {
+		Expect(c.probe(nil, "shoot--foo/kube-apiserver", period, probe(nil))).To(BeFalse())
+		Expect(c.probe(nil, "shoot--bar/kube-apiserver", period, probe(nil))).To(BeFalse())
+		var none *probeCache
+		Expect(none.probe(sha, "shoot--bar/kube-apiserver", period, probe(nil))).To(BeFalse())
+		Expect(probes).To(Equal(int32(3)))
+	})
+
+	It("should probe concurrent probers only once", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, _ = c.probe(sha, fmt.Sprintf("shoot--%d/kube-apiserver", i), period, probe(nil))
+			}(i)
+		}
+		wg.Wait()
+		Expect(probes).To(Equal(int32(1)))
+	})
+
+	It("should drop stale results", func() {
+		Expect(c.probe(sha, "shoot--foo/kube-apiserver", period, probe(nil))).To(BeFalse())
+		c.gc()
+		Expect(c.entries).To(HaveLen(1))
+		now = now.Add(probeResultMaxAge + time.Second)
+		c.gc()
+		Expect(c.entries).To(BeEmpty())
+	})
+
+	It("should really probe on every run of a scheduled prober", func() {
+		c = newProbeCache()
+		s := newProbeScheduler()
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		go s.run(1, stopCh)
+
+		var runs, sharedRuns int32
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s.schedule(ctx, "shoot--foo/kube-apiserver", 100*time.Millisecond, func(ctx context.Context) (time.Duration, error) {
+			shared, _ := c.probe(sha, "shoot--foo/kube-apiserver", 100*time.Millisecond, func() error {
+				time.Sleep(20 * time.Millisecond)
+				return nil
+			})
+			if shared {
+				atomic.AddInt32(&sharedRuns, 1)
+			}
+			atomic.AddInt32(&runs, 1)
+			return 0, nil
+		}, func(error) {})
+
+		Eventually(func() int32 { return atomic.LoadInt32(&runs) }, 2*time.Second).Should(BeNumerically(">=", 5))
+		Expect(atomic.LoadInt32(&sharedRuns)).To(BeZero())
+	})
+})
@@ -7,29 +7,32 @@ package scaler
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/gardener/dependency-watchdog/pkg/maintenance"
 	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
-	extensionscontroller "github.com/gardener/gardener/extensions/pkg/controller"
-	gardenerlisterv1alpha1 "github.com/gardener/gardener/pkg/client/extensions/listers/extensions/v1alpha1"
 	"github.com/prometheus/client_golang/prometheus"
-	appsv1 "k8s.io/api/apps/v1"
 	autoscalingapi "k8s.io/api/autoscaling/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	listerappsv1 "k8s.io/client-go/listers/apps/v1"
 	listerv1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/scale"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog"
-	"k8s.io/utils/pointer"
 )
 
 type probeType int
@@ -40,42 +43,70 @@ const (
 
 	defaultInitialDelaySeconds = 30
 	defaultPeriodSeconds       = 10
+	fastProbeWindowAfterAction = time.Minute
 	defaultScaleTimeoutSeconds = 10
 	defaultProbeTimeoutSeconds = 30
 	defaultSuccessThreshold    = 1
 	defaultFailureThreshold    = 3
 	defaultMaxRetries          = 3
 	defaultJitterMaxFactor     = 0.2
-	defaultJitterSliding       = true
+
+	defaultScaleRefDependsOnTimeoutSeconds = 60
 
 	kindDeployment             = "Deployment"
+	kindStatefulSet            = "StatefulSet"
 	ignoreScalingAnnotationKey = "dependency-watchdog.gardener.cloud/ignore-scaling"
 )
 
 type prober struct {
-	namespace         string
-	mapper            apimeta.RESTMapper
-	secretLister      listerv1.SecretLister
-	clusterLister     gardenerlisterv1alpha1.ClusterLister
-	deploymentsLister listerappsv1.DeploymentLister
-	scaleInterface    scale.ScaleInterface
-	probeDeps         *api.ProbeDependants
-	initialDelay      time.Duration
-	initialDelayTimer *time.Timer
-	successThreshold  int32
-	failureThreshold  int32
-	internalSHA       []byte
-	externalSHA       []byte
-	internalClient    kubernetes.Interface
-	externalClient    kubernetes.Interface
-	internalResult    probeResult
-	externalResult    probeResult
-	resultCh          chan *probeResult
+	namespace             string
+	mapper                apimeta.RESTMapper
+	secretLister          listerv1.SecretLister
+	readiness             readinessSource
+	targets               *targetCaches
+	conflicts             *conflictDetector
+	circuitBreaker        *circuitBreaker
+	selfCheck             *selfCheck
+	stateStore            *stateStore
+	holdDowns             *holdDowns
+	maintenance           *maintenance.Checker
+	emergencyStop         *maintenance.EmergencyStop
+	scheduler             *probeScheduler
+	probeCache            *probeCache
+	dynamicClient         dynamic.Interface
+	scaleInterface        scale.ScaleInterface
+	probeDeps             *api.ProbeDependants
+	period                time.Duration
+	minPeriod             time.Duration
+	maxPeriod             time.Duration
+	currentPeriod         time.Duration
+	initialDelay          time.Duration
+	initialDelayUntil     time.Time
+	successThreshold      int32
+	failureThreshold      int32
+	evaluator             *windowEvaluator
+	cooldown              *cooldown
+	countedFailureClasses map[api.FailureClass]bool
+	internalSHA           []byte
+	externalSHA           []byte
+	internalClient        kubernetes.Interface
+	externalClient        kubernetes.Interface
+	internalResult        probeResult
+	externalResult        probeResult
+	resultCh              chan *probeResult
+	lastAction            string
+	lastActionTime        time.Time
+	runningAction         *scaleAction
+	persistedState        *probeState
+	status                atomic.Value // *probeState, see publishStatus
 }
 
 type probeResult struct {
-	lastError error
-	resultRun int32
+	lastError    error
+	failureClass api.FailureClass
+	resultRun    int32
+	window       []windowedResult
+	state        healthState
 }
 
 // get the internal and external client along with new SHA values for each one of them respectively
@@ -166,38 +197,14 @@ func (p *prober) getClientFromSecret(secretName string, oldSHA []byte) (kubernet
 	return client, newSHA, err
 }
 
-func (p *prober) shootNotReady() (bool, error) {
-	// The name of cluster is same as shoot's namespace
-	clusterName := p.namespace
-	cluster, err := p.clusterLister.Get(clusterName)
-	if err != nil {
-		klog.Errorf("Error getting cluster: %s, Err: %s", clusterName, err.Error())
-		return true, err
-	}
-	decoder, err := extensionscontroller.NewGardenDecoder()
-	if err != nil {
-		klog.Errorf("Error getting gardener decoder. Cluster: %s, Err: %s", clusterName, err.Error())
-		return true, err
-	}
-
-	shoot, err := extensionscontroller.ShootFromCluster(decoder, cluster)
-	if err != nil {
-		klog.Errorf("Error extracting shoot from cluster. Cluster: %s, Err: %s", clusterName, err.Error())
-		return true, err
-	}
-	if (shoot.Spec.Hibernation == nil || shoot.Spec.Hibernation.Enabled == nil || *shoot.Spec.Hibernation.Enabled == false) && shoot.Status.IsHibernated == false {
-		return false, nil
-	}
-	return true, nil
-}
-
 // tryAndRun runs a fresh prober only if either of the internal or external secrets changed and the shoot is in ready state.
 // It updates the client and SHA checksums in the prober if a fresh prober was indeed run.
 // It calls the prepareRun callback function to stop previous prober if any and to create a
-// fresh context or stop channel for the fresh prober.
+// fresh context for the fresh prober.
 // prepareRun is called only if a fresh prober is run.
-// It is a blocking function. When it returns, it cancels the probe, and removes the namespace key from probers memory map
-func (p *prober) tryAndRun(prepareRun func() (stopCh <-chan struct{}), cancelFn func(), enqueueFn func(), probeRunningFn func() bool) error {
+// It does not block. The fresh prober is run by the scheduler until its context is cancelled or it fails,
+// which cancels the probe, and removes the namespace key from probers memory map.
+func (p *prober) tryAndRun(prepareRun func() context.Context, cancelFn func(), enqueueFn func(), probeRunningFn func() bool) error {
 	if p == nil || p.probeDeps == nil || p.probeDeps.Probe == nil {
 		return errors.New("Invalid empty probe dependants configuration")
 	}
@@ -211,9 +218,9 @@ func (p *prober) tryAndRun(prepareRun func() (stopCh <-chan struct{}), cancelFn
 	// Any errors returned shall be evaluated on checks defined in subsequent section.
 	internalClient, externalClient, internalSHA, externalSHA, internalErr, externalErr := p.getClients()
 
-	shootNotReady, err := p.shootNotReady()
-	if (shootNotReady && err == nil) || apierrors.IsNotFound(internalErr) || apierrors.IsNotFound(externalErr) {
-		klog.V(4).Infof("Cluster not ready: %v, internal err %v, external err %v", shootNotReady, internalErr, externalErr)
+	notReadyReason, err := p.readiness.notReadyReason(p.namespace)
+	if (notReadyReason != "" && err == nil) || apierrors.IsNotFound(internalErr) || apierrors.IsNotFound(externalErr) {
+		klog.V(4).Infof("Cluster not ready: %q, internal err %v, external err %v", notReadyReason, internalErr, externalErr)
 		// If shoot is not ready or secrets are not found, cancel any probe that might be running
 		// No need to enqueqe; the key will be enqueued again when any of the above condition changes anyway
 		cancelFn()
@@ -245,20 +252,27 @@ func (p *prober) tryAndRun(prepareRun func() (stopCh <-chan struct{}), cancelFn
 	}
 
 	// If we are here, then either secrets were created/updated, or the cluster woke up from hibernation
-	// Run a fresh prober goroutine
-	stopCh := prepareRun()
-	// This will also delete prober from memory map if present
-	defer cancelFn()
+	// Run a fresh prober
+	ctx := prepareRun()
 
-	// prepareRun should have stopped previous prober goroutine.
+	// prepareRun should have stopped previous prober.
 	// So, there is no need for any synchronization here.
 	p.refreshClients(internalClient, externalClient, internalSHA, externalSHA)
 
-	return p.run(stopCh)
+	p.start(ctx, func(err error) {
+		// This will also delete prober from memory map if present
+		cancelFn()
+		if err != nil {
+			klog.Errorf("Probe %s/%s stopped with error: %s", p.namespace, p.probeDeps.Name, err)
+			return
+		}
+		klog.Infof("Finished the probe in the namespace %s: %v", p.namespace, p.probeDeps.Name)
+	})
+	return nil
 }
 
-// run actually runs the prober logic. It is a blocking function. It should be called only via tryAndRun.
-func (p *prober) run(stopCh <-chan struct{}) error {
+// start sets up the prober and schedules its periodic probes. It should be called only via tryAndRun.
+func (p *prober) start(ctx context.Context, done func(err error)) {
 	p.initialDelay = toDuration(p.probeDeps.Probe.InitialDelaySeconds, defaultInitialDelaySeconds)
 
 	if p.probeDeps.Probe.SuccessThreshold != nil {
@@ -273,30 +287,94 @@ func (p *prober) run(stopCh <-chan struct{}) error {
 		p.failureThreshold = defaultFailureThreshold
 	}
 
+	p.evaluator = newWindowEvaluator(p.probeDeps.Probe.Evaluation)
+	p.cooldown = newCooldown(p.probeDeps.Probe.Cooldown)
+	p.countedFailureClasses = countedFailureClassesOf(p.probeDeps.Probe)
+	p.runningAction = nil // the action of a previous run is cancelled with its context
+
 	dwdProbersTotal.With(nil).Inc()
 
-	var err error
-	d := toDuration(p.probeDeps.Probe.PeriodSeconds, defaultPeriodSeconds)
-	ctx, cancelFn := context.WithCancel(context.Background())
-	defer cancelFn()
-	wait.JitterUntilWithContext(ctx, func(ctx context.Context) {
-		select {
-		case <-stopCh:
-			return
-		default:
-			if p.initialDelayTimer != nil {
-				<-p.initialDelayTimer.C
-				p.initialDelayTimer.Stop()
-				p.initialDelayTimer = nil
-			}
-			if err = p.probe(ctx); err != nil {
-				cancelFn()
-				return
-			}
+	p.restoreState()
+	p.restoreHoldDown()
+
+	p.period, p.minPeriod, p.maxPeriod = periodsOf(p.probeDeps.Probe)
+	p.currentPeriod = p.period
+	cooldown := p.cooldown
+	p.scheduler.schedule(ctx, p.key(), p.period, p.tick, func(err error) {
+		cooldown.release()
+		done(err)
+	})
+}
+
+// key identifies the prober by its namespace and probe name.
+func (p *prober) key() string {
+	return p.namespace + "/" + p.probeDeps.Name
+}
+
+// tick runs a single probe unless the initial delay after the internal probe turned unhealthy has not yet elapsed.
+// It returns the period after which the next probe is due.
+func (p *prober) tick(ctx context.Context) (time.Duration, error) {
+	if !p.initialDelayUntil.IsZero() {
+		if time.Now().Before(p.initialDelayUntil) {
+			return p.nextPeriod(), nil
 		}
-	}, d, defaultJitterMaxFactor, defaultJitterSliding)
+		p.initialDelayUntil = time.Time{}
+	}
+	if err := p.probe(ctx); err != nil {
+		return 0, err
+	}
+	return p.nextPeriod(), nil
+}
 
-	return err
+// nextPeriod adapts the period to the state of the probes. It is the minimum period while the probes need
+// attention and doubles up to the maximum period while both probes are steadily healthy.
+func (p *prober) nextPeriod() time.Duration {
+	period := p.period
+	switch {
+	case p.needsAttention():
+		period = p.minPeriod
+	case p.isHealthy(&p.internalResult) && p.isHealthy(&p.externalResult):
+		if p.currentPeriod >= p.period {
+			period = p.currentPeriod * 2
+		}
+		if period > p.maxPeriod {
+			period = p.maxPeriod
+		}
+	}
+	if period != p.currentPeriod {
+		klog.V(4).Infof("%s/%s: probing every %s", p.probeDeps.Name, p.namespace, period)
+	}
+	p.currentPeriod = period
+	return period
+}
+
+// needsAttention checks if the results of the probes are transitioning, if the internal and external results
+// disagree, if a scale action is running or if the dependants were scaled recently. With a sliding window
+// evaluation the results are also transitioning while the window disagrees with the latched state.
+func (p *prober) needsAttention() bool {
+	internalSettled := p.isHealthy(&p.internalResult) || p.isUnhealthy(&p.internalResult)
+	externalSettled := p.isHealthy(&p.externalResult) || p.isUnhealthy(&p.externalResult)
+	switch {
+	case p.runningAction != nil:
+		return true
+	case p.evaluator.unsettled(&p.internalResult) || p.evaluator.unsettled(&p.externalResult):
+		return true
+	case !internalSettled:
+		return true
+	case p.isHealthy(&p.internalResult) && !externalSettled:
+		return true
+	case p.isHealthy(&p.internalResult) != p.isHealthy(&p.externalResult):
+		return true
+	}
+	return !p.lastActionTime.IsZero() && time.Since(p.lastActionTime) < fastProbeWindowAfterAction
+}
+
+// periodsOf returns the period of the probe and the bounds of its adaptive period.
+func periodsOf(probe *api.ProbeConfig) (period, minPeriod, maxPeriod time.Duration) {
+	period = toDuration(probe.PeriodSeconds, defaultPeriodSeconds)
+	minPeriod = toDuration(probe.MinPeriodSeconds, int32(period/time.Second))
+	maxPeriod = toDuration(probe.MaxPeriodSeconds, int32(period/time.Second))
+	return period, minPeriod, maxPeriod
 }
 
 func toDuration(seconds *int32, defaultSeconds int32) time.Duration {
@@ -307,10 +385,16 @@ func toDuration(seconds *int32, defaultSeconds int32) time.Duration {
 }
 
 func (p *prober) isHealthy(pr *probeResult) bool {
+	if p.evaluator != nil {
+		return pr.state == healthHealthy
+	}
 	return pr.lastError == nil && pr.resultRun >= p.successThreshold
 }
 
 func (p *prober) isUnhealthy(pr *probeResult) bool {
+	if p.evaluator != nil {
+		return pr.state == healthUnhealthy
+	}
 	return pr.lastError != nil && pr.resultRun >= p.failureThreshold
 }
 
@@ -338,20 +422,37 @@ func (p *prober) getProbeResultLabels(pr *probeResult) prometheus.Labels {
 // 5. Unless the internal probe is HEALTHY, no external probes are done. Also,
 // no actions are taken on the dependants.
 // 6. If the external probe is HEALTHY then the dependants are scaled up.
-// 7. If the external probe is UNHEALTHY then the dependants are scaled down.
+// 7. If the external probe is UNHEALTHY then the dependants are scaled down unless
+// the self-check of the network path of the dependency-watchdog fails (observer unhealthy)
+// or the seed-wide circuit breaker is tripped.
+// 8. Transitions between scaling down and up are suppressed while the cooldowns of the probe have not elapsed
+// or the dependants are flapping.
+// 9. Probers sharing the kubeconfig of an endpoint share the result of its probe,
+// so that every endpoint is probed at most once per period.
+// 10. The scale actions run in the background. No other action is started while one is still running and
+// a failed action stops the prober.
 func (p *prober) probe(ctx context.Context) error {
+	defer p.persistState()
+	defer p.publishStatus()
+
+	if err := p.collectAction(); err != nil {
+		return err
+	}
+
 	internalProbeMsg := fmt.Sprintf("%s/%s/internal", p.probeDeps.Name, p.namespace)
-	err := p.doProbe(internalProbeMsg, p.internalClient, &p.internalResult)
+	shared, err := p.probeCache.probe(p.internalSHA, p.key(), p.currentPeriod, func() error {
+		return p.doProbe(internalProbeMsg, p.internalClient, &p.internalResult)
+	})
 	p.handleError(&p.internalResult, err, internalProbeMsg)
+	if !shared {
+		countProbeFailure(probeTypeInternal, err)
+	}
 
 	dwdInternalProbesTotal.With(p.getProbeResultLabels(&p.internalResult)).Inc()
 
 	if p.isUnhealthy(&p.internalResult) {
 		klog.V(3).Infof("%s/%s/internal is unhealthy. Activating initial delay.", p.probeDeps.Name, p.namespace)
-		if p.initialDelayTimer != nil {
-			p.initialDelayTimer.Stop()
-		}
-		p.initialDelayTimer = time.NewTimer(p.initialDelay)
+		p.initialDelayUntil = time.Now().Add(p.initialDelay)
 		return nil // Short-circuit external probe if the internal one fails
 	}
 
@@ -360,27 +461,82 @@ func (p *prober) probe(ctx context.Context) error {
 		return nil //  Short-circuit external probe if the internal one fails
 	}
 
-	if p.initialDelayTimer != nil {
-		p.initialDelayTimer.Stop()
-		p.initialDelayTimer = nil
-	}
+	p.initialDelayUntil = time.Time{}
 
 	externalProbeMsg := fmt.Sprintf("%s/%s/external", p.probeDeps.Name, p.namespace)
-	err = p.doProbe(externalProbeMsg, p.externalClient, &p.externalResult)
+	shared, err = p.probeCache.probe(p.externalSHA, p.key(), p.currentPeriod, func() error {
+		return p.doProbe(externalProbeMsg, p.externalClient, &p.externalResult)
+	})
 	p.handleError(&p.externalResult, err, externalProbeMsg)
+	if !shared {
+		countProbeFailure(probeTypeExternal, err)
+	}
 
 	dwdExternalProbesTotal.With(p.getProbeResultLabels(&p.externalResult)).Inc()
 
 	if p.isHealthy(&p.externalResult) {
-		return p.scaleUp(ctx)
+		p.circuitBreaker.recordExternalResult(p.namespace, p.probeDeps.Name, true)
+		p.cooldown.observe(actionScaleUp, time.Now())
+		if reason := p.standDownReason(); reason != "" {
+			klog.V(3).Infof("%s/%s/external is healthy but %s. Skipping the scale up.", p.probeDeps.Name, p.namespace, reason)
+			return nil
+		}
+		if !p.cooldownAllows(actionScaleUp) {
+			return nil
+		}
+		// Adopt the targets scaled down before they were marked if the dependants were last scaled down.
+		adoptUnmarked := p.lastAction == actionScaleDown
+		p.act(ctx, actionScaleUp, func(ctx context.Context) (bool, error) {
+			return p.scaleUp(ctx, adoptUnmarked)
+		})
+		return nil
 	}
 	if p.isUnhealthy(&p.externalResult) {
-		return p.scaleDown(ctx)
+		p.cooldown.observe(actionScaleDown, time.Now())
+		if reason := p.standDownReason(); reason != "" {
+			klog.Warningf("%s/%s/external is unhealthy but %s. Skipping the scale down.", p.probeDeps.Name, p.namespace, reason)
+			return nil
+		}
+		// Record the result even if the observer is unhealthy, so that the circuit breaker still sees the outage.
+		p.circuitBreaker.recordExternalResult(p.namespace, p.probeDeps.Name, false)
+		if err := p.selfCheck.check(); err != nil {
+			klog.Warningf("%s/%s/external is unhealthy but the observer is unhealthy too. Skipping the scale down.", p.probeDeps.Name, p.namespace)
+			return nil
+		}
+		if !p.circuitBreaker.allowScaleDown(p.namespace) {
+			klog.Warningf("%s/%s/external is unhealthy but the circuit breaker is tripped. Skipping the scale down.", p.probeDeps.Name, p.namespace)
+			return nil
+		}
+		if !p.cooldownAllows(actionScaleDown) {
+			return nil
+		}
+		p.act(ctx, actionScaleDown, p.scaleDown)
+		return nil
 	}
 
 	return nil
 }
 
+// standDownReason returns why the prober must not act on its probe results or an empty string if it may.
+// The readiness is checked again as it may have changed since the prober was started.
+func (p *prober) standDownReason() string {
+	if p.emergencyStop.Suspended() {
+		return "all actions are suspended"
+	}
+	if p.maintenance.Paused(p.namespace) {
+		return "the namespace is paused"
+	}
+	if p.readiness == nil {
+		return ""
+	}
+	reason, err := p.readiness.notReadyReason(p.namespace)
+	if err != nil {
+		// act on the probe results as before if the readiness cannot be checked
+		return ""
+	}
+	return reason
+}
+
 func (p *prober) doProbe(msg string, client kubernetes.Interface, pr *probeResult) error {
 	var (
 		err        error
@@ -403,14 +559,17 @@ func (p *prober) doProbe(msg string, client kubernetes.Interface, pr *probeResul
 
 // handleError processing the err message for a given probe and decides -
 // . 1. If the secrets are rotated it update the clients used by the probes
-// . 2. If the requests are throttled it doesn't mean the API Server is down so it just logs and relies on the next sync.
-//   3. If it is any other error then it logs the error and increments the result run if still under failure threshold configured.
+// . 2. If the class of the failure does not count, e.g. as the requests are throttled which doesn't mean the API Server
+// .    is down, it just logs the class and relies on the next sync.
+// . 3. If it is any other error then it logs the error with its class and increments the result run if still under
+// .    failure threshold configured. With a sliding window evaluation the result is also added to the window of the probe.
 func (p *prober) handleError(pr *probeResult, err error, msg string) {
+	class := classifyProbeError(err)
 	if p.checkSecretsRotated(err, &p.internalResult) {
 		p.updateClientsSecrets(&p.internalResult, msg)
 		return
-	} else if p.checkThrottled(err) {
-		klog.V(4).Infof("%s: Probe skipped as it experienced throttling. Will be retried..", msg)
+	} else if err != nil && !p.countsFailure(class) {
+		klog.V(4).Infof("%s: Probe skipped as its %s failure does not count: %s. Will be retried..", msg, class, err.Error())
 		return
 	}
 
@@ -419,11 +578,13 @@ func (p *prober) handleError(pr *probeResult, err error, msg string) {
 	}
 
 	pr.lastError = err
+	pr.failureClass = class
 	if pr.resultRun <= p.successThreshold || pr.resultRun <= p.failureThreshold { // Prevents overflow
 		pr.resultRun++
 	}
+	p.evaluator.record(pr, err != nil, time.Now())
 	if pr.lastError != nil {
-		klog.Errorf("%s: Probe finished with error %s for resultRun:%d", msg, pr.lastError.Error(), pr.resultRun)
+		klog.Errorf("%s: Probe finished with %s error %s for resultRun:%d", msg, pr.failureClass, pr.lastError.Error(), pr.resultRun)
 	} else {
 		klog.V(4).Infof("%s: Probe finished successfully for rusultRun:%d", msg, pr.resultRun)
 	}
@@ -440,13 +601,6 @@ func (p *prober) checkSecretsRotated(err error, pr *probeResult) bool {
 
 }
 
-func (p *prober) checkThrottled(err error) bool {
-	if apierrors.IsTooManyRequests(err) {
-		return true
-	}
-	return false
-}
-
 func (p *prober) updateClientsSecrets(pr *probeResult, msg string) {
 	// get the client to get the secret
 	internalClient, externalClient, internalSHA, externalSHA, internalErr, externalErr := p.getClients()
@@ -465,86 +619,218 @@ func (p *prober) updateClientsSecrets(pr *probeResult, msg string) {
 
 }
 
-func ignoreScalingDeployment(d *appsv1.Deployment) bool {
-	if val, ok := d.Annotations[ignoreScalingAnnotationKey]; ok {
+func ignoreScaling(annotations map[string]string) bool {
+	if val, ok := annotations[ignoreScalingAnnotationKey]; ok {
 		return val == "true"
 	}
 
 	return false
 }
 
-func retry(msg string, fn func() error, retries int) error {
+// defaultScaleBackoff is the backoff between the attempts to scale a target.
+var defaultScaleBackoff = wait.Backoff{
+	Duration: 500 * time.Millisecond,
+	Factor:   2,
+	Jitter:   defaultJitterMaxFactor,
+	Steps:    defaultMaxRetries,
+}
+
+// retryScaling calls fn until it succeeds, the backoff steps are exhausted or the context is cancelled.
+// Conflicts are expected if the target changed concurrently and are retried right after the backoff as fn
+// re-reads the target. Throttled attempts wait at least as long as suggested by the server. Targets that
+// are not found are not retried. Any other error is retried after the backoff.
+func retryScaling(ctx context.Context, msg string, fn func() error, backoff wait.Backoff) error {
 	var err error
-	for ; retries > 0; retries-- {
-		err = fn()
-		if err == nil {
+	for backoff.Steps > 0 {
+		if err = fn(); err == nil {
 			return nil
 		}
-		klog.Warningf("%s: %s. %d retries remaining...", msg, err, retries)
-	}
 
-	return err
-}
-
-func (p *prober) scaleTo(parentContext context.Context, msg string, replicas int32, checkFn func(oReplicas, nReplicas int32) bool) error {
-	timeout := toDuration(p.probeDeps.Probe.TimeoutSeconds, defaultScaleTimeoutSeconds)
-	for _, dsd := range p.probeDeps.DependantScales {
-		if dsd == nil {
-			continue
+		delay := backoff.Step()
+		switch {
+		case apierrors.IsConflict(err):
+			dwdScaleErrorsTotal.With(prometheus.Labels{labelReason: reasonConflict}).Inc()
+			klog.V(4).Infof("%s: %s. %d retries remaining with the current state...", msg, err, backoff.Steps)
+		case isRateLimited(err) || apierrors.IsTooManyRequests(err):
+			dwdScaleErrorsTotal.With(prometheus.Labels{labelReason: reasonThrottled}).Inc()
+			if seconds, ok := apierrors.SuggestsClientDelay(err); ok && time.Duration(seconds)*time.Second > delay {
+				delay = time.Duration(seconds) * time.Second
+			}
+			klog.Warningf("%s: throttled: %s. %d retries remaining...", msg, err, backoff.Steps)
+		case apierrors.IsNotFound(err):
+			dwdScaleErrorsTotal.With(prometheus.Labels{labelReason: reasonOther}).Inc()
+			return err
+		default:
+			dwdScaleErrorsTotal.With(prometheus.Labels{labelReason: reasonOther}).Inc()
+			klog.Warningf("%s: %s. %d retries remaining...", msg, err, backoff.Steps)
 		}
 
-		ds := dsd.ScaleRef
-		if replicas > 0 && dsd.Replicas != nil {
-			replicas = *dsd.Replicas
+		if backoff.Steps == 0 {
+			break
 		}
+		if err := sleepWithContext(ctx, delay); err != nil {
+			return err
+		}
+	}
 
-		prefix := fmt.Sprintf("%s: %s.%s/%s", msg, ds.APIVersion, ds.Kind, ds.Name)
-
-		klog.V(5).Infof("%s: replicas=%d: in progress...", prefix, replicas)
+	return err
+}
 
-		// if possible check from the cache if the target needs to be scaled
-		if ds.APIVersion == appsv1.SchemeGroupVersion.String() && ds.Kind == kindDeployment {
-			dwdGetTargetFromCacheTotal.With(prometheus.Labels{labelResource: resourceDeployments}).Inc()
+// scaleTo scales the dependant scales to the given replicas along their dependency graph.
+// While scaling up, a dependant scale is scaled only after all the dependant scales it depends on are processed.
+// While scaling down, the order is reversed. Dependant scales that do not depend on each other are scaled in parallel.
+// If a dependant scale fails to scale, the dependant scales which have to be scaled after it are skipped.
+// It returns whether it scaled any of the dependant scales.
+func (p *prober) scaleTo(parentContext context.Context, msg string, replicas int32, adoptUnmarked bool, checkFn func(oReplicas, nReplicas int32) bool) (bool, error) {
+	g, err := newScaleGraph(p.probeDeps.DependantScales)
+	if err != nil {
+		return false, err
+	}
 
-			if d, err := p.deploymentsLister.Deployments(p.namespace).Get(ds.Name); err != nil {
-				klog.Errorf("%s: Skipped as target reference: %s", prefix, err)
-				klog.V(5).Infof("%s: replicas=%d: failed", prefix, replicas)
-				continue
+	var (
+		scaleUp = replicas > 0
+		wg      sync.WaitGroup
+		done    = make([]chan struct{}, len(g.nodes))
+		scaled  = make([]bool, len(g.nodes))
+		errs    = make([]error, len(g.nodes))
+		// failed is set for the nodes which failed or were skipped as one of their predecessors failed, so that
+		// the rest of their branch of the graph is skipped as well.
+		failed = make([]bool, len(g.nodes))
+	)
+	for i := range g.nodes {
+		done[i] = make(chan struct{})
+	}
+	for i := range g.nodes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer close(done[i])
+
+			var dependsOn []autoscalingapi.CrossVersionObjectReference
+			if scaleUp {
+				dependsOn = g.nodes[i].ScaleRefDependsOn
 			} else {
-				if ignoreScalingDeployment(d) {
-					klog.V(4).Infof("%s: skipped because annotation %s present on deployment", prefix, ignoreScalingAnnotationKey)
-					continue
+				for _, j := range g.dependants[i] {
+					dependsOn = append(dependsOn, g.nodes[j].ScaleRef)
 				}
-				var specReplicas = int32(0)
-				if d.Spec.Replicas != nil {
-					specReplicas = *(d.Spec.Replicas)
+			}
+			for _, j := range g.predecessors(i, scaleUp) {
+				select {
+				case <-done[j]:
+				case <-parentContext.Done():
+					failed[i] = true
+					return
 				}
-				if !checkFn(specReplicas, replicas) {
-					klog.V(4).Infof("%s: skipped because desired=%d and current=%d", prefix, replicas, specReplicas)
-					continue
+				if failed[j] {
+					ds, pd := g.nodes[i].ScaleRef, g.nodes[j].ScaleRef
+					klog.Errorf("%s: %s.%s/%s: Skipped as %s.%s/%s failed to scale", msg, ds.APIVersion, ds.Kind, ds.Name, pd.APIVersion, pd.Kind, pd.Name)
+					failed[i] = true
+					return
 				}
 			}
-		}
+			scaled[i], errs[i] = p.scaleTarget(parentContext, msg, g.nodes[i], dependsOn, replicas, adoptUnmarked, checkFn)
+			failed[i] = errs[i] != nil
+		}(i)
+	}
+	wg.Wait()
 
-		// load the target scale subresource
-		// TODO avoid the second get
-		gv, err := schema.ParseGroupVersion(ds.APIVersion)
+	for _, err := range errs {
 		if err != nil {
-			return err
+			return false, err
+		}
+	}
+	for _, s := range scaled {
+		if s {
+			return true, nil
 		}
+	}
+	return false, nil
+}
+
+// scaleTarget scales a single dependant scale to the given replicas if its dependsOn targets are in the desired state.
+// Unmarked targets at 0 are only scaled up if adoptUnmarked is set, see ownsTarget. It returns whether it scaled the target.
+func (p *prober) scaleTarget(parentContext context.Context, msg string, dsd *api.DependantScaleDetails, dependsOn []autoscalingapi.CrossVersionObjectReference, replicas int32, adoptUnmarked bool, checkFn func(oReplicas, nReplicas int32) bool) (bool, error) {
+	timeout := toDuration(p.probeDeps.Probe.TimeoutSeconds, defaultScaleTimeoutSeconds)
+	ds := dsd.ScaleRef
+	if replicas > 0 && dsd.Replicas != nil {
+		replicas = *dsd.Replicas
+	}
+
+	prefix := fmt.Sprintf("%s: %s.%s/%s", msg, ds.APIVersion, ds.Kind, ds.Name)
+
+	klog.V(5).Infof("%s: replicas=%d: in progress...", prefix, replicas)
+
+	gv, err := schema.ParseGroupVersion(ds.APIVersion)
+	if err != nil {
+		return false, err
+	}
+
+	gk := schema.GroupKind{
+		Group: gv.Group,
+		Kind:  ds.Kind,
+	}
 
-		gk := schema.GroupKind{
-			Group: gv.Group,
-			Kind:  ds.Kind,
+	// if possible check from the cache if the target needs to be scaled
+	t, err := p.targets.get(p.namespace, ds)
+	if err == errNoTargetCache {
+		if dsd.ReplicasPath != "" {
+			klog.Errorf("%s: Skipped as there is no informer cache for the target", prefix)
+			return false, nil
+		}
+		klog.V(5).Infof("%s: no informer cache for the target, checking its scale sub-resource", prefix)
+		t = nil
+	} else if err != nil {
+		klog.Errorf("%s: Skipped as target reference: %s", prefix, err)
+		klog.V(5).Infof("%s: replicas=%d: failed", prefix, replicas)
+		return false, nil
+	} else {
+		if ignoreScaling(t.annotations) {
+			klog.V(4).Infof("%s: skipped because annotation %s present on target", prefix, ignoreScalingAnnotationKey)
+			return false, nil
 		}
+		if replicas > 0 && !p.ownsTarget(t.annotations, t.specReplicas, adoptUnmarked) {
+			klog.V(4).Infof("%s: skipped because the target was not scaled down by the dependency-watchdog", prefix)
+			return false, nil
+		}
+		if !checkFn(t.specReplicas, replicas) {
+			klog.V(4).Infof("%s: skipped because desired=%d and current=%d", prefix, replicas, t.specReplicas)
+			if replicas > 0 {
+				// The target was scaled up by someone else. Give up the ownership.
+				p.resumeScalers(prefix, dsd)
+				return false, p.unmarkTarget(gk, gv.Version, ds.Name, prefix)
+			}
+			return false, nil
+		}
+	}
 
+	var (
+		gvr       schema.GroupVersionResource
+		scalingFn func() (bool, error)
+	)
+	if dsd.ReplicasPath != "" {
+		// the target has no scale subresource, patch its replicas field instead
+		dwdScaleRequestsTotal.With(prometheus.Labels{labelVerb: verbDiscovery}).Inc()
+		m, err := p.mapper.RESTMapping(gk, gv.Version)
+		if err != nil {
+			if isRateLimited(err) {
+				dwdThrottledScaleRequestsTotal.With(prometheus.Labels{labelVerb: verbDiscovery}).Inc()
+			}
+			return false, err
+		}
+		gvr = m.Resource
+		if scalingFn, err = p.getPatchingFn(gvr, ds.Name, parseFieldPath(dsd.ReplicasPath), replicas); err != nil {
+			return false, err
+		}
+	} else {
+		// load the target scale subresource
+		// TODO avoid the second get
 		dwdScaleRequestsTotal.With(prometheus.Labels{labelVerb: verbDiscovery}).Inc()
 		ms, err := p.mapper.RESTMappings(gk)
 		if err != nil {
 			if isRateLimited(err) {
 				dwdThrottledScaleRequestsTotal.With(prometheus.Labels{labelVerb: verbDiscovery}).Inc()
 			}
-			return err
+			return false, err
 		}
 
 		var (
@@ -552,7 +838,8 @@ func (p *prober) scaleTo(parentContext context.Context, msg string, replicas int
 			s  *autoscalingapi.Scale
 		)
 		for _, m := range ms {
-			gr = m.Resource.GroupResource()
+			gvr = m.Resource
+			gr = gvr.GroupResource()
 			_, cancelFn := context.WithTimeout(parentContext, timeout)
 			s, err = p.scaleInterface.Get(gr, ds.Name)
 			cancelFn()
@@ -567,117 +854,330 @@ func (p *prober) scaleTo(parentContext context.Context, msg string, replicas int
 			}
 		}
 
-		if err == nil {
-			if !checkFn(s.Spec.Replicas, replicas) {
-				klog.V(4).Infof("%s: skipped because desired=%d and current=%d", prefix, replicas, s.Spec.Replicas)
-				continue
-			}
-			/*
-				Check if the scaled objects has defined any delays for the operation.
-				scaleUpDelay is the delay in seconds to wait before initiating scaleUp to ensures that the resource is scaled up after allowing sufficient time for system to recover.
-				scaleDownDelay is the delay in seconds to wait before initiating scaleDown to ensure that the resource is scaled down after allowing its dependents room to react.
-			*/
-			var depChecked bool
-			// Check for scaleUp delays
-			if replicas > 0 {
-				if dsd.ScaleUpDelaySeconds != nil {
-					klog.V(4).Infof("Delaying scale up of %s by %d seconds to allow state of resources it depends on to be updated. \n", dsd.ScaleRef.Name, *dsd.ScaleUpDelaySeconds)
-					time.Sleep(toDuration(dsd.ScaleUpDelaySeconds, 0))
-				}
-				depChecked = p.checkScaleRefDependsOn(parentContext, fmt.Sprintf("Checking dependents of %s before scaleUp", dsd.ScaleRef.Name), dsd.ScaleRefDependsOn, replicas, checkFn)
-
-			} else if replicas == 0 { // check for scaleDown delays
-				if dsd.ScaleDownDelaySeconds != nil {
-					klog.V(4).Infof("Delaying scale down of %s by %d seconds to allow state to resources it depends on to be updated. \n", dsd.ScaleRef.Name, *dsd.ScaleDownDelaySeconds)
-					time.Sleep(toDuration(dsd.ScaleDownDelaySeconds, 0))
-				}
-				depChecked = p.checkScaleRefDependsOn(parentContext, fmt.Sprintf("Checking dependents of %s before scaleDown", dsd.ScaleRef.Name), dsd.ScaleRefDependsOn, replicas, checkFn)
+		if err != nil {
+			klog.Errorf("%s: Could not get target reference: %s", prefix, err)
+			klog.Errorf("%s: replicas=%d: failed", prefix, replicas)
+			return false, nil
+		}
 
-			} else {
-				klog.Errorf("%s: Replicas has a unsupported value %d\n", prefix, replicas)
+		if t == nil && replicas > 0 {
+			annotations, err := p.getAnnotations(gvr, ds.Name)
+			if err != nil {
+				klog.Errorf("%s: Could not get target: %s", prefix, err)
+				return false, nil
 			}
-			if depChecked {
+			if !p.ownsTarget(annotations, s.Spec.Replicas, adoptUnmarked) {
+				klog.V(4).Infof("%s: skipped because the target was not scaled down by the dependency-watchdog", prefix)
+				return false, nil
+			}
+		}
 
-				if err = retry(msg, p.getScalingFn(parentContext, gr, s, replicas), defaultMaxRetries); err != nil {
-					klog.Errorf("%s: Error scaling : %s", prefix, err)
-				}
-				klog.Infof("%s: replicas=%d: successful", prefix, replicas)
-			} else {
-				klog.V(4).Infof("Check for dependents returned false. Skipping scaling")
+		if !checkFn(s.Spec.Replicas, replicas) {
+			klog.V(4).Infof("%s: skipped because desired=%d and current=%d", prefix, replicas, s.Spec.Replicas)
+			if replicas > 0 {
+				p.resumeScalers(prefix, dsd)
+				return false, p.unmarkTarget(gk, gv.Version, ds.Name, prefix)
 			}
+			return false, nil
+		}
+		scalingFn = p.getScalingFn(gr, ds.Name, replicas, checkFn)
+	}
+	pause, ok := p.resolveConflicts(prefix, dsd, t, replicas)
+	if !ok {
+		return false, nil
+	}
+	scalingFn = p.withOwnership(gvr, ds.Name, replicas, scalingFn)
+	/*
+		Check if the scaled objects has defined any delays for the operation.
+		scaleUpDelay is the delay in seconds to wait before initiating scaleUp to ensures that the resource is scaled up after allowing sufficient time for system to recover.
+		scaleDownDelay is the delay in seconds to wait before initiating scaleDown to ensure that the resource is scaled down after allowing its dependents room to react.
+	*/
+	var (
+		depChecked       bool
+		dependsOnTimeout = toDuration(dsd.ScaleRefDependsOnTimeoutSeconds, defaultScaleRefDependsOnTimeoutSeconds)
+	)
+	// Check for scaleUp delays
+	if replicas > 0 {
+		if dsd.ScaleUpDelaySeconds != nil {
+			klog.V(4).Infof("Delaying scale up of %s by %d seconds to allow state of resources it depends on to be updated. \n", dsd.ScaleRef.Name, *dsd.ScaleUpDelaySeconds)
+			if err := sleepWithContext(parentContext, toDuration(dsd.ScaleUpDelaySeconds, 0)); err != nil {
+				klog.V(4).Infof("%s: aborted while delaying scale up: %s", prefix, err)
+				return false, nil
+			}
+		}
+		depChecked = p.checkScaleRefDependsOn(parentContext, fmt.Sprintf("Checking dependencies of %s before scaleUp", dsd.ScaleRef.Name), dependsOn, replicas, checkFn, dependsOnTimeout)
+
+	} else if replicas == 0 { // check for scaleDown delays
+		if dsd.ScaleDownDelaySeconds != nil {
+			klog.V(4).Infof("Delaying scale down of %s by %d seconds to allow state to resources it depends on to be updated. \n", dsd.ScaleRef.Name, *dsd.ScaleDownDelaySeconds)
+			if err := sleepWithContext(parentContext, toDuration(dsd.ScaleDownDelaySeconds, 0)); err != nil {
+				klog.V(4).Infof("%s: aborted while delaying scale down: %s", prefix, err)
+				return false, nil
+			}
+		}
+		depChecked = p.checkScaleRefDependsOn(parentContext, fmt.Sprintf("Checking dependants of %s before scaleDown", dsd.ScaleRef.Name), dependsOn, replicas, checkFn, dependsOnTimeout)
+
+	} else {
+		klog.Errorf("%s: Replicas has a unsupported value %d\n", prefix, replicas)
+	}
+	if depChecked && p.emergencyStop.Suspended() {
+		// the emergency stop may have been activated while waiting for the delays and dependencies
+		klog.Warningf("%s: skipped because all actions are suspended", prefix)
+		return false, nil
+	}
+	if depChecked {
+		// the conflicting scalers are only paused once the target is about to be scaled down
+		if !p.pauseScalers(prefix, pause) {
+			return false, nil
+		}
+		var changed bool
+		if err = retryScaling(parentContext, prefix, func() (err error) {
+			changed, err = scalingFn()
+			return err
+		}, defaultScaleBackoff); err != nil {
+			klog.Errorf("%s: Error scaling : %s", prefix, err)
 		} else {
-			klog.Errorf("%s: Could not get target reference: %s", prefix, err)
-			klog.Errorf("%s: replicas=%d: failed", prefix, replicas)
+			klog.Infof("%s: replicas=%d: successful", prefix, replicas)
+			if replicas > 0 {
+				p.resumeScalers(prefix, dsd)
+			}
+			return changed, nil
 		}
+	} else {
+		klog.V(4).Infof("Check for dependents returned false. Skipping scaling")
 	}
 
-	return nil
+	return false, nil
 }
 
-func (p *prober) getScalingFn(parentContext context.Context, gr schema.GroupResource, s *autoscalingapi.Scale, replicas int32) func() error {
-	return func() error {
+// getScalingFn returns a function that scales the target through its scale sub-resource.
+// Every call re-reads the current scale so that updates are based on the latest resource version, and
+// re-checks with checkFn if the scaling is still needed. The scaling is skipped if it is not. The function reports
+// whether it changed the target.
+func (p *prober) getScalingFn(gr schema.GroupResource, name string, replicas int32, checkFn func(oReplicas, nReplicas int32) bool) func() (bool, error) {
+	return func() (bool, error) {
+		dwdScaleRequestsTotal.With(prometheus.Labels{labelVerb: verbGet}).Inc()
+
+		s, err := p.scaleInterface.Get(gr, name)
+		if err != nil {
+			if isRateLimited(err) {
+				dwdThrottledScaleRequestsTotal.With(prometheus.Labels{labelVerb: verbGet}).Inc()
+			}
+			return false, err
+		}
+		if !checkFn(s.Spec.Replicas, replicas) {
+			klog.V(4).Infof("Scaling %v %s/%s skipped because desired=%d and current=%d", gr, p.namespace, name, replicas, s.Spec.Replicas)
+			return false, nil
+		}
+
 		s = s.DeepCopy()
 		s.Spec.Replicas = replicas
 
-		timeout := toDuration(p.probeDeps.Probe.TimeoutSeconds, defaultScaleTimeoutSeconds)
-		_, cancelFn := context.WithTimeout(parentContext, timeout)
-		defer cancelFn()
-
 		dwdScaleRequestsTotal.With(prometheus.Labels{labelVerb: verbUpdate}).Inc()
 
-		_, err := p.scaleInterface.Update(gr, s)
+		_, err = p.scaleInterface.Update(gr, s)
 
 		if err != nil && isRateLimited(err) {
-			dwdThrottledScaleRequestsTotal.With(prometheus.Labels{labelVerb: verbGet}).Inc()
+			dwdThrottledScaleRequestsTotal.With(prometheus.Labels{labelVerb: verbUpdate}).Inc()
 		}
 
-		return err
+		return err == nil, err
 	}
 }
 
-func (p *prober) scaleDown(ctx context.Context) error {
-	return p.scaleTo(ctx, fmt.Sprintf("Scaling down dependents of %s/%s", p.probeDeps.Name, p.namespace), 0, func(o, n int32) bool {
+// getPatchingFn returns a function that scales the target by patching the field at the given path through the dynamic client.
+// The patch is applied unconditionally, so that the function always reports that it changed the target.
+func (p *prober) getPatchingFn(gvr schema.GroupVersionResource, name string, path []string, replicas int32) (func() (bool, error), error) {
+	patch := map[string]interface{}{}
+	if err := unstructured.SetNestedField(patch, int64(replicas), path...); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (bool, error) {
+		dwdScaleRequestsTotal.With(prometheus.Labels{labelVerb: verbPatch}).Inc()
+
+		_, err := p.dynamicClient.Resource(gvr).Namespace(p.namespace).Patch(name, types.MergePatchType, data, metav1.PatchOptions{})
+
+		if err != nil && isRateLimited(err) {
+			dwdThrottledScaleRequestsTotal.With(prometheus.Labels{labelVerb: verbPatch}).Inc()
+		}
+
+		return err == nil, err
+	}, nil
+}
+
+// scaleAction is a scale action of a prober. It runs on its own goroutine rather than on a probe worker, as it may
+// wait for the scale up delays and the dependsOn targets of the dependants for minutes.
+type scaleAction struct {
+	action string
+	done   chan struct{}
+	scaled bool  // set before done is closed
+	err    error // set before done is closed
+}
+
+// act starts the scale action unless another one is still running. It should be called only from probe.
+func (p *prober) act(ctx context.Context, action string, fn func(ctx context.Context) (bool, error)) {
+	if p.runningAction != nil {
+		klog.V(4).Infof("%s/%s: skipping the %s as the %s is still running", p.probeDeps.Name, p.namespace, action, p.runningAction.action)
+		return
+	}
+	a := &scaleAction{action: action, done: make(chan struct{})}
+	p.runningAction = a
+	go func() {
+		defer close(a.done)
+		a.scaled, a.err = fn(ctx)
+	}()
+}
+
+// collectAction records the running scale action once it finished and returns its error if it failed.
+func (p *prober) collectAction() error {
+	a := p.runningAction
+	if a == nil {
+		return nil
+	}
+	select {
+	case <-a.done:
+	default:
+		return nil
+	}
+	p.runningAction = nil
+	if a.err != nil {
+		return a.err
+	}
+	if a.scaled {
+		p.recordAction(a.action)
+	}
+	return nil
+}
+
+// scaleDown scales the dependants down. It runs on the goroutine of a scaleAction and returns whether it scaled any
+// of them.
+func (p *prober) scaleDown(ctx context.Context) (bool, error) {
+	scaled, err := p.scaleTo(ctx, fmt.Sprintf("Scaling down dependents of %s/%s", p.probeDeps.Name, p.namespace), 0, false, func(o, n int32) bool {
 		return o > n // scale to at most n
 	})
+	if err != nil {
+		return false, err
+	}
+	if scaled {
+		p.holdDowns.set(p.namespace, p.probeDeps, true)
+	}
+	return scaled, nil
 }
 
-func (p *prober) scaleUp(ctx context.Context) error {
-	return p.scaleTo(ctx, fmt.Sprintf("Scaling up dependents of %s/%s", p.probeDeps.Name, p.namespace), 1, func(o, n int32) bool {
+// scaleUp scales the dependants up. It runs on the goroutine of a scaleAction and returns whether it scaled any
+// of them. Unmarked targets at 0 are adopted if adoptUnmarked is set.
+func (p *prober) scaleUp(ctx context.Context, adoptUnmarked bool) (bool, error) {
+	// Release the hold on the dependants first so that the scale up webhook admits the scale up.
+	p.holdDowns.set(p.namespace, p.probeDeps, false)
+	return p.scaleTo(ctx, fmt.Sprintf("Scaling up dependents of %s/%s", p.probeDeps.Name, p.namespace), 1, adoptUnmarked, func(o, n int32) bool {
 		return n > o // scale to at least n
 	})
 }
 
-// Checks for a given resource considered for scale, if for the respective scale operations its dependent deployments are in desired state.
-// If availableReplicas is not equal to desired then it fails the check and the scaling fo the parent is stopped
-func (p *prober) checkScaleRefDependsOn(ctx context.Context, prefix string, dependsOnScaleRefs []autoscalingapi.CrossVersionObjectReference, replicas int32, checkFn func(oReplicas, nReplicas int32) bool) bool {
-	// running this check immediately after scaling tends to fail as the parent resource might still be processing
-	// introduce a short delay to let the parent resource availability to reflect current state correctly
-	// TODO: We should replace this with a proper flag for DWD probe, this requires also to identify the currect default value.
-	time.Sleep(toDuration(pointer.Int32Ptr(10), 0))
-	// if possible check from the cache if the target needs to be scaled
+// release hands the dependants back before the dependency-watchdog stands down for the namespace or stops probing
+// it. If the dependants are meant to run, it scales up the targets it owns, adopting unmarked ones if its persisted
+// last action is a scale down, and persists the scale up. Otherwise it only removes its marks from the targets it
+// owns and leaves them to their owner. Either way the hold on the dependants is released.
+func (p *prober) release(ctx context.Context, keepUp bool) {
+	p.holdDowns.set(p.namespace, p.probeDeps, false)
+	if p.emergencyStop.Suspended() {
+		klog.Warningf("%s/%s: not releasing the dependants as all actions are suspended", p.probeDeps.Name, p.namespace)
+		return
+	}
+	if !keepUp {
+		p.unmarkOwnedTargets()
+		return
+	}
+
+	state, err := p.stateStore.load(p.namespace, p.probeDeps.Name)
+	if err != nil {
+		klog.Errorf("%s/%s: failed to load the persisted prober state: %v", p.probeDeps.Name, p.namespace, err)
+	}
+	scaledDown := state != nil && state.LastAction == actionScaleDown
+	if _, err := p.scaleUp(ctx, scaledDown); err != nil {
+		klog.Errorf("%s/%s: failed to scale up the dependants before standing down: %v", p.probeDeps.Name, p.namespace, err)
+		return
+	}
+	if scaledDown {
+		now := metav1.Now()
+		state.LastAction, state.LastActionTime, state.UpdateTime = actionScaleUp, &now, now
+		if err := p.stateStore.save(p.namespace, p.probeDeps.Name, state); err != nil {
+			klog.Errorf("%s/%s: failed to persist the prober state: %v", p.probeDeps.Name, p.namespace, err)
+		}
+	}
+}
+
+// Checks for a given resource considered for scale, if for the respective scale operations all the targets it has to wait for are in desired state.
+// It waits for the availableReplicas of each of them to become as desired until the timeout expires or the context is cancelled.
+// If that does not happen then it fails the check and the scaling fo the parent is stopped
+func (p *prober) checkScaleRefDependsOn(ctx context.Context, prefix string, dependsOnScaleRefs []autoscalingapi.CrossVersionObjectReference, replicas int32, checkFn func(oReplicas, nReplicas int32) bool, timeout time.Duration) bool {
+	if len(dependsOnScaleRefs) == 0 {
+		klog.V(4).Infof("%s skipped as there are no dependents to process.", prefix)
+		return true
+	}
+	ctx, cancelFn := context.WithTimeout(ctx, timeout)
+	defer cancelFn()
+
 	klog.V(5).Infof("%s with dependents %v", prefix, dependsOnScaleRefs)
-	if len(dependsOnScaleRefs) != 0 {
-		for _, dependsOnScaleRef := range dependsOnScaleRefs {
-			klog.V(4).Infof("Checking if the dependent scaleRef %v  has the desired replicas %d", dependsOnScaleRef, replicas)
-			if dependsOnScaleRef.APIVersion == appsv1.SchemeGroupVersion.String() && dependsOnScaleRef.Kind == kindDeployment {
-				dwdGetTargetFromCacheTotal.With(prometheus.Labels{labelResource: resourceDeployments}).Inc()
-				d, err := p.deploymentsLister.Deployments(p.namespace).Get(dependsOnScaleRef.Name)
-				if err != nil {
-					klog.Errorf("%s: Could not find the target reference for %s: %s", prefix, dependsOnScaleRef.Name, err)
-					return false
-				}
-				var availableReplicas = int32(0)
-				availableReplicas = d.Status.AvailableReplicas // check if available replicas is as desired
-				if !checkFn(availableReplicas, replicas) {
-					klog.V(4).Infof("%s: check for dependent %s succeeded as desired=%d and available=%d", prefix, d.Name, replicas, availableReplicas)
-					return true // can continue with scale operation of the parent
-				}
-				klog.V(4).Infof("%s: check for dependent %s failed as desired=%d and available=%d", prefix, d.Name, replicas, availableReplicas)
-				return false // stop the scale operation of parent as dependent has not yet scaled
-			}
+	for _, dependsOnScaleRef := range dependsOnScaleRefs {
+		klog.V(4).Infof("Checking if the dependent scaleRef %v  has the desired replicas %d", dependsOnScaleRef, replicas)
+		if !p.targets.has(dependsOnScaleRef) {
+			klog.Errorf("%s: check for dependent %s/%s failed as there is no informer cache for its kind", prefix, dependsOnScaleRef.Kind, dependsOnScaleRef.Name)
+			return false
+		}
+		var availableReplicas int32
+		err := p.waitForTarget(ctx, dependsOnScaleRef, func(t *targetInfo) bool {
+			availableReplicas = t.availableReplicas // check if available replicas is as desired
+			return !checkFn(availableReplicas, replicas)
+		})
+		if err != nil {
+			klog.V(4).Infof("%s: check for dependent %s failed as desired=%d and available=%d: %s", prefix, dependsOnScaleRef.Name, replicas, availableReplicas, err)
+			return false // stop the scale operation of parent as dependent has not yet scaled
+		}
+		klog.V(4).Infof("%s: check for dependent %s succeeded as desired=%d and available=%d", prefix, dependsOnScaleRef.Name, replicas, availableReplicas)
+	}
+	return true // can continue with scale operation of the parent
+}
+
+// waitForTarget waits until the condition is true for the target in the informer cache.
+// It is woken up by the target caches and returns an error if the target is not found
+// or if the context is cancelled before the condition is true.
+func (p *prober) waitForTarget(ctx context.Context, ref autoscalingapi.CrossVersionObjectReference, condition func(t *targetInfo) bool) error {
+	// Watch before the first check so that no change is missed
+	changed, cancelFn := p.targets.watch(p.namespace, ref)
+	defer cancelFn()
+
+	for {
+		t, err := p.targets.get(p.namespace, ref)
+		if err != nil {
+			return err
+		}
+		if condition(t) {
+			return nil
+		}
 
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
-	klog.V(4).Infof("%s skipped as there are no dependents to process.", prefix)
-	return true
+}
+
+// sleepWithContext waits for the given duration and returns an error if the context is cancelled in the meantime.
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
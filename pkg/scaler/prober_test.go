@@ -5,18 +5,29 @@
 package scaler
 
 import (
+	"context"
 	"crypto/sha256"
+	"errors"
+	"sync"
+	"time"
 
 	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
 	listerv1 "k8s.io/client-go/listers/core/v1"
+	scalefake "k8s.io/client-go/scale/fake"
+	k8stesting "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/pointer"
 )
 
 var (
@@ -123,3 +134,257 @@ var _ = Describe("prober", func() {
 		Entry("No change in kubeconfig", shaOf(kubeconfig1), kubeconfig1, nil, apierrors.NewAlreadyExists(schema.GroupResource{Resource: "secret"}, secretName)),
 		Entry("Changed kubeconfig", shaOf(kubeconfig1), kubeconfig2, shaOf(kubeconfig2), nil))
 })
+
+var _ = Describe("retryScaling", func() {
+	var (
+		backoff = wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 3}
+		gr      = schema.GroupResource{Group: "apps", Resource: "deployments"}
+	)
+
+	failing := func(errs ...error) (func() error, *int) {
+		calls := 0
+		return func() error {
+			calls++
+			if calls <= len(errs) {
+				return errs[calls-1]
+			}
+			return nil
+		}, &calls
+	}
+
+	It("should retry conflicts and throttled attempts", func() {
+		fn, calls := failing(
+			apierrors.NewConflict(gr, "kube-controller-manager", errors.New("modified")),
+			apierrors.NewTooManyRequests("throttled", 0),
+		)
+		Expect(retryScaling(context.Background(), "test", fn, backoff)).To(Succeed())
+		Expect(*calls).To(Equal(3))
+	})
+
+	It("should give up once the retries are exhausted", func() {
+		fn, calls := failing(errors.New("1"), errors.New("2"), errors.New("3"), errors.New("4"))
+		Expect(retryScaling(context.Background(), "test", fn, backoff)).To(MatchError("3"))
+		Expect(*calls).To(Equal(3))
+	})
+
+	It("should not retry targets that are not found", func() {
+		fn, calls := failing(apierrors.NewNotFound(gr, "kube-controller-manager"))
+		Expect(apierrors.IsNotFound(retryScaling(context.Background(), "test", fn, backoff))).To(BeTrue())
+		Expect(*calls).To(Equal(1))
+	})
+
+	Describe("getScalingFn", func() {
+		var (
+			client  *scalefake.FakeScaleClient
+			current int32
+			updates []int32
+			p       *prober
+		)
+
+		BeforeEach(func() {
+			current, updates = 1, nil
+			client = &scalefake.FakeScaleClient{}
+			client.AddReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, &autoscalingv1.Scale{
+					ObjectMeta: metav1.ObjectMeta{Name: "kube-controller-manager", ResourceVersion: "1"},
+					Spec:       autoscalingv1.ScaleSpec{Replicas: current},
+				}, nil
+			})
+			client.AddReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				s := action.(k8stesting.UpdateAction).GetObject().(*autoscalingv1.Scale)
+				updates = append(updates, s.Spec.Replicas)
+				if len(updates) == 1 {
+					return true, nil, apierrors.NewConflict(gr, s.Name, errors.New("modified"))
+				}
+				current = s.Spec.Replicas
+				return true, s, nil
+			})
+			p = &prober{
+				namespace:      "test",
+				scaleInterface: client.Scales("test"),
+				probeDeps:      &api.ProbeDependants{Name: "kube-apiserver"},
+			}
+		})
+
+		scaleDown := func(o, n int32) bool { return o > n }
+
+		It("should re-read the scale on every attempt", func() {
+			fn := p.getScalingFn(gr, "kube-controller-manager", 0, scaleDown)
+			changed := false
+			Expect(retryScaling(context.Background(), "test", func() (err error) {
+				changed, err = fn()
+				return err
+			}, backoff)).To(Succeed())
+			Expect(changed).To(BeTrue())
+			Expect(updates).To(Equal([]int32{0, 0}))
+			Expect(current).To(BeZero())
+
+			gets := 0
+			for _, a := range client.Actions() {
+				if a.GetVerb() == "get" {
+					gets++
+				}
+			}
+			Expect(gets).To(Equal(2))
+		})
+
+		It("should skip the update if the scaling is no longer needed", func() {
+			current = 0
+			fn := p.getScalingFn(gr, "kube-controller-manager", 0, scaleDown)
+			Expect(fn()).To(BeFalse())
+			Expect(updates).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("adaptive period", func() {
+	var (
+		p       *prober
+		healthy = probeResult{resultRun: defaultSuccessThreshold}
+		failed  = probeResult{lastError: errors.New("connection refused"), resultRun: defaultFailureThreshold}
+		pending = probeResult{lastError: errors.New("connection refused"), resultRun: 1}
+	)
+
+	BeforeEach(func() {
+		p = &prober{
+			probeDeps:        &api.ProbeDependants{Name: "kube-apiserver"},
+			successThreshold: defaultSuccessThreshold,
+			failureThreshold: defaultFailureThreshold,
+		}
+		p.period, p.minPeriod, p.maxPeriod = periodsOf(&api.ProbeConfig{
+			PeriodSeconds:    pointer.Int32Ptr(10),
+			MinPeriodSeconds: pointer.Int32Ptr(2),
+			MaxPeriodSeconds: pointer.Int32Ptr(60),
+		})
+		p.currentPeriod = p.period
+	})
+
+	It("should back off while both probes are steadily healthy", func() {
+		p.internalResult, p.externalResult = healthy, healthy
+		var periods []time.Duration
+		for i := 0; i < 5; i++ {
+			periods = append(periods, p.nextPeriod())
+		}
+		Expect(periods).To(Equal([]time.Duration{20 * time.Second, 40 * time.Second, time.Minute, time.Minute, time.Minute}))
+	})
+
+	DescribeTable("should probe fast while the probes need attention", func(internal, external probeResult, lastAction time.Duration, expected time.Duration) {
+		p.currentPeriod = time.Minute
+		p.internalResult, p.externalResult = internal, external
+		if lastAction > 0 {
+			p.lastActionTime = time.Now().Add(-lastAction)
+		}
+		Expect(p.nextPeriod()).To(Equal(expected))
+	},
+		Entry("transitioning internal probe", pending, healthy, time.Duration(0), 2*time.Second),
+		Entry("transitioning external probe", healthy, pending, time.Duration(0), 2*time.Second),
+		Entry("disagreeing probes", healthy, failed, time.Duration(0), 2*time.Second),
+		Entry("right after a scale action", healthy, healthy, 10*time.Second, 2*time.Second),
+		Entry("long after a scale action", healthy, healthy, time.Hour, time.Minute),
+		Entry("both probes unhealthy", failed, failed, time.Duration(0), 10*time.Second),
+	)
+
+	It("should keep a fixed period without bounds", func() {
+		period, minPeriod, maxPeriod := periodsOf(&api.ProbeConfig{PeriodSeconds: pointer.Int32Ptr(5)})
+		Expect(minPeriod).To(Equal(period))
+		Expect(maxPeriod).To(Equal(period))
+		Expect(validatePeriods(&api.ProbeConfig{PeriodSeconds: pointer.Int32Ptr(5), MaxPeriodSeconds: pointer.Int32Ptr(4)})).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("scale actions", func() {
+	var p *prober
+
+	BeforeEach(func() {
+		p = &prober{namespace: "shoot--foo", probeDeps: &api.ProbeDependants{Name: "kube-apiserver"}}
+	})
+
+	It("should run in the background and be recorded once they finished", func() {
+		release := make(chan struct{})
+		p.act(context.Background(), actionScaleUp, func(ctx context.Context) (bool, error) {
+			<-release
+			return true, nil
+		})
+		Expect(p.needsAttention()).To(BeTrue())
+		Expect(p.collectAction()).To(Succeed())
+		Expect(p.lastAction).To(BeEmpty())
+
+		By("not starting another action while one is running")
+		started := false
+		p.act(context.Background(), actionScaleDown, func(ctx context.Context) (bool, error) {
+			started = true
+			return true, nil
+		})
+		Expect(p.runningAction.action).To(Equal(actionScaleUp))
+
+		close(release)
+		Eventually(func() string {
+			Expect(p.collectAction()).To(Succeed())
+			return p.lastAction
+		}).Should(Equal(actionScaleUp))
+		Expect(p.runningAction).To(BeNil())
+		Expect(started).To(BeFalse())
+	})
+
+	It("should return the error of a failed action", func() {
+		failure := errors.New("conflict")
+		p.act(context.Background(), actionScaleDown, func(ctx context.Context) (bool, error) {
+			return false, failure
+		})
+		Eventually(p.collectAction).Should(Equal(failure))
+		Expect(p.lastAction).To(BeEmpty())
+	})
+
+	It("should not record actions which scaled none of the dependants", func() {
+		p.act(context.Background(), actionScaleUp, func(ctx context.Context) (bool, error) {
+			return false, nil
+		})
+		Eventually(func() *scaleAction {
+			Expect(p.collectAction()).To(Succeed())
+			return p.runningAction
+		}).Should(BeNil())
+		Expect(p.lastAction).To(BeEmpty())
+		Expect(p.lastActionTime.IsZero()).To(BeTrue())
+	})
+})
+
+// recordingMapper records the kinds it is asked to map.
+type recordingMapper struct {
+	meta.RESTMapper
+	mux   sync.Mutex
+	kinds []string
+}
+
+func (m *recordingMapper) RESTMappings(gk schema.GroupKind, versions ...string) ([]*meta.RESTMapping, error) {
+	m.mux.Lock()
+	m.kinds = append(m.kinds, gk.String())
+	m.mux.Unlock()
+	return m.RESTMapper.RESTMappings(gk, versions...)
+}
+
+var _ = Describe("scaleTo", func() {
+	It("should skip the branch of the graph after a target which failed to scale", func() {
+		invalid := newDependantScale("kube-apiserver")
+		invalid.ScaleRef.APIVersion = "apps/v1/invalid"
+		kcm := newDependantScale("kube-controller-manager")
+		kcm.ScaleRefDependsOn = []autoscalingv1.CrossVersionObjectReference{invalid.ScaleRef}
+		other := newDependantScale("other")
+		other.ScaleRef.Kind = "Other"
+
+		mapper := &recordingMapper{RESTMapper: meta.NewDefaultRESTMapper(nil)}
+		p := &prober{
+			namespace: "test",
+			mapper:    mapper,
+			targets:   newTargetCaches(),
+			probeDeps: &api.ProbeDependants{
+				Name:            "kube-apiserver",
+				Probe:           &api.ProbeConfig{},
+				DependantScales: []*api.DependantScaleDetails{newDependantScale("machine-controller-manager", "kube-controller-manager"), kcm, invalid, other},
+			},
+		}
+
+		_, err := p.scaleTo(context.Background(), "test", 1, false, func(oReplicas, nReplicas int32) bool { return oReplicas < nReplicas })
+		Expect(err).To(HaveOccurred())
+		Expect(mapper.kinds).To(ConsistOf("Other.apps"))
+	})
+})
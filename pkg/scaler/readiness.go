@@ -0,0 +1,334 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	extensionscontroller "github.com/gardener/gardener/extensions/pkg/controller"
+	gardencorev1beta1 "github.com/gardener/gardener/pkg/apis/core/v1beta1"
+	gardenerv1alpha1 "github.com/gardener/gardener/pkg/apis/extensions/v1alpha1"
+	gardenerinformers "github.com/gardener/gardener/pkg/client/extensions/informers/externalversions"
+	gardenerlisterv1alpha1 "github.com/gardener/gardener/pkg/client/extensions/listers/extensions/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// defaultReadinessLabel marks the namespaces ready to be probed for the Namespace readiness source if no other
+// label or annotation is configured.
+const defaultReadinessLabel = "dependency-watchdog.gardener.cloud/probe"
+
+// readinessSource decides which namespaces are ready to be probed.
+type readinessSource interface {
+	// notReadyReason returns why the namespace is not ready to be probed or an empty string if it is.
+	notReadyReason(namespace string) (string, error)
+	// keepsDependantsUp checks if the dependants in the namespace are meant to run while the dependency-watchdog
+	// stands down for it, so that the targets it scaled down are scaled up again before.
+	keepsDependantsUp(namespace string) bool
+	// exists checks if the namespace is still known to the source.
+	exists(namespace string) bool
+	// namespaces lists the namespaces which may be probed.
+	namespaces() ([]string, error)
+	// addEventHandlers calls enqueue for namespaces whose readiness may have changed and stop for namespaces which
+	// are no longer known to the source.
+	addEventHandlers(enqueue, stop func(namespace string))
+	start(stopCh <-chan struct{})
+	hasSynced() bool
+}
+
+// ReadinessSourceTypeOf returns the type of the readiness source configured in the given list.
+func ReadinessSourceTypeOf(probeDependantsList *api.ProbeDependantsList) api.ReadinessSourceType {
+	if rs := probeDependantsList.ReadinessSource; rs != nil && rs.Type != "" {
+		return rs.Type
+	}
+	return api.ReadinessSourceGardenerCluster
+}
+
+// ValidateReadinessSource checks that the readiness source has a known type and a consistent configuration.
+func ValidateReadinessSource(config *api.ReadinessSourceConfig) error {
+	if config == nil {
+		return nil
+	}
+	switch config.Type {
+	case "", api.ReadinessSourceGardenerCluster, api.ReadinessSourceAlwaysReady:
+		return nil
+	case api.ReadinessSourceNamespace:
+		if config.Label != "" && config.Annotation != "" {
+			return fmt.Errorf("only one of label and annotation may be set")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown type %q", config.Type)
+	}
+}
+
+// newReadinessSource creates the readiness source configured in the given list. The Gardener informer factory is
+// only used by the Gardener Cluster readiness source.
+func newReadinessSource(probeDependantsList *api.ProbeDependantsList, gardenerInformerFactory gardenerinformers.SharedInformerFactory, namespaces coreinformers.NamespaceInformer) readinessSource {
+	switch ReadinessSourceTypeOf(probeDependantsList) {
+	case api.ReadinessSourceNamespace:
+		config := probeDependantsList.ReadinessSource
+		r := &namespaceReadiness{
+			informer:   namespaces.Informer(),
+			lister:     namespaces.Lister(),
+			label:      config.Label,
+			annotation: config.Annotation,
+			value:      config.Value,
+		}
+		if r.label == "" && r.annotation == "" {
+			r.label, r.value = defaultReadinessLabel, "true"
+		}
+		return r
+	case api.ReadinessSourceAlwaysReady:
+		return &namespaceReadiness{
+			informer: namespaces.Informer(),
+			lister:   namespaces.Lister(),
+			always:   true,
+		}
+	default:
+		return &clusterReadiness{
+			factory:  gardenerInformerFactory,
+			informer: gardenerInformerFactory.Extensions().V1alpha1().Clusters().Informer(),
+			lister:   gardenerInformerFactory.Extensions().V1alpha1().Clusters().Lister(),
+		}
+	}
+}
+
+// clusterReadiness probes the namespaces of the Gardener Clusters as long as their shoots are ready.
+// The shoots are decoded once per resource version of their Cluster, as they are looked up on every probe.
+type clusterReadiness struct {
+	factory  gardenerinformers.SharedInformerFactory
+	informer cache.SharedIndexInformer
+	lister   gardenerlisterv1alpha1.ClusterLister
+
+	mux     sync.Mutex
+	decoder runtime.Decoder
+	shoots  map[string]*decodedShoot
+}
+
+// decodedShoot is the shoot decoded from the given resource version of its Cluster.
+type decodedShoot struct {
+	resourceVersion string
+	shoot           *gardencorev1beta1.Shoot
+}
+
+// shootOf returns the shoot of the Cluster of the namespace.
+func (r *clusterReadiness) shootOf(namespace string) (*gardencorev1beta1.Shoot, error) {
+	// The name of cluster is same as shoot's namespace
+	clusterName := namespace
+	cluster, err := r.lister.Get(clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if cached, ok := r.shoots[clusterName]; ok && cached.resourceVersion == cluster.ResourceVersion {
+		return cached.shoot, nil
+	}
+	if r.decoder == nil {
+		if r.decoder, err = extensionscontroller.NewGardenDecoder(); err != nil {
+			return nil, fmt.Errorf("error getting gardener decoder: %v", err)
+		}
+	}
+	shoot, err := extensionscontroller.ShootFromCluster(r.decoder, cluster)
+	if err != nil {
+		return nil, fmt.Errorf("error extracting shoot from cluster: %v", err)
+	}
+	if shoot == nil {
+		return nil, fmt.Errorf("the cluster has no shoot")
+	}
+	if r.shoots == nil {
+		r.shoots = make(map[string]*decodedShoot)
+	}
+	r.shoots[clusterName] = &decodedShoot{resourceVersion: cluster.ResourceVersion, shoot: shoot}
+	return shoot, nil
+}
+
+// forget drops the decoded shoot of the deleted Cluster.
+func (r *clusterReadiness) forget(clusterName string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	delete(r.shoots, clusterName)
+}
+
+func (r *clusterReadiness) notReadyReason(namespace string) (string, error) {
+	shoot, err := r.shootOf(namespace)
+	if err != nil {
+		klog.Errorf("Error getting the shoot of cluster: %s, Err: %s", namespace, err.Error())
+		return "", err
+	}
+	return shootNotReadyReason(shoot), nil
+}
+
+func (r *clusterReadiness) keepsDependantsUp(namespace string) bool {
+	shoot, err := r.shootOf(namespace)
+	if err != nil {
+		// leave the dependants alone if it is unknown whether they are meant to run
+		return false
+	}
+	return shootKeepsControlPlane(shoot)
+}
+
+func (r *clusterReadiness) exists(namespace string) bool {
+	_, err := r.lister.Get(namespace)
+	return !apierrors.IsNotFound(err)
+}
+
+func (r *clusterReadiness) namespaces() ([]string, error) {
+	clusters, err := r.lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, cluster := range clusters {
+		if cluster.DeletionTimestamp == nil {
+			names = append(names, cluster.Name)
+		}
+	}
+	return names, nil
+}
+
+func (r *clusterReadiness) addEventHandlers(enqueue, stop func(namespace string)) {
+	r.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			cluster := obj.(*gardenerv1alpha1.Cluster)
+			klog.V(4).Infof("Add event on cluster: %v", cluster.Name)
+			// namespace is same as cluster's name
+			enqueue(cluster.Name)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			newCluster := new.(*gardenerv1alpha1.Cluster)
+			oldCluster := old.(*gardenerv1alpha1.Cluster)
+			klog.V(4).Infof("Update event on cluster: %v", newCluster.Name)
+			if newCluster.ResourceVersion == oldCluster.ResourceVersion {
+				// Periodic resync will send update events for all known Deployments.
+				// Two different versions of the same Deployment will always have different RVs.
+				return
+			}
+
+			if shootStateChanged(oldCluster, newCluster) {
+				// namespace is same as cluster's name
+				klog.V(4).Infof("Requeueing namespace: %v", newCluster.Name)
+				enqueue(newCluster.Name)
+			} else {
+				klog.V(5).Infof("Ignore update event on cluster: %v", newCluster.Name)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if cluster, ok := obj.(*gardenerv1alpha1.Cluster); ok {
+				klog.V(4).Infof("Delete event on cluster: %v", cluster.Name)
+				r.forget(cluster.Name)
+				stop(cluster.Name)
+			}
+		},
+	})
+}
+
+func (r *clusterReadiness) start(stopCh <-chan struct{}) {
+	r.factory.Start(stopCh)
+}
+
+func (r *clusterReadiness) hasSynced() bool {
+	return r.informer.HasSynced()
+}
+
+// namespaceReadiness probes the namespaces marked with a label or annotation, or all namespaces if always is set.
+// It relies on the namespace informer of the controller which is started with the shared informer factory.
+type namespaceReadiness struct {
+	informer   cache.SharedIndexInformer
+	lister     listerv1.NamespaceLister
+	always     bool
+	label      string
+	annotation string
+	value      string
+}
+
+func (r *namespaceReadiness) ready(ns *v1.Namespace) bool {
+	if r.always {
+		return true
+	}
+	values := ns.Labels
+	key := r.label
+	if r.annotation != "" {
+		values, key = ns.Annotations, r.annotation
+	}
+	value, ok := values[key]
+	return ok && (r.value == "" || value == r.value)
+}
+
+func (r *namespaceReadiness) notReadyReason(namespace string) (string, error) {
+	ns, err := r.lister.Get(namespace)
+	if err != nil {
+		return "", err
+	}
+	if r.ready(ns) {
+		return "", nil
+	}
+	if r.annotation != "" {
+		return fmt.Sprintf("the namespace is not annotated with %s", r.annotation), nil
+	}
+	return fmt.Sprintf("the namespace is not labelled with %s", r.label), nil
+}
+
+// keepsDependantsUp returns true as a namespace only stops to be ready if it is no longer to be probed.
+func (r *namespaceReadiness) keepsDependantsUp(string) bool {
+	return true
+}
+
+func (r *namespaceReadiness) exists(namespace string) bool {
+	_, err := r.lister.Get(namespace)
+	return !apierrors.IsNotFound(err)
+}
+
+func (r *namespaceReadiness) namespaces() ([]string, error) {
+	namespaces, err := r.lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, ns := range namespaces {
+		if ns.DeletionTimestamp == nil && r.ready(ns) {
+			names = append(names, ns.Name)
+		}
+	}
+	return names, nil
+}
+
+func (r *namespaceReadiness) addEventHandlers(enqueue, _ func(namespace string)) {
+	// deleted namespaces are handled by the lifecycle handlers of the controller
+	r.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if ns := obj.(*v1.Namespace); r.ready(ns) {
+				enqueue(ns.Name)
+			}
+		},
+		UpdateFunc: func(old, new interface{}) {
+			oldNs, newNs := old.(*v1.Namespace), new.(*v1.Namespace)
+			if r.ready(oldNs) != r.ready(newNs) {
+				enqueue(newNs.Name)
+			}
+		},
+	})
+}
+
+func (r *namespaceReadiness) start(<-chan struct{}) {}
+
+func (r *namespaceReadiness) hasSynced() bool {
+	return r.informer.HasSynced()
+}
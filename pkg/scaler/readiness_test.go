@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("readiness source", func() {
+	newNamespaceReadiness := func(config *api.ReadinessSourceConfig, namespaces ...*v1.Namespace) readinessSource {
+		factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+		nsInformer := factory.Core().V1().Namespaces()
+		for _, ns := range namespaces {
+			Expect(nsInformer.Informer().GetIndexer().Add(ns)).To(Succeed())
+		}
+		return newReadinessSource(&api.ProbeDependantsList{ReadinessSource: config}, nil, nsInformer)
+	}
+
+	namespace := func(name string, labels, annotations map[string]string) *v1.Namespace {
+		return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels, Annotations: annotations}}
+	}
+
+	DescribeTable("##ValidateReadinessSource",
+		func(config *api.ReadinessSourceConfig, valid bool) {
+			err := ValidateReadinessSource(config)
+			if valid {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(HaveOccurred())
+			}
+		},
+		Entry("no config", nil, true),
+		Entry("default type", &api.ReadinessSourceConfig{}, true),
+		Entry("always ready", &api.ReadinessSourceConfig{Type: api.ReadinessSourceAlwaysReady}, true),
+		Entry("namespace label", &api.ReadinessSourceConfig{Type: api.ReadinessSourceNamespace, Label: "probe"}, true),
+		Entry("namespace label and annotation", &api.ReadinessSourceConfig{Type: api.ReadinessSourceNamespace, Label: "probe", Annotation: "probe"}, false),
+		Entry("unknown type", &api.ReadinessSourceConfig{Type: "Unknown"}, false),
+	)
+
+	It("should default to the Gardener Clusters", func() {
+		Expect(ReadinessSourceTypeOf(&api.ProbeDependantsList{})).To(Equal(api.ReadinessSourceGardenerCluster))
+		Expect(ReadinessSourceTypeOf(&api.ProbeDependantsList{ReadinessSource: &api.ReadinessSourceConfig{}})).To(Equal(api.ReadinessSourceGardenerCluster))
+	})
+
+	It("should only probe the namespaces with the default label", func() {
+		r := newNamespaceReadiness(&api.ReadinessSourceConfig{Type: api.ReadinessSourceNamespace},
+			namespace("labelled", map[string]string{defaultReadinessLabel: "true"}, nil),
+			namespace("other-value", map[string]string{defaultReadinessLabel: "false"}, nil),
+			namespace("unlabelled", nil, nil),
+		)
+		Expect(r.namespaces()).To(ConsistOf("labelled"))
+
+		reason, err := r.notReadyReason("unlabelled")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reason).To(ContainSubstring(defaultReadinessLabel))
+		Expect(r.notReadyReason("labelled")).To(BeEmpty())
+	})
+
+	It("should only probe the namespaces with the configured annotation", func() {
+		r := newNamespaceReadiness(&api.ReadinessSourceConfig{Type: api.ReadinessSourceNamespace, Annotation: "probe"},
+			namespace("annotated", nil, map[string]string{"probe": "anything"}),
+			namespace("labelled", map[string]string{"probe": "anything"}, nil),
+		)
+		Expect(r.namespaces()).To(ConsistOf("annotated"))
+	})
+
+	It("should probe all namespaces if they are always ready", func() {
+		r := newNamespaceReadiness(&api.ReadinessSourceConfig{Type: api.ReadinessSourceAlwaysReady},
+			namespace("a", nil, nil),
+			namespace("b", nil, nil),
+		)
+		Expect(r.namespaces()).To(ConsistOf("a", "b"))
+		Expect(r.exists("a")).To(BeTrue())
+		Expect(r.exists("c")).To(BeFalse())
+	})
+})
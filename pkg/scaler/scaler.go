@@ -16,78 +16,90 @@ import (
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	componentbaseconfigv1alpha1 "k8s.io/component-base/config/v1alpha1"
 
+	"github.com/gardener/dependency-watchdog/pkg/access"
+	"github.com/gardener/dependency-watchdog/pkg/maintenance"
 	"github.com/gardener/dependency-watchdog/pkg/multicontext"
-	gardenerv1alpha1 "github.com/gardener/gardener/pkg/apis/extensions/v1alpha1"
+	"github.com/gardener/dependency-watchdog/pkg/nsselector"
 	gardenerinformers "github.com/gardener/gardener/pkg/client/extensions/informers/externalversions"
+	gardenerlisterv1alpha1 "github.com/gardener/gardener/pkg/client/extensions/listers/extensions/v1alpha1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	listerappsv1 "k8s.io/client-go/listers/apps/v1"
+	listerautoscalingv1 "k8s.io/client-go/listers/autoscaling/v1"
 	"k8s.io/client-go/scale"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog"
 )
 
 // NewController initializes a new K8s depencency-watchdog controller with scaler.
+// The gardenerInformerFactory is only used if the namespaces to probe are selected by the Gardener Clusters and
+// may be nil otherwise.
 func NewController(clientset kubernetes.Interface,
 	mapper apimeta.RESTMapper,
 	scalesGetter scale.ScalesGetter,
 	sharedInformerFactory informers.SharedInformerFactory,
 	gardenerInformerFactory gardenerinformers.SharedInformerFactory,
+	dynamicClient dynamic.Interface,
 	probeDependantsList *api.ProbeDependantsList,
+	recorder record.EventRecorder,
 	stopCh <-chan struct{}) *Controller {
 
+	namespaces := sharedInformerFactory.Core().V1().Namespaces()
+	readiness := newReadinessSource(probeDependantsList, gardenerInformerFactory, namespaces)
+	var (
+		clusterLister   gardenerlisterv1alpha1.ClusterLister
+		clusterInformer cache.SharedIndexInformer
+	)
+	if cr, ok := readiness.(*clusterReadiness); ok {
+		// pauses can also be set on the Clusters
+		clusterLister = cr.lister
+		clusterInformer = cr.informer
+	}
+	namespaceSelector, err := nsselector.New(probeDependantsList.NamespaceSelector, namespaces)
+	if err != nil {
+		klog.Fatalf("Invalid namespace selector: %s", err)
+	}
 	c := &Controller{
-		client:                 clientset,
-		mapper:                 mapper,
-		scalesGetter:           scalesGetter,
-		informerFactory:        sharedInformerFactory,
-		secretsInformer:        sharedInformerFactory.Core().V1().Secrets().Informer(),
-		secretsLister:          sharedInformerFactory.Core().V1().Secrets().Lister(),
-		clusterInformerFactory: gardenerInformerFactory,
-		clusterInformer:        gardenerInformerFactory.Extensions().V1alpha1().Clusters().Informer(),
-		clusterLister:          gardenerInformerFactory.Extensions().V1alpha1().Clusters().Lister(),
-		deploymentsInformer:    sharedInformerFactory.Apps().V1().Deployments().Informer(),
-		deploymentsLister:      sharedInformerFactory.Apps().V1().Deployments().Lister(),
-		workqueue:              workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Namespaces"),
-		stopCh:                 stopCh,
-		probeDependantsList:    probeDependantsList,
-		Multicontext:           multicontext.New(),
+		client:              clientset,
+		mapper:              mapper,
+		scalesGetter:        scalesGetter,
+		informerFactory:     sharedInformerFactory,
+		secrets:             newKubeconfigSecrets(clientset, probeDependantsList.Namespace, kubeconfigSecretNamesOf(probeDependantsList)),
+		readiness:           readiness,
+		namespacesInformer:  namespaces.Informer(),
+		namespaceSelector:   namespaceSelector,
+		dynamicClient:       dynamicClient,
+		targets:             newTargetCaches(),
+		circuitBreaker:      newCircuitBreaker(probeDependantsList.CircuitBreaker, recorder),
+		selfCheck:           newSelfCheck(probeDependantsList.SelfCheck),
+		stateStore:          newStateStore(clientset, probeDependantsList.PersistState),
+		holdDowns:           newHoldDowns(),
+		scheduler:           newProbeScheduler(),
+		probeCache:          newProbeCache(),
+		ProbeWorkers:        DefaultProbeWorkers,
+		maintenance:         maintenance.NewChecker(namespaces.Lister(), clusterLister, recorder),
+		workqueue:           workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Namespaces"),
+		stopCh:              stopCh,
+		probeDependantsList: probeDependantsList,
+		Multicontext:        multicontext.New(),
 		LeaderElection: componentbaseconfigv1alpha1.LeaderElectionConfiguration{
 			ResourceLock: resourcelock.LeasesResourceLock,
 		},
 	}
 	componentbaseconfigv1alpha1.RecommendedDefaultLeaderElectionConfiguration(&c.LeaderElection)
-	c.clusterInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		UpdateFunc: func(old, new interface{}) {
-			newCluster := new.(*gardenerv1alpha1.Cluster)
-			oldCluster := old.(*gardenerv1alpha1.Cluster)
-			klog.V(4).Infof("Update event on cluster: %v", newCluster.Name)
-			if newCluster.ResourceVersion == oldCluster.ResourceVersion {
-				// Periodic resync will send update events for all known Deployments.
-				// Two different versions of the same Deployment will always have different RVs.
-				return
-			}
-
-			if shootHibernationStateChanged(oldCluster, newCluster) {
-				// namespace is same as cluster's name
-				ns := newCluster.Name
-				klog.V(4).Infof("Requeueing namespace: %v", ns)
-				if c.probeDependantsList.Namespace != "" && ns != c.probeDependantsList.Namespace {
-					// skip reconciling other namespaces if a namespace was already configured
-					return
-				}
-				c.workqueue.AddRateLimited(ns)
-				klog.V(4).Infof("Requeued namespace: %v", ns)
-			} else {
-				klog.V(5).Infof("Ignore update event on cluster: %v", newCluster.Name)
-			}
-			return
-		},
-	})
-	c.secretsInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+	c.namespacesInformer.AddEventHandler(c.maintenance.ForgetDeleted())
+	if clusterInformer != nil {
+		clusterInformer.AddEventHandler(c.maintenance.ForgetDeleted())
+	}
+	c.secrets.addEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(new interface{}) {
 			newSecret := new.(*v1.Secret)
 			klog.V(5).Infof("Secret %s added in %s namespace\n", newSecret.Name, newSecret.Namespace)
@@ -110,12 +122,93 @@ func NewController(clientset kubernetes.Interface,
 			c.enqueueProbe(old)
 		},
 	})
-	c.hasSecretsSynced = c.secretsInformer.HasSynced
-	c.hasDeploymentsSynced = c.deploymentsInformer.HasSynced
-	c.hasClustersSynced = c.clusterInformer.HasSynced
+	c.registerLifecycleHandlers()
+	c.registerTargetCaches()
+	c.registerConflictCaches()
+	c.hasSecretsSynced = c.secrets.hasSynced
+	c.hasNamespacesSynced = c.namespacesInformer.HasSynced
 	return c
 }
 
+// registerTargetCaches creates the informers for all the kinds of scale targets referenced in the configuration
+// so that their state can be looked up and waited for from the cache. Deployments and StatefulSets use
+// typed informers which only keep the fields needed in their cache while any other kind uses a dynamic
+// informer for the resource it maps to.
+// Targets of kinds that cannot be mapped are looked up live via their scale sub-resource.
+func (c *Controller) registerTargetCaches() {
+	paths, err := fieldPathsOf(c.probeDependantsList)
+	if err != nil {
+		klog.Errorf("Invalid field paths of dependant scales: %s", err)
+	}
+
+	deployments := newDeploymentInformer(c.client, c.probeDependantsList.Namespace)
+	c.targets.add(deploymentGroupKind, resourceDeployments, deployments, &deploymentCache{lister: listerappsv1.NewDeploymentLister(deployments.GetIndexer())})
+	c.targetInformers = append(c.targetInformers, deployments)
+	c.hasTargetsSynced = append(c.hasTargetsSynced, deployments.HasSynced)
+
+	for _, ref := range scaleRefsOf(c.probeDependantsList) {
+		if c.targets.has(ref) {
+			continue
+		}
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err != nil {
+			klog.Errorf("Invalid apiVersion of scale target %s/%s: %s", ref.Kind, ref.Name, err)
+			continue
+		}
+		gk, _ := targetGroupKind(ref)
+
+		if gk == statefulSetGroupKind {
+			statefulSets := newStatefulSetInformer(c.client, c.probeDependantsList.Namespace)
+			c.targets.add(gk, resourceStatefulSets, statefulSets, &statefulSetCache{lister: listerappsv1.NewStatefulSetLister(statefulSets.GetIndexer())})
+			c.targetInformers = append(c.targetInformers, statefulSets)
+			c.hasTargetsSynced = append(c.hasTargetsSynced, statefulSets.HasSynced)
+			continue
+		}
+
+		mapping, err := c.mapper.RESTMapping(gk, gv.Version)
+		if err != nil {
+			klog.Errorf("No informer cache for scale targets of kind %s. They will be looked up live: %s", gk, err)
+			continue
+		}
+		fp, ok := paths[gk]
+		if !ok {
+			fp = defaultFieldPaths
+		}
+		informer := newDynamicInformer(c.dynamicClient, mapping.Resource, c.probeDependantsList.Namespace, targetAnnotationKeys,
+			append([][]string{fp.replicas}, fp.availableReplicas...)...)
+		c.targets.add(gk, mapping.Resource.Resource, informer, &dynamicCache{lister: cache.NewGenericLister(informer.GetIndexer(), mapping.Resource.GroupResource()), paths: fp})
+		c.targetInformers = append(c.targetInformers, informer)
+		c.hasTargetsSynced = append(c.hasTargetsSynced, informer.HasSynced)
+	}
+}
+
+// registerConflictCaches creates the informers for the HorizontalPodAutoscalers and Hvpas which might manage
+// the replicas of scale targets as well. They are only created if a conflict policy is configured for any of
+// the dependant scales. Hvpas are only considered if they are served.
+func (c *Controller) registerConflictCaches() {
+	if !hasConflictPolicies(c.probeDependantsList) {
+		return
+	}
+
+	d := &conflictDetector{ownerKinds: sets.NewString(c.probeDependantsList.ScalerOwnerKinds...)}
+
+	hpas := newHPAInformer(c.client, c.probeDependantsList.Namespace)
+	d.hpaLister = listerautoscalingv1.NewHorizontalPodAutoscalerLister(hpas.GetIndexer())
+	c.targetInformers = append(c.targetInformers, hpas)
+	c.hasTargetsSynced = append(c.hasTargetsSynced, hpas.HasSynced)
+
+	if _, err := c.mapper.RESTMapping(hvpaGroupKind, hvpaGroupVersionResource.Version); err != nil {
+		klog.Infof("Hvpas are not considered as conflicting scalers as they are not served: %s", err)
+	} else {
+		hvpas := newDynamicInformer(c.dynamicClient, hvpaGroupVersionResource, c.probeDependantsList.Namespace,
+			[]string{pausedUpdateModeAnnotationKey}, []string{"spec", "targetRef"}, []string{"spec", "hpa", "updatePolicy"})
+		d.hvpaLister = cache.NewGenericLister(hvpas.GetIndexer(), hvpaGroupVersionResource.GroupResource())
+		c.targetInformers = append(c.targetInformers, hvpas)
+		c.hasTargetsSynced = append(c.hasTargetsSynced, hvpas.HasSynced)
+	}
+	c.conflicts = d
+}
+
 // enqueueProbe takes an Secret resource and converts it into a namespace/name
 // string which is then put onto the work queue. This method should *not* be
 // passed resources of any type other than Endpoints.
@@ -129,8 +222,8 @@ func (c *Controller) enqueueProbe(obj interface{}) {
 	ns := meta.GetNamespace()
 	name := meta.GetName()
 
-	if c.probeDependantsList.Namespace != "" && ns != c.probeDependantsList.Namespace {
-		// skip reconciling other namespaces if a namespace was already configured
+	if !c.selected(ns) {
+		// skip reconciling namespaces which are not configured or selected
 		return
 	}
 
@@ -167,16 +260,25 @@ func (c *Controller) Run(threadiness int) error {
 	defer utilruntime.HandleCrash()
 
 	klog.Info("Starting scaler controller")
+	if err := access.CheckNamespaces(c.client); err != nil {
+		return err
+	}
 
 	klog.Info("Starting informer factory.")
 	c.informerFactory.Start(c.stopCh)
-	c.clusterInformerFactory.Start(c.stopCh)
+	c.secrets.start(c.stopCh)
+	for _, informer := range c.targetInformers {
+		go informer.Run(c.stopCh)
+	}
+	c.readiness.start(c.stopCh)
+	c.EmergencyStop.Start(c.stopCh)
+	go c.selfCheck.start(c.stopCh)
 
 	go c.Multicontext.Start(c.stopCh)
 
 	// Wait for the caches to be synced before starting workers
 	klog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(c.stopCh, c.hasSecretsSynced, c.hasDeploymentsSynced, c.hasClustersSynced); !ok {
+	if ok := cache.WaitForCacheSync(c.stopCh, append(c.hasTargetsSynced, c.hasSecretsSynced, c.readiness.hasSynced, c.hasNamespacesSynced, c.EmergencyStop.HasSynced)...); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -185,6 +287,9 @@ func (c *Controller) Run(threadiness int) error {
 	for i := 0; i < threadiness; i++ {
 		go wait.Until(c.runWorker, time.Second, c.stopCh)
 	}
+	go c.scheduler.run(c.ProbeWorkers, c.stopCh)
+	go wait.Until(c.probeCache.gc, fullResyncPeriod, c.stopCh)
+	go wait.Until(c.resync, fullResyncPeriod, c.stopCh)
 
 	<-c.stopCh
 	klog.Info("Shutting down workers")
@@ -248,25 +353,28 @@ func (c *Controller) processNamespace(key string) error {
 		utilruntime.HandleError(fmt.Errorf("invalid resource key: %s", key))
 		return err
 	}
-	if c.probeDependantsList.Namespace != "" && namespace != c.probeDependantsList.Namespace {
+	if !c.selected(namespace) {
 		klog.V(5).Infof("Namespace %s is not in the list probe dependant namespace \n", namespace)
+		c.stopProbers(namespace)
+		return nil
+	}
+	if c.namespaceGone(namespace) {
+		klog.V(4).Infof("Namespace %s or its cluster is deleted", namespace)
+		c.stopProbers(namespace)
+		return nil
+	}
+	if reason, err := c.readiness.notReadyReason(namespace); err == nil && reason != "" {
+		klog.V(4).Infof("Namespace %s is not ready as %s", namespace, reason)
+		c.stopProbers(namespace)
 		return nil
 	}
 
 	for i := range c.probeDependantsList.Probes {
 		probeDeps := &c.probeDependantsList.Probes[i]
 
-		go func(ns string, pd *api.ProbeDependants) {
-			p := &prober{
-				namespace:         ns,
-				mapper:            c.mapper,
-				secretLister:      c.secretsLister,
-				clusterLister:     c.clusterLister,
-				deploymentsLister: c.deploymentsLister,
-				scaleInterface:    c.scalesGetter.Scales(ns),
-				probeDeps:         probeDeps,
-			}
-			err := p.tryAndRun(func() <-chan struct{} {
+		func(ns string, pd *api.ProbeDependants) {
+			p := c.newProber(ns, probeDeps)
+			err := p.tryAndRun(func() context.Context {
 				klog.Infof("Starting the probe in the namespace %s: %v", ns, pd.Name)
 				ctx, cancelFn := c.newContext(ns, pd)
 				klog.V(5).Infof("Created the context %v with cancelFun %v\n", ctx, cancelFn)
@@ -277,7 +385,7 @@ func (c *Controller) processNamespace(key string) error {
 				}
 
 				c.registerProber(p)
-				return ctx.Done()
+				return ctx
 			}, func() {
 				klog.V(4).Infof("Setting the context nil for ns %s and probe dependent %v\n", ns, probeDeps)
 				c.Multicontext.ContextCh <- &multicontext.ContextMessage{
@@ -288,14 +396,13 @@ func (c *Controller) processNamespace(key string) error {
 				klog.V(4).Infof("Enqueuing with a delay of 10 mins\n")
 				c.workqueue.AddAfter(ns, 10*time.Minute)
 			}, func() bool {
-
-				_, ok := c.probers[ns]
+				ok := c.proberRegistered(c.getKey(ns, pd))
 				klog.V(4).Infof("Prober ran with ok code %v\n", ok)
 				return ok
 			})
 
 			if err == nil {
-				klog.Infof("Finished the probe in the namespace %s: %v", ns, pd)
+				klog.V(4).Infof("Scheduled the probe in the namespace %s: %v", ns, pd.Name)
 			} else if apierrors.IsAlreadyExists(err) {
 				klog.V(4).Infof("Probe already exists for the namespace %s: %v", ns, pd)
 			} else {
@@ -307,6 +414,29 @@ func (c *Controller) processNamespace(key string) error {
 	return nil
 }
 
+// newProber returns a prober of the given probe dependants in the namespace.
+func (c *Controller) newProber(ns string, probeDeps *api.ProbeDependants) *prober {
+	return &prober{
+		namespace:      ns,
+		mapper:         c.mapper,
+		secretLister:   c.secrets,
+		readiness:      c.readiness,
+		targets:        c.targets,
+		conflicts:      c.conflicts,
+		circuitBreaker: c.circuitBreaker,
+		selfCheck:      c.selfCheck,
+		stateStore:     c.stateStore,
+		holdDowns:      c.holdDowns,
+		maintenance:    c.maintenance,
+		emergencyStop:  c.EmergencyStop,
+		scheduler:      c.scheduler,
+		probeCache:     c.probeCache,
+		dynamicClient:  c.dynamicClient,
+		scaleInterface: c.scalesGetter.Scales(ns),
+		probeDeps:      probeDeps,
+	}
+}
+
 func (c *Controller) getKey(ns string, probeDeps *api.ProbeDependants) string {
 	return ns + "/" + probeDeps.Name
 }
@@ -332,22 +462,10 @@ func (c *Controller) registerProber(p *prober) *prober {
 		c.probers = make(map[string]*prober)
 	}
 
-	pb, ok := c.probers[key]
-	if ok && pb != nil {
-		klog.V(4).Infof("Found and existing probe for key %s so using existing probe %v \n", key, pb)
-		return pb
-	}
-
-	pb = &prober{
-		namespace:      ns,
-		mapper:         c.mapper,
-		secretLister:   c.secretsLister,
-		scaleInterface: c.scalesGetter.Scales(ns),
-		probeDeps:      probeDeps,
-	}
-	c.probers[key] = pb
-	klog.V(4).Infof("Created a new probe and added for key %v \n", key)
-	return pb
+	// The running prober is registered so that its status can be served, see NewStatusHandler.
+	c.probers[key] = p
+	klog.V(4).Infof("Registered the probe for key %v \n", key)
+	return p
 }
 
 func (c *Controller) deleteProber(key string) {
@@ -371,5 +489,7 @@ func (c *Controller) newContext(ns string, probeDeps *api.ProbeDependants) (cont
 	return ctx, func() {
 		defer cancelFn()
 		c.deleteProber(key)
+		c.circuitBreaker.forget(ns, probeDeps.Name)
+		c.holdDowns.set(ns, probeDeps, false)
 	}
 }
@@ -0,0 +1,216 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"container/heap"
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// DefaultProbeWorkers is the default number of workers running the due probes of all probers.
+const DefaultProbeWorkers = 50
+
+// scheduledProbe is a periodic probe run by the probeScheduler until its context is cancelled or it fails.
+type scheduledProbe struct {
+	key    string
+	ctx    context.Context
+	period time.Duration
+	due    time.Time
+	run    func(ctx context.Context) (time.Duration, error)
+	done   func(err error)
+	index  int
+}
+
+// probeQueue is a min-heap of the scheduled probes ordered by their due time.
+type probeQueue []*scheduledProbe
+
+func (q probeQueue) Len() int           { return len(q) }
+func (q probeQueue) Less(i, j int) bool { return q[i].due.Before(q[j].due) }
+func (q probeQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *probeQueue) Push(x interface{}) {
+	sp := x.(*scheduledProbe)
+	sp.index = len(*q)
+	*q = append(*q, sp)
+}
+
+func (q *probeQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	sp := old[n-1]
+	old[n-1] = nil
+	sp.index = -1
+	*q = old[:n-1]
+	return sp
+}
+
+// probeScheduler runs the periodic probes of all probers on a bounded pool of workers instead of one goroutine
+// and timer per prober. The first run of every probe is spread across its period by a hash of its key so that
+// the probes started together, e.g. on startup, do not all run at the same time.
+// A probe is only queued again once its run finished. If the workers cannot keep up, the probes run late and
+// the runs missed in the meantime are skipped rather than caught up on.
+type probeScheduler struct {
+	now    func() time.Time
+	mux    sync.Mutex
+	queue  probeQueue
+	wakeCh chan struct{}
+	jobs   chan *scheduledProbe
+}
+
+func newProbeScheduler() *probeScheduler {
+	return &probeScheduler{
+		now:    time.Now,
+		wakeCh: make(chan struct{}, 1),
+		jobs:   make(chan *scheduledProbe),
+	}
+}
+
+// schedule runs the probe until ctx is cancelled or the probe returns an error. The first run is due within the
+// given period. Every run returns the period after which the next run is due or zero to keep the period.
+// done is called once the probe is no longer scheduled, with the error returned by the probe if any.
+func (s *probeScheduler) schedule(ctx context.Context, key string, period time.Duration, run func(ctx context.Context) (time.Duration, error), done func(err error)) {
+	if period <= 0 {
+		period = defaultPeriodSeconds * time.Second
+	}
+	sp := &scheduledProbe{
+		key:    key,
+		ctx:    ctx,
+		period: period,
+		due:    s.now().Add(spreadOffset(key, period)),
+		run:    run,
+		done:   done,
+	}
+	s.push(sp)
+	dwdScheduledProbes.With(nil).Inc()
+}
+
+// spreadOffset returns an offset within the period which is evenly distributed over the keys.
+func spreadOffset(key string, period time.Duration) time.Duration {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return time.Duration(h.Sum64() % uint64(period))
+}
+
+func (s *probeScheduler) push(sp *scheduledProbe) {
+	s.mux.Lock()
+	heap.Push(&s.queue, sp)
+	s.mux.Unlock()
+
+	select {
+	case s.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// run starts the given number of workers and dispatches the due probes to them until stopCh is closed.
+func (s *probeScheduler) run(workers int, stopCh <-chan struct{}) {
+	if workers <= 0 {
+		workers = DefaultProbeWorkers
+	}
+	klog.Infof("Starting the probe scheduler with %d workers", workers)
+	for i := 0; i < workers; i++ {
+		go s.work(stopCh)
+	}
+	s.dispatch(stopCh)
+}
+
+func (s *probeScheduler) dispatch(stopCh <-chan struct{}) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		sp, wait := s.next()
+		if sp != nil {
+			if sp.ctx.Err() != nil {
+				s.finish(sp, nil)
+				continue
+			}
+			dwdProbeScheduleDelaySeconds.With(nil).Observe(s.now().Sub(sp.due).Seconds())
+			select {
+			case s.jobs <- sp: // blocks while all workers are busy
+			case <-stopCh:
+				return
+			}
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-s.wakeCh:
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// next pops the first probe if it is due. Otherwise it returns how long to wait for it.
+func (s *probeScheduler) next() (*scheduledProbe, time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if len(s.queue) == 0 {
+		return nil, time.Hour
+	}
+	if wait := s.queue[0].due.Sub(s.now()); wait > 0 {
+		return nil, wait
+	}
+	return heap.Pop(&s.queue).(*scheduledProbe), 0
+}
+
+func (s *probeScheduler) work(stopCh <-chan struct{}) {
+	for {
+		select {
+		case sp := <-s.jobs:
+			if sp.ctx.Err() != nil {
+				s.finish(sp, nil)
+				continue
+			}
+			period, err := sp.run(sp.ctx)
+			if err != nil || sp.ctx.Err() != nil {
+				s.finish(sp, err)
+				continue
+			}
+			if period > 0 {
+				sp.period = period
+			}
+			s.reschedule(sp)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// reschedule queues the probe for its next run. Runs missed while the probe was running late are skipped.
+func (s *probeScheduler) reschedule(sp *scheduledProbe) {
+	now := s.now()
+	sp.due = sp.due.Add(sp.period)
+	if !sp.due.After(now) {
+		missed := now.Sub(sp.due)/sp.period + 1
+		sp.due = sp.due.Add(missed * sp.period)
+		dwdSkippedProbesTotal.With(nil).Add(float64(missed))
+		klog.V(4).Infof("Probe %s is running late. Skipped %d runs.", sp.key, missed)
+	}
+	s.push(sp)
+}
+
+func (s *probeScheduler) finish(sp *scheduledProbe, err error) {
+	dwdScheduledProbes.With(nil).Dec()
+	sp.done(err)
+}
@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("probeScheduler", func() {
+	var (
+		s      *probeScheduler
+		stopCh chan struct{}
+	)
+
+	BeforeEach(func() {
+		s = newProbeScheduler()
+		stopCh = make(chan struct{})
+		go s.run(2, stopCh)
+	})
+
+	AfterEach(func() {
+		close(stopCh)
+	})
+
+	It("should spread the probes across the period", func() {
+		period := 10 * time.Second
+		buckets := make(map[time.Duration]int)
+		for i := 0; i < 1000; i++ {
+			offset := spreadOffset(fmt.Sprintf("shoot--%d/kube-apiserver", i), period)
+			Expect(offset).To(BeNumerically(">=", 0))
+			Expect(offset).To(BeNumerically("<", period))
+			buckets[offset/time.Second]++
+		}
+		Expect(buckets).To(HaveLen(10))
+		for _, n := range buckets {
+			Expect(n).To(BeNumerically("~", 100, 50))
+		}
+	})
+
+	It("should run the probe periodically until it is cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		var runs int32
+		done := make(chan error, 1)
+		s.schedule(ctx, "shoot--foo/kube-apiserver", 10*time.Millisecond, func(context.Context) (time.Duration, error) {
+			atomic.AddInt32(&runs, 1)
+			return 0, nil
+		}, func(err error) { done <- err })
+
+		Eventually(func() int32 { return atomic.LoadInt32(&runs) }).Should(BeNumerically(">=", 3))
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+		stoppedAt := atomic.LoadInt32(&runs)
+		Consistently(func() int32 { return atomic.LoadInt32(&runs) }, 50*time.Millisecond).Should(BeNumerically("<=", stoppedAt+1))
+	})
+
+	It("should stop the probe once it fails", func() {
+		failure := errors.New("failed")
+		done := make(chan error, 1)
+		s.schedule(context.Background(), "shoot--foo/kube-apiserver", 10*time.Millisecond, func(context.Context) (time.Duration, error) {
+			return 0, failure
+		}, func(err error) { done <- err })
+
+		Eventually(done).Should(Receive(Equal(failure)))
+	})
+
+	It("should skip the runs missed by late probes", func() {
+		now := time.Now()
+		late := newProbeScheduler()
+		late.now = func() time.Time { return now }
+		sp := &scheduledProbe{key: "shoot--foo/kube-apiserver", period: 10 * time.Second, due: now.Add(-35 * time.Second)}
+
+		late.reschedule(sp)
+		Expect(sp.due).To(Equal(now.Add(5 * time.Second)))
+	})
+})
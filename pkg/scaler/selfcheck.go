@@ -0,0 +1,138 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/transport"
+	"k8s.io/klog"
+)
+
+const (
+	defaultSelfCheckTimeoutSeconds = 10
+	defaultSelfCheckCacheSeconds   = 10
+)
+
+// errSelfCheckPending is returned by the self-check until the reference endpoints were requested once.
+var errSelfCheckPending = errors.New("the reference endpoints were not checked yet")
+
+// selfCheck checks the network path of the dependency-watchdog itself by requesting known-good reference
+// endpoints. A failed external probe is only trusted if at least one of them is reachable.
+// The reference endpoints are requested periodically by the self-check itself rather than by the probe workers,
+// which only read the last result. A nil selfCheck always succeeds.
+type selfCheck struct {
+	mux      sync.RWMutex
+	urls     []string
+	client   *http.Client
+	interval time.Duration
+	lastErr  error
+}
+
+// newSelfCheck returns a self-check for the given configuration or nil if no reference endpoints are configured.
+func newSelfCheck(config *api.SelfCheckConfig) *selfCheck {
+	if config == nil || len(config.URLs) == 0 {
+		return nil
+	}
+
+	rt, err := newExternalTransport()
+	if err != nil {
+		klog.Fatalf("Error creating the transport of the self-check: %s", err)
+	}
+	return &selfCheck{
+		urls:     config.URLs,
+		client:   &http.Client{Transport: rt, Timeout: toDuration(config.TimeoutSeconds, defaultSelfCheckTimeoutSeconds)},
+		interval: toDuration(config.CacheSeconds, defaultSelfCheckCacheSeconds),
+		lastErr:  errSelfCheckPending,
+	}
+}
+
+// newExternalTransport creates a transport with the settings client-go applies to the transports of the clients of
+// the external probes, i.e. the same proxy, dial and TLS handshake settings, so that the reference endpoints are
+// requested through the same route as the external probes.
+func newExternalTransport() (http.RoundTripper, error) {
+	// Without a dialer or TLS options client-go falls back to the default transport of net/http.
+	return transport.New(&transport.Config{
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+	})
+}
+
+// start requests the reference endpoints right away and then every interval until stopCh is closed.
+func (s *selfCheck) start(stopCh <-chan struct{}) {
+	if s == nil {
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.refresh()
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check returns an error if none of the reference endpoints was reachable the last time they were requested, i.e.
+// the observer itself is unhealthy, or if they were not requested yet. It does not block.
+func (s *selfCheck) check() error {
+	if s == nil {
+		return nil
+	}
+
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.lastErr
+}
+
+// refresh requests the reference endpoints and stores the result.
+func (s *selfCheck) refresh() {
+	err := s.doCheck(context.Background())
+	if err != nil {
+		dwdSelfChecksTotal.With(prometheus.Labels{labelResult: resultFailure}).Inc()
+		klog.Warningf("Self-check failed, the observer is unhealthy: %v", err)
+	} else {
+		dwdSelfChecksTotal.With(prometheus.Labels{labelResult: resultSuccess}).Inc()
+	}
+
+	s.mux.Lock()
+	s.lastErr = err
+	s.mux.Unlock()
+}
+
+func (s *selfCheck) doCheck(ctx context.Context) error {
+	var errs []string
+	for _, url := range s.urls {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		resp, err := s.client.Do(req.WithContext(ctx))
+		if err != nil {
+			klog.V(4).Infof("Self-check of %s failed: %v", url, err)
+			errs = append(errs, err.Error())
+			continue
+		}
+		resp.Body.Close()
+		// Any response proves that the network path is fine.
+		return nil
+	}
+	return fmt.Errorf("none of the reference endpoints is reachable: %s", strings.Join(errs, "; "))
+}
@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/pointer"
+)
+
+var _ = Describe("selfCheck", func() {
+	var (
+		server   *httptest.Server
+		requests int32
+		down     string
+	)
+
+	BeforeEach(func() {
+		requests = 0
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		closed := httptest.NewServer(http.NotFoundHandler())
+		down = closed.URL
+		closed.Close()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newTestSelfCheck := func(urls ...string) *selfCheck {
+		return newSelfCheck(&api.SelfCheckConfig{
+			URLs:           urls,
+			TimeoutSeconds: pointer.Int32Ptr(1),
+			CacheSeconds:   pointer.Int32Ptr(60),
+		})
+	}
+
+	It("should succeed if any reference endpoint responds", func() {
+		s := newTestSelfCheck(down, server.URL)
+		s.refresh()
+		Expect(s.check()).To(Succeed())
+	})
+
+	It("should fail if no reference endpoint is reachable", func() {
+		s := newTestSelfCheck(down)
+		s.refresh()
+		Expect(s.check()).To(HaveOccurred())
+	})
+
+	It("should fail until the reference endpoints were requested", func() {
+		s := newTestSelfCheck(server.URL)
+		Expect(s.check()).To(MatchError(errSelfCheckPending))
+		Expect(atomic.LoadInt32(&requests)).To(BeZero())
+	})
+
+	It("should request the reference endpoints periodically and share the result in between", func() {
+		s := newTestSelfCheck(server.URL)
+		s.interval = 50 * time.Millisecond
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		go s.start(stopCh)
+
+		Eventually(s.check).Should(Succeed())
+		Expect(s.check()).To(Succeed())
+		Eventually(func() int32 { return atomic.LoadInt32(&requests) }).Should(BeNumerically(">=", 2))
+	})
+
+	It("should use a transport configured like the ones of the external probes", func() {
+		s := newTestSelfCheck(server.URL)
+		Expect(s.client.Transport).ToNot(BeIdenticalTo(http.DefaultTransport))
+		t, ok := s.client.Transport.(*http.Transport)
+		Expect(ok).To(BeTrue())
+		Expect(t.Proxy).ToNot(BeNil())
+		Expect(t.TLSHandshakeTimeout).To(Equal(10 * time.Second))
+	})
+
+	It("should always succeed if it is not configured", func() {
+		Expect(newSelfCheck(nil).check()).To(Succeed())
+	})
+})
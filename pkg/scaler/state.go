@@ -0,0 +1,264 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	retryutil "k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+)
+
+const (
+	probeStateConfigMapName   = "dependency-watchdog-probe-state"
+	defaultStateMaxAgeSeconds = 300
+
+	actionScaleUp   = "ScaleUp"
+	actionScaleDown = "ScaleDown"
+)
+
+// persistedResult is the persisted form of a probeResult. Class is the class of the failure, so that the
+// persisted state tells why a probe failed. The error itself is not persisted as its message changes with every
+// request, which would update the state on every probe. The sliding window of the results is not persisted,
+// only the health state latched from it.
+type persistedResult struct {
+	Failed    bool   `json:"failed,omitempty"`
+	Class     string `json:"class,omitempty"`
+	ResultRun int32  `json:"resultRun"`
+	Health    string `json:"health,omitempty"`
+}
+
+const (
+	healthStateHealthy   = "Healthy"
+	healthStateUnhealthy = "Unhealthy"
+)
+
+// errPersistedFailure is the error of a failed probe result restored from its persisted state.
+var errPersistedFailure = errors.New("the probe failed before the prober was resumed")
+
+func newPersistedResult(pr *probeResult) persistedResult {
+	r := persistedResult{ResultRun: pr.resultRun}
+	switch pr.state {
+	case healthHealthy:
+		r.Health = healthStateHealthy
+	case healthUnhealthy:
+		r.Health = healthStateUnhealthy
+	}
+	if pr.lastError != nil {
+		r.Failed = true
+		r.Class = string(pr.failureClass)
+	}
+	return r
+}
+
+func (r persistedResult) toProbeResult() probeResult {
+	pr := probeResult{resultRun: r.ResultRun}
+	switch r.Health {
+	case healthStateHealthy:
+		pr.state = healthHealthy
+	case healthStateUnhealthy:
+		pr.state = healthUnhealthy
+	}
+	if r.Failed {
+		pr.lastError = errPersistedFailure
+		pr.failureClass = api.FailureClass(r.Class)
+	}
+	return pr
+}
+
+// probeState is the state of a prober which is persisted so that it can be resumed after a restart
+// or a leader failover. LastAction is the last scale action taken on the dependants. Transitions are the times of
+// the recent scale transitions counted by the cooldown and FlappingSince is the time it latched into flapping.
+type probeState struct {
+	Internal       persistedResult `json:"internal"`
+	External       persistedResult `json:"external"`
+	LastAction     string          `json:"lastAction,omitempty"`
+	LastActionTime *metav1.Time    `json:"lastActionTime,omitempty"`
+	Transitions    []metav1.Time   `json:"transitions,omitempty"`
+	FlappingSince  *metav1.Time    `json:"flappingSince,omitempty"`
+	UpdateTime     metav1.Time     `json:"updateTime"`
+}
+
+// stateStore persists the states of the probers of a namespace in a config map in that namespace,
+// keyed by the probe name. A nil stateStore does not persist anything.
+type stateStore struct {
+	client kubernetes.Interface
+}
+
+// newStateStore returns a state store using the given client or nil if the state should not be persisted.
+func newStateStore(client kubernetes.Interface, enabled bool) *stateStore {
+	if !enabled {
+		return nil
+	}
+	return &stateStore{client: client}
+}
+
+// load returns the persisted state of the given probe or nil if there is none.
+func (s *stateStore) load(namespace, probe string) (*probeState, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	cm, err := s.client.CoreV1().ConfigMaps(namespace).Get(probeStateConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, ok := cm.Data[probe]
+	if !ok {
+		return nil, nil
+	}
+
+	state := &probeState{}
+	if err := json.Unmarshal([]byte(data), state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// save persists the state of the given probe.
+func (s *stateStore) save(namespace, probe string, state *probeState) error {
+	if s == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	cms := s.client.CoreV1().ConfigMaps(namespace)
+	return retryutil.RetryOnConflict(retryutil.DefaultBackoff, func() error {
+		cm, err := cms.Get(probeStateConfigMapName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = cms.Create(&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: namespace,
+					Name:      probeStateConfigMapName,
+				},
+				Data: map[string]string{probe: string(data)},
+			})
+			if apierrors.IsAlreadyExists(err) {
+				// Created concurrently by the prober of another probe. Retry as a conflict.
+				return apierrors.NewConflict(corev1.Resource("configmaps"), probeStateConfigMapName, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		cm = cm.DeepCopy()
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		cm.Data[probe] = string(data)
+		_, err = cms.Update(cm)
+		return err
+	})
+}
+
+// snapshotState returns the current state of the prober without the update time.
+func (p *prober) snapshotState() *probeState {
+	state := &probeState{
+		Internal:   newPersistedResult(&p.internalResult),
+		External:   newPersistedResult(&p.externalResult),
+		LastAction: p.lastAction,
+	}
+	if !p.lastActionTime.IsZero() {
+		state.LastActionTime = &metav1.Time{Time: p.lastActionTime}
+	}
+	if p.cooldown != nil {
+		for _, t := range p.cooldown.transitions {
+			state.Transitions = append(state.Transitions, metav1.Time{Time: t})
+		}
+		if !p.cooldown.flappingSince.IsZero() {
+			state.FlappingSince = &metav1.Time{Time: p.cooldown.flappingSince}
+		}
+	}
+	return state
+}
+
+// restoreState resumes the prober from its persisted state. The probe results are restored only if they
+// are recent enough to still be meaningful. The last action is always restored so that the prober
+// remembers that it scaled the dependants down.
+func (p *prober) restoreState() {
+	defer func() {
+		p.persistedState = p.snapshotState()
+	}()
+
+	state, err := p.stateStore.load(p.namespace, p.probeDeps.Name)
+	if err != nil {
+		klog.Errorf("%s/%s: failed to load the persisted prober state: %v", p.probeDeps.Name, p.namespace, err)
+		return
+	}
+	if state == nil {
+		return
+	}
+
+	p.lastAction = state.LastAction
+	if state.LastActionTime != nil {
+		p.lastActionTime = state.LastActionTime.Time
+	}
+	if p.cooldown != nil {
+		p.cooldown.transitions = nil
+		for _, t := range state.Transitions {
+			p.cooldown.transitions = append(p.cooldown.transitions, t.Time)
+		}
+		if state.FlappingSince != nil {
+			p.cooldown.setFlapping(state.FlappingSince.Time)
+		}
+	}
+	if time.Since(state.UpdateTime.Time) <= defaultStateMaxAgeSeconds*time.Second {
+		p.internalResult = state.Internal.toProbeResult()
+		p.externalResult = state.External.toProbeResult()
+	} else {
+		klog.V(4).Infof("%s/%s: ignoring the persisted probe results last updated at %s", p.probeDeps.Name, p.namespace, state.UpdateTime)
+	}
+
+	if p.lastAction == actionScaleDown {
+		p.holdDowns.set(p.namespace, p.probeDeps, true)
+		klog.Infof("%s/%s: resuming after the dependants were scaled down at %s. They are scaled up once the external probe is healthy.", p.probeDeps.Name, p.namespace, p.lastActionTime)
+	}
+}
+
+// persistState persists the state of the prober if it changed since it was last persisted.
+func (p *prober) persistState() {
+	state := p.snapshotState()
+	if reflect.DeepEqual(state, p.persistedState) {
+		return
+	}
+
+	state.UpdateTime = metav1.Now()
+	if err := p.stateStore.save(p.namespace, p.probeDeps.Name, state); err != nil {
+		klog.Errorf("%s/%s: failed to persist the prober state: %v", p.probeDeps.Name, p.namespace, err)
+		return
+	}
+	state.UpdateTime = metav1.Time{}
+	p.persistedState = state
+}
+
+// recordAction records the scale action taken on the dependants. The time is only updated if the action changes,
+// which counts as a transition for the cooldown.
+func (p *prober) recordAction(action string) {
+	if p.lastAction == action {
+		return
+	}
+	if p.lastAction != "" && p.cooldown.recordTransition(time.Now()) {
+		klog.Warningf("%s/%s: the dependants are flapping. No further scale transitions until the external probe is stable.", p.probeDeps.Name, p.namespace)
+	}
+	p.lastAction = action
+	p.lastActionTime = time.Now()
+}
@@ -0,0 +1,133 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/pointer"
+)
+
+var _ = Describe("stateStore", func() {
+	const ns = "test"
+
+	var (
+		client *fake.Clientset
+		store  *stateStore
+	)
+
+	BeforeEach(func() {
+		client = fake.NewSimpleClientset()
+		store = newStateStore(client, true)
+	})
+
+	newTestProber := func(probe string) *prober {
+		return &prober{
+			namespace:  ns,
+			stateStore: store,
+			probeDeps:  &api.ProbeDependants{Name: probe},
+		}
+	}
+
+	It("should persist the states of all the probes of a namespace", func() {
+		kas := &probeState{LastAction: actionScaleDown, UpdateTime: metav1.Now()}
+		etcd := &probeState{External: persistedResult{Failed: true, Class: string(api.FailureClassTimeout), ResultRun: 3}}
+		Expect(store.save(ns, "kube-apiserver", kas)).To(Succeed())
+		Expect(store.save(ns, "etcd", etcd)).To(Succeed())
+
+		state, err := store.load(ns, "etcd")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state.External).To(Equal(etcd.External))
+		state, err = store.load(ns, "kube-apiserver")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state.LastAction).To(Equal(actionScaleDown))
+		state, err = store.load(ns, "unknown")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state).To(BeNil())
+	})
+
+	It("should resume a prober from its persisted state", func() {
+		p := newTestProber("kube-apiserver")
+		p.externalResult = probeResult{lastError: errors.New("timeout"), failureClass: api.FailureClassTimeout, resultRun: 3}
+		p.recordAction(actionScaleDown)
+		p.persistState()
+
+		resumed := newTestProber("kube-apiserver")
+		resumed.restoreState()
+		Expect(resumed.lastAction).To(Equal(actionScaleDown))
+		Expect(resumed.externalResult.resultRun).To(Equal(int32(3)))
+		Expect(resumed.externalResult.lastError).To(Equal(errPersistedFailure))
+		Expect(resumed.externalResult.failureClass).To(Equal(api.FailureClassTimeout))
+	})
+
+	It("should resume the cooldown from the persisted state", func() {
+		config := &api.CooldownConfig{MaxTransitionsPerHour: pointer.Int32Ptr(1)}
+		p := newTestProber("kube-apiserver")
+		p.cooldown = newCooldown(config)
+		p.recordAction(actionScaleDown)
+		p.recordAction(actionScaleUp)
+		p.persistState()
+
+		By("counting the transitions before the restart")
+		resumed := newTestProber("kube-apiserver")
+		resumed.cooldown = newCooldown(config)
+		resumed.restoreState()
+		Expect(resumed.cooldown.transitions).To(HaveLen(1))
+		resumed.recordAction(actionScaleDown)
+		Expect(resumed.cooldown.flappingSince.IsZero()).To(BeFalse())
+		resumed.persistState()
+
+		By("keeping the flapping latch across restarts")
+		again := newTestProber("kube-apiserver")
+		again.cooldown = newCooldown(config)
+		again.restoreState()
+		Expect(again.cooldown.transitions).To(HaveLen(2))
+		reason, _ := again.cooldown.suppressReason(actionScaleUp, again.lastAction, again.lastActionTime, time.Now())
+		Expect(reason).To(Equal(reasonFlapping))
+
+		resumed.cooldown.release()
+		again.cooldown.release()
+	})
+
+	It("should not restore outdated probe results", func() {
+		Expect(store.save(ns, "kube-apiserver", &probeState{
+			External:       persistedResult{Failed: true, Class: string(api.FailureClassTimeout), ResultRun: 3},
+			LastAction:     actionScaleDown,
+			LastActionTime: &metav1.Time{Time: time.Now().Add(-time.Hour)},
+			UpdateTime:     metav1.NewTime(time.Now().Add(-time.Hour)),
+		})).To(Succeed())
+
+		p := newTestProber("kube-apiserver")
+		p.restoreState()
+		Expect(p.lastAction).To(Equal(actionScaleDown))
+		Expect(p.externalResult).To(Equal(probeResult{}))
+	})
+
+	It("should persist the state only if it changed", func() {
+		p := newTestProber("kube-apiserver")
+		p.restoreState()
+		p.persistState()
+		Expect(client.Actions()).To(HaveLen(1)) // get
+
+		p.recordAction(actionScaleUp)
+		p.persistState()
+		p.persistState()
+		Expect(client.Actions()).To(HaveLen(3)) // get and create
+
+		By("ignoring the changing messages of the same failure")
+		p.externalResult = probeResult{lastError: errors.New("request 1 timed out"), failureClass: api.FailureClassTimeout, resultRun: 1}
+		p.persistState()
+		Expect(client.Actions()).To(HaveLen(5)) // get and update
+		p.externalResult.lastError = errors.New("request 2 timed out")
+		p.persistState()
+		Expect(client.Actions()).To(HaveLen(5))
+	})
+})
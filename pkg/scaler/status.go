@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// proberStatus is the status of a prober as served by the status handler. It is the state the prober would
+// persist, including the classes of the last failures of its probes, whether or not the state is persisted.
+type proberStatus struct {
+	Namespace string `json:"namespace"`
+	Probe     string `json:"probe"`
+	probeState
+}
+
+// publishStatus publishes the current state of the prober to the status handler. It is called after every probe.
+func (p *prober) publishStatus() {
+	state := p.snapshotState()
+	state.UpdateTime = metav1.Now()
+	p.status.Store(state)
+}
+
+// NewStatusHandler returns the handler serving the status of all probers as JSON. It shows the health of their
+// probes, the classes of their last failures and their last scale actions.
+func (c *Controller) NewStatusHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		data, err := json.Marshal(c.statuses())
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		if _, err := rw.Write(data); err != nil {
+			klog.Errorf("Failed to write the prober status: %s", err)
+		}
+	})
+}
+
+// statuses returns the status of all probers which probed at least once, sorted by their keys.
+func (c *Controller) statuses() []proberStatus {
+	c.mux.Lock()
+	probers := make([]*prober, 0, len(c.probers))
+	for _, p := range c.probers {
+		probers = append(probers, p)
+	}
+	c.mux.Unlock()
+
+	statuses := make([]proberStatus, 0, len(probers))
+	for _, p := range probers {
+		state, ok := p.status.Load().(*probeState)
+		if !ok {
+			continue
+		}
+		statuses = append(statuses, proberStatus{Namespace: p.namespace, Probe: p.probeDeps.Name, probeState: *state})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Namespace != statuses[j].Namespace {
+			return statuses[i].Namespace < statuses[j].Namespace
+		}
+		return statuses[i].Probe < statuses[j].Probe
+	})
+	return statuses
+}
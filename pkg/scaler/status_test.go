@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("status handler", func() {
+	It("should serve the failure classes of the probes whether or not the state is persisted", func() {
+		p := &prober{namespace: "shoot--foo", probeDeps: &api.ProbeDependants{Name: "kube-apiserver"}}
+		idle := &prober{namespace: "shoot--bar", probeDeps: &api.ProbeDependants{Name: "kube-apiserver"}}
+		c := &Controller{probers: map[string]*prober{"shoot--foo/kube-apiserver": p, "shoot--bar/kube-apiserver": idle}}
+
+		p.externalResult = probeResult{lastError: errors.New("x509: certificate signed by unknown authority"), failureClass: api.FailureClassTLS, resultRun: 2, state: healthUnhealthy}
+		p.recordAction(actionScaleDown)
+		p.publishStatus()
+
+		rec := httptest.NewRecorder()
+		c.NewStatusHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+		Expect(rec.Code).To(Equal(http.StatusOK))
+
+		var statuses []map[string]interface{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), &statuses)).To(Succeed())
+		Expect(statuses).To(HaveLen(1))
+		Expect(statuses[0]).To(HaveKeyWithValue("namespace", "shoot--foo"))
+		Expect(statuses[0]).To(HaveKeyWithValue("probe", "kube-apiserver"))
+		Expect(statuses[0]).To(HaveKeyWithValue("lastAction", actionScaleDown))
+		Expect(statuses[0]["external"]).To(HaveKeyWithValue("class", string(api.FailureClassTLS)))
+		Expect(statuses[0]["external"]).To(HaveKeyWithValue("health", healthStateUnhealthy))
+	})
+})
@@ -0,0 +1,278 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingapi "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	listerappsv1 "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	deploymentGroupKind  = schema.GroupKind{Group: appsv1.GroupName, Kind: kindDeployment}
+	statefulSetGroupKind = schema.GroupKind{Group: appsv1.GroupName, Kind: kindStatefulSet}
+
+	errNoTargetCache = errors.New("no informer cache for the target kind")
+)
+
+// targetInfo is the state of a scale target as found in an informer cache.
+type targetInfo struct {
+	annotations       map[string]string
+	ownerReferences   []metav1.OwnerReference
+	specReplicas      int32
+	availableReplicas int32
+}
+
+// targetCache looks up the scale targets of a single kind in an informer cache.
+type targetCache interface {
+	get(namespace, name string) (*targetInfo, error)
+}
+
+type deploymentCache struct {
+	lister listerappsv1.DeploymentLister
+}
+
+func (c *deploymentCache) get(namespace, name string) (*targetInfo, error) {
+	d, err := c.lister.Deployments(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	info := &targetInfo{
+		annotations:       d.Annotations,
+		ownerReferences:   d.OwnerReferences,
+		availableReplicas: d.Status.AvailableReplicas,
+	}
+	if d.Spec.Replicas != nil {
+		info.specReplicas = *d.Spec.Replicas
+	}
+	return info, nil
+}
+
+type statefulSetCache struct {
+	lister listerappsv1.StatefulSetLister
+}
+
+func (c *statefulSetCache) get(namespace, name string) (*targetInfo, error) {
+	s, err := c.lister.StatefulSets(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	info := &targetInfo{
+		annotations:       s.Annotations,
+		ownerReferences:   s.OwnerReferences,
+		availableReplicas: s.Status.ReadyReplicas,
+	}
+	if s.Spec.Replicas != nil {
+		info.specReplicas = *s.Spec.Replicas
+	}
+	return info, nil
+}
+
+// fieldPaths are the paths of the fields holding the replicas and the available replicas of a kind.
+// The availability is read from the first of the availableReplicas paths that is present.
+type fieldPaths struct {
+	replicas          []string
+	availableReplicas [][]string
+}
+
+var defaultFieldPaths = fieldPaths{
+	replicas:          []string{"spec", "replicas"},
+	availableReplicas: [][]string{{"status", "availableReplicas"}, {"status", "readyReplicas"}},
+}
+
+func parseFieldPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "."), ".")
+}
+
+// fieldPathsOf returns the field paths configured for the dependant scales of every kind.
+// Kinds without configured field paths use the defaultFieldPaths.
+// It returns an error if dependant scales of the same kind are configured with different field paths
+// or if field paths are configured for deployments or statefulsets.
+func fieldPathsOf(probeDependantsList *api.ProbeDependantsList) (map[schema.GroupKind]fieldPaths, error) {
+	var (
+		paths      = make(map[schema.GroupKind]fieldPaths)
+		configured = make(map[schema.GroupKind]*api.DependantScaleDetails)
+	)
+	for _, pd := range probeDependantsList.Probes {
+		for _, dsd := range pd.DependantScales {
+			if dsd == nil {
+				continue
+			}
+			gk, err := targetGroupKind(dsd.ScaleRef)
+			if err != nil {
+				return nil, err
+			}
+			if first, ok := configured[gk]; ok {
+				if first.ReplicasPath != dsd.ReplicasPath || first.AvailableReplicasPath != dsd.AvailableReplicasPath {
+					return nil, fmt.Errorf("dependant scales of kind %s have different field paths", gk)
+				}
+				continue
+			}
+			configured[gk] = dsd
+			if dsd.ReplicasPath == "" && dsd.AvailableReplicasPath == "" {
+				continue
+			}
+			if gk == deploymentGroupKind || gk == statefulSetGroupKind {
+				return nil, fmt.Errorf("field paths are not supported for dependant scales of kind %s", gk)
+			}
+
+			fp := defaultFieldPaths
+			if dsd.ReplicasPath != "" {
+				fp.replicas = parseFieldPath(dsd.ReplicasPath)
+			}
+			if dsd.AvailableReplicasPath != "" {
+				fp.availableReplicas = [][]string{parseFieldPath(dsd.AvailableReplicasPath)}
+			}
+			paths[gk] = fp
+		}
+	}
+	return paths, nil
+}
+
+// dynamicCache looks up scale targets of any kind in a dynamic informer cache.
+// The replicas and the availability are read from the fields at the given paths.
+type dynamicCache struct {
+	lister cache.GenericLister
+	paths  fieldPaths
+}
+
+func (c *dynamicCache) get(namespace, name string) (*targetInfo, error) {
+	obj, err := c.lister.ByNamespace(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object of type %T in dynamic informer cache", obj)
+	}
+
+	info := &targetInfo{
+		annotations:     u.GetAnnotations(),
+		ownerReferences: u.GetOwnerReferences(),
+	}
+	if replicas, found, err := unstructured.NestedInt64(u.Object, c.paths.replicas...); err != nil {
+		return nil, err
+	} else if found {
+		info.specReplicas = int32(replicas)
+	}
+	for _, path := range c.paths.availableReplicas {
+		if replicas, found, err := unstructured.NestedInt64(u.Object, path...); err != nil {
+			return nil, err
+		} else if found {
+			info.availableReplicas = int32(replicas)
+			break
+		}
+	}
+	return info, nil
+}
+
+// targetCaches holds the informer caches of all the kinds of scale targets and
+// notifies waiters about changes to the targets.
+type targetCaches struct {
+	mux      sync.RWMutex
+	caches   map[schema.GroupKind]targetCache
+	labels   map[schema.GroupKind]prometheus.Labels
+	notifier *changeNotifier
+}
+
+func newTargetCaches() *targetCaches {
+	return &targetCaches{
+		caches:   make(map[schema.GroupKind]targetCache),
+		labels:   make(map[schema.GroupKind]prometheus.Labels),
+		notifier: newChangeNotifier(),
+	}
+}
+
+// add registers the cache for the given kind. The waiters for targets of this kind are notified
+// about every change seen by the informer.
+func (t *targetCaches) add(gk schema.GroupKind, resource string, informer cache.SharedIndexInformer, c targetCache) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	informer.AddEventHandler(t.notifier.handlerFor(gk.String()))
+	t.caches[gk] = c
+	t.labels[gk] = prometheus.Labels{labelResource: resource}
+}
+
+// has checks if there is a cache for the kind of the given target.
+func (t *targetCaches) has(ref autoscalingapi.CrossVersionObjectReference) bool {
+	gk, err := targetGroupKind(ref)
+	if err != nil {
+		return false
+	}
+
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	_, ok := t.caches[gk]
+	return ok
+}
+
+// get looks up the target in the cache for its kind. It returns errNoTargetCache if there is no such cache.
+func (t *targetCaches) get(namespace string, ref autoscalingapi.CrossVersionObjectReference) (*targetInfo, error) {
+	gk, err := targetGroupKind(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mux.RLock()
+	c, ok := t.caches[gk]
+	labels := t.labels[gk]
+	t.mux.RUnlock()
+	if !ok {
+		return nil, errNoTargetCache
+	}
+
+	dwdGetTargetFromCacheTotal.With(labels).Inc()
+	return c.get(namespace, ref.Name)
+}
+
+// watch registers a waiter for changes to the given target. See changeNotifier.watch.
+func (t *targetCaches) watch(namespace string, ref autoscalingapi.CrossVersionObjectReference) (<-chan struct{}, func()) {
+	gk, err := targetGroupKind(ref)
+	if err != nil {
+		return nil, func() {}
+	}
+	return t.notifier.watch(gk.String(), namespace, ref.Name)
+}
+
+// targetGroupKind returns the group and kind of the target.
+// Deployments of the deprecated extensions group are looked up as apps deployments.
+func targetGroupKind(ref autoscalingapi.CrossVersionObjectReference) (schema.GroupKind, error) {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return schema.GroupKind{}, err
+	}
+	gk := schema.GroupKind{Group: gv.Group, Kind: ref.Kind}
+	if gk.Group == "extensions" && gk.Kind == kindDeployment {
+		return deploymentGroupKind, nil
+	}
+	return gk, nil
+}
+
+// scaleRefsOf returns all the scale targets and the targets they depend on in the configuration.
+func scaleRefsOf(probeDependantsList *api.ProbeDependantsList) []autoscalingapi.CrossVersionObjectReference {
+	var refs []autoscalingapi.CrossVersionObjectReference
+	for _, pd := range probeDependantsList.Probes {
+		for _, dsd := range pd.DependantScales {
+			if dsd == nil {
+				continue
+			}
+			refs = append(refs, dsd.ScaleRef)
+			refs = append(refs, dsd.ScaleRefDependsOn...)
+		}
+	}
+	return refs
+}
@@ -0,0 +1,232 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"context"
+	"time"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/pointer"
+)
+
+var _ = Describe("targetCaches", func() {
+	const ns = "test"
+
+	var (
+		etcdGVR = schema.GroupVersionResource{Group: "druid.gardener.cloud", Version: "v1alpha1", Resource: "etcds"}
+		etcdGK  = schema.GroupKind{Group: "druid.gardener.cloud", Kind: "Etcd"}
+
+		targets          *targetCaches
+		deployments      cache.SharedIndexInformer
+		statefulSets     cache.SharedIndexInformer
+		etcds            cache.SharedIndexInformer
+		deploymentRef    = autoscalingv1.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: kindDeployment, Name: "kube-controller-manager"}
+		oldDeploymentRef = autoscalingv1.CrossVersionObjectReference{APIVersion: "extensions/v1beta1", Kind: kindDeployment, Name: "kube-controller-manager"}
+		statefulSetRef   = autoscalingv1.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: kindStatefulSet, Name: "prometheus"}
+		etcdRef          = autoscalingv1.CrossVersionObjectReference{APIVersion: "druid.gardener.cloud/v1alpha1", Kind: "Etcd", Name: "etcd-main"}
+		unknownRef       = autoscalingv1.CrossVersionObjectReference{APIVersion: "example.com/v1", Kind: "Unknown", Name: "unknown"}
+	)
+
+	BeforeEach(func() {
+		factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+		deployments = factory.Apps().V1().Deployments().Informer()
+		statefulSets = factory.Apps().V1().StatefulSets().Informer()
+		etcds = cache.NewSharedIndexInformer(nil, &unstructured.Unstructured{}, 0, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+
+		targets = newTargetCaches()
+		targets.add(deploymentGroupKind, resourceDeployments, deployments, &deploymentCache{lister: factory.Apps().V1().Deployments().Lister()})
+		targets.add(statefulSetGroupKind, resourceStatefulSets, statefulSets, &statefulSetCache{lister: factory.Apps().V1().StatefulSets().Lister()})
+		targets.add(etcdGK, etcdGVR.Resource, etcds, &dynamicCache{lister: cache.NewGenericLister(etcds.GetIndexer(), etcdGVR.GroupResource()), paths: defaultFieldPaths})
+
+		d := newDeployment(ns, deploymentRef.Name, 1)
+		d.Spec.Replicas = pointer.Int32Ptr(2)
+		d.Annotations = map[string]string{ignoreScalingAnnotationKey: "true"}
+		Expect(deployments.GetIndexer().Add(d)).To(Succeed())
+
+		Expect(statefulSets.GetIndexer().Add(&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: statefulSetRef.Name},
+			Spec:       appsv1.StatefulSetSpec{Replicas: pointer.Int32Ptr(3)},
+			Status:     appsv1.StatefulSetStatus{ReadyReplicas: 2},
+		})).To(Succeed())
+
+		etcd := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec":   map[string]interface{}{"replicas": int64(1)},
+			"status": map[string]interface{}{"readyReplicas": int64(1)},
+		}}
+		etcd.SetNamespace(ns)
+		etcd.SetName(etcdRef.Name)
+		Expect(etcds.GetIndexer().Add(etcd)).To(Succeed())
+	})
+
+	It("should look up targets of every registered kind", func() {
+		Expect(targets.get(ns, deploymentRef)).To(Equal(&targetInfo{
+			annotations:       map[string]string{ignoreScalingAnnotationKey: "true"},
+			specReplicas:      2,
+			availableReplicas: 1,
+		}))
+		Expect(targets.get(ns, oldDeploymentRef)).To(Equal(&targetInfo{
+			annotations:       map[string]string{ignoreScalingAnnotationKey: "true"},
+			specReplicas:      2,
+			availableReplicas: 1,
+		}))
+		Expect(targets.get(ns, statefulSetRef)).To(Equal(&targetInfo{
+			specReplicas:      3,
+			availableReplicas: 2,
+		}))
+		Expect(targets.get(ns, etcdRef)).To(Equal(&targetInfo{
+			specReplicas:      1,
+			availableReplicas: 1,
+		}))
+	})
+
+	It("should report targets of unregistered kinds", func() {
+		Expect(targets.has(unknownRef)).To(BeFalse())
+		_, err := targets.get(ns, unknownRef)
+		Expect(err).To(Equal(errNoTargetCache))
+	})
+
+	Describe("waitForTarget", func() {
+		var p *prober
+
+		BeforeEach(func() {
+			p = &prober{
+				namespace: ns,
+				targets:   targets,
+			}
+		})
+
+		isAvailable := func(t *targetInfo) bool {
+			return t.availableReplicas >= 2
+		}
+
+		It("should return once the target becomes available", func() {
+			done := make(chan error)
+			go func() {
+				done <- p.waitForTarget(context.Background(), deploymentRef, isAvailable)
+			}()
+			Consistently(done).ShouldNot(Receive())
+
+			available := newDeployment(ns, deploymentRef.Name, 2)
+			Expect(deployments.GetIndexer().Update(available)).To(Succeed())
+			targets.notifier.notify(deploymentGroupKind.String(), available)
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("should return immediately if the target is already available", func() {
+			Expect(p.waitForTarget(context.Background(), statefulSetRef, isAvailable)).To(Succeed())
+		})
+
+		It("should fail if the target does not become available in time", func() {
+			ctx, cancelFn := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancelFn()
+			Expect(p.waitForTarget(ctx, etcdRef, isAvailable)).To(Equal(context.DeadlineExceeded))
+		})
+
+		It("should fail if the target does not exist", func() {
+			ref := deploymentRef
+			ref.Name = "unknown"
+			Expect(p.waitForTarget(context.Background(), ref, isAvailable)).To(HaveOccurred())
+		})
+	})
+})
+
+var _ = DescribeTable("fieldPathsOf", func(dependantScales []*api.DependantScaleDetails, expectedPaths map[schema.GroupKind]fieldPaths, expectErr bool) {
+	paths, err := fieldPathsOf(&api.ProbeDependantsList{
+		Probes: []api.ProbeDependants{{Name: "test", DependantScales: dependantScales}},
+	})
+	if expectErr {
+		Expect(err).To(HaveOccurred())
+		return
+	}
+	Expect(err).ToNot(HaveOccurred())
+	Expect(paths).To(Equal(expectedPaths))
+},
+	Entry("no field paths", []*api.DependantScaleDetails{
+		newDependantScale("kube-controller-manager"),
+	}, map[schema.GroupKind]fieldPaths{}, false),
+	Entry("field paths of a custom resource", []*api.DependantScaleDetails{
+		newCustomDependantScale("etcd-main", "spec.replicas", ".status.readyReplicas"),
+		newCustomDependantScale("etcd-events", "spec.replicas", ".status.readyReplicas"),
+	}, map[schema.GroupKind]fieldPaths{
+		{Group: "druid.gardener.cloud", Kind: "Etcd"}: {
+			replicas:          []string{"spec", "replicas"},
+			availableReplicas: [][]string{{"status", "readyReplicas"}},
+		},
+	}, false),
+	Entry("only replicas path", []*api.DependantScaleDetails{
+		newCustomDependantScale("etcd-main", "spec.replicas", ""),
+	}, map[schema.GroupKind]fieldPaths{
+		{Group: "druid.gardener.cloud", Kind: "Etcd"}: {
+			replicas:          []string{"spec", "replicas"},
+			availableReplicas: defaultFieldPaths.availableReplicas,
+		},
+	}, false),
+	Entry("different field paths of the same kind", []*api.DependantScaleDetails{
+		newCustomDependantScale("etcd-main", "spec.replicas", "status.readyReplicas"),
+		newCustomDependantScale("etcd-events", "spec.replicas", ""),
+	}, nil, true),
+	Entry("field paths of a deployment", []*api.DependantScaleDetails{
+		func() *api.DependantScaleDetails {
+			dsd := newDependantScale("kube-controller-manager")
+			dsd.ReplicasPath = "spec.replicas"
+			return dsd
+		}(),
+	}, nil, true),
+)
+
+func newCustomDependantScale(name, replicasPath, availableReplicasPath string) *api.DependantScaleDetails {
+	return &api.DependantScaleDetails{
+		ScaleRef: autoscalingv1.CrossVersionObjectReference{
+			APIVersion: "druid.gardener.cloud/v1alpha1",
+			Kind:       "Etcd",
+			Name:       name,
+		},
+		ReplicasPath:          replicasPath,
+		AvailableReplicasPath: availableReplicasPath,
+	}
+}
+
+var _ = Describe("getPatchingFn", func() {
+	It("should patch the replicas at the given field path", func() {
+		var (
+			gvr  = schema.GroupVersionResource{Group: "druid.gardener.cloud", Version: "v1alpha1", Resource: "etcds"}
+			etcd = &unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "druid.gardener.cloud/v1alpha1",
+				"kind":       "Etcd",
+				"spec":       map[string]interface{}{"replicas": int64(1), "foo": "bar"},
+			}}
+		)
+		etcd.SetNamespace("test")
+		etcd.SetName("etcd-main")
+
+		client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), etcd)
+		p := &prober{
+			namespace:     "test",
+			dynamicClient: client,
+		}
+
+		scalingFn, err := p.getPatchingFn(gvr, "etcd-main", []string{"spec", "replicas"}, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(scalingFn()).To(BeTrue())
+
+		actual, err := client.Resource(gvr).Namespace("test").Get("etcd-main", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.Object["spec"]).To(Equal(map[string]interface{}{"replicas": int64(0), "foo": "bar"}))
+	})
+})
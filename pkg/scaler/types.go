@@ -5,18 +5,18 @@
 package scaler
 
 import (
+	"context"
 	"sync"
 
+	"github.com/gardener/dependency-watchdog/pkg/maintenance"
 	"github.com/gardener/dependency-watchdog/pkg/multicontext"
+	"github.com/gardener/dependency-watchdog/pkg/nsselector"
 	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
-	gardnerinformer "github.com/gardener/gardener/pkg/client/extensions/informers/externalversions"
-	gardenerlisterv1alpha1 "github.com/gardener/gardener/pkg/client/extensions/listers/extensions/v1alpha1"
 	"github.com/prometheus/client_golang/prometheus"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
-	listerappsv1 "k8s.io/client-go/listers/apps/v1"
-	listerv1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/scale"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
@@ -25,43 +25,71 @@ import (
 
 // Controller looks at ServiceDependants and reconciles the dependantPods once the service becomes available.
 type Controller struct {
-	client                 kubernetes.Interface
-	mapper                 apimeta.RESTMapper
-	scalesGetter           scale.ScalesGetter
-	informerFactory        informers.SharedInformerFactory
-	secretsInformer        cache.SharedIndexInformer
-	secretsLister          listerv1.SecretLister
-	clusterInformerFactory gardnerinformer.SharedInformerFactory
-	clusterInformer        cache.SharedIndexInformer
-	clusterLister          gardenerlisterv1alpha1.ClusterLister
-	deploymentsInformer    cache.SharedIndexInformer
-	deploymentsLister      listerappsv1.DeploymentLister
-	workqueue              workqueue.RateLimitingInterface
-	hasSecretsSynced       cache.InformerSynced
-	hasClustersSynced      cache.InformerSynced
-	hasDeploymentsSynced   cache.InformerSynced
-	stopCh                 <-chan struct{}
-	probeDependantsList    *api.ProbeDependantsList
-	probers                map[string]*prober // the key is <namespace>/<probeDependents.Name>
-	mux                    sync.Mutex
+	client              kubernetes.Interface
+	mapper              apimeta.RESTMapper
+	scalesGetter        scale.ScalesGetter
+	informerFactory     informers.SharedInformerFactory
+	secrets             *kubeconfigSecrets
+	readiness           readinessSource
+	namespacesInformer  cache.SharedIndexInformer
+	namespaceSelector   *nsselector.Selector
+	dynamicClient       dynamic.Interface
+	targets             *targetCaches
+	conflicts           *conflictDetector
+	circuitBreaker      *circuitBreaker
+	selfCheck           *selfCheck
+	stateStore          *stateStore
+	holdDowns           *holdDowns
+	scheduler           *probeScheduler
+	probeCache          *probeCache
+	maintenance         *maintenance.Checker
+	workqueue           workqueue.RateLimitingInterface
+	hasSecretsSynced    cache.InformerSynced
+	hasNamespacesSynced cache.InformerSynced
+	hasTargetsSynced    []cache.InformerSynced
+	targetInformers     []cache.SharedIndexInformer // informers not taken from the informer factories
+	stopCh              <-chan struct{}
+	probeDependantsList *api.ProbeDependantsList
+	probers             map[string]*prober         // the key is <namespace>/<probeDependents.Name>
+	releases            map[string]context.Context // the releases of stopped probers by the key of the prober
+	mux                 sync.Mutex
 	*multicontext.Multicontext
+	// ProbeWorkers is the number of workers running the probes of all probers.
+	ProbeWorkers int
+	// EmergencyStop suspends all scale updates while it is active. It is optional.
+	EmergencyStop *maintenance.EmergencyStop
 	// LeaderElection defines the configuration of leader election client.
 	LeaderElection componentbaseconfig.LeaderElectionConfiguration
 }
 
 const (
-	dwdNamespace        = "dwd"
-	subsystemAggregate  = "aggr"
-	labelResult         = "result"
-	resultSuccess       = "success"
-	resultFailure       = "failure"
-	labelResource       = "resource"
-	resourceSecrets     = "secrets"
-	resourceDeployments = "deployments"
-	labelVerb           = "verb"
-	verbDiscovery       = "discovery"
-	verbGet             = "GET"
-	verbUpdate          = "UPDATE"
+	dwdNamespace         = "dwd"
+	subsystemAggregate   = "aggr"
+	labelResult          = "result"
+	resultSuccess        = "success"
+	resultFailure        = "failure"
+	labelResource        = "resource"
+	resourceSecrets      = "secrets"
+	resourceDeployments  = "deployments"
+	resourceStatefulSets = "statefulsets"
+	labelVerb            = "verb"
+	verbDiscovery        = "discovery"
+	verbGet              = "GET"
+	verbUpdate           = "UPDATE"
+	verbPatch            = "PATCH"
+	labelPolicy          = "policy"
+	labelReason          = "reason"
+	reasonConflict       = "conflict"
+	reasonThrottled      = "throttled"
+	reasonOther          = "other"
+	reasonMinDown        = "min_down"
+	reasonMinUp          = "min_up"
+	reasonFlapping       = "flapping"
+	labelMode            = "mode"
+	labelProbeType       = "type"
+	probeTypeInternal    = "internal"
+	probeTypeExternal    = "external"
+	labelClass           = "class"
 )
 
 var (
@@ -105,6 +133,16 @@ var (
 		[]string{labelResult},
 	)
 
+	dwdSelfChecksTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: dwdNamespace,
+			Subsystem: subsystemAggregate,
+			Name:      "self_checks_total",
+			Help:      "The accumulated total number of checks of its own network path done by the dependency-watchdog.",
+		},
+		[]string{labelResult},
+	)
+
 	dwdScaleRequestsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: dwdNamespace,
@@ -115,6 +153,137 @@ var (
 		[]string{labelVerb},
 	)
 
+	dwdScaleConflictsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: dwdNamespace,
+			Subsystem: subsystemAggregate,
+			Name:      "scale_conflicts_total",
+			Help:      "The accumulated total number of conflicts with other scalers detected by the dependency-watchdog.",
+		},
+		[]string{labelPolicy},
+	)
+
+	dwdHeldDownScaleUpsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: dwdNamespace,
+			Subsystem: subsystemAggregate,
+			Name:      "held_down_scale_ups_total",
+			Help:      "The accumulated total number of scale ups of targets held down by the dependency-watchdog seen by its webhook.",
+		},
+		[]string{labelMode},
+	)
+
+	dwdCircuitBreakerTripped = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: dwdNamespace,
+			Subsystem: subsystemAggregate,
+			Name:      "circuit_breaker_tripped",
+			Help:      "Whether the circuit breaker suppressing scale downs of the dependency-watchdog is tripped.",
+		},
+		nil,
+	)
+
+	dwdCircuitBreakerTripsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: dwdNamespace,
+			Subsystem: subsystemAggregate,
+			Name:      "circuit_breaker_trips_total",
+			Help:      "The accumulated total number of times the circuit breaker of the dependency-watchdog tripped.",
+		},
+		nil,
+	)
+
+	dwdSuppressedScaleDownsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: dwdNamespace,
+			Subsystem: subsystemAggregate,
+			Name:      "suppressed_scale_downs_total",
+			Help:      "The accumulated total number of scale downs suppressed by the circuit breaker of the dependency-watchdog.",
+		},
+		nil,
+	)
+
+	dwdScaleErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: dwdNamespace,
+			Subsystem: subsystemAggregate,
+			Name:      "scale_errors_total",
+			Help:      "The accumulated total number of failed attempts of the dependency-watchdog to scale a target.",
+		},
+		[]string{labelReason},
+	)
+
+	dwdScheduledProbes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: dwdNamespace,
+			Subsystem: subsystemAggregate,
+			Name:      "scheduled_probes",
+			Help:      "The number of probes currently scheduled by the dependency-watchdog.",
+		},
+		nil,
+	)
+
+	dwdProbeScheduleDelaySeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: dwdNamespace,
+			Subsystem: subsystemAggregate,
+			Name:      "probe_schedule_delay_seconds",
+			Help:      "The delay between the time a probe was due and the time it was handed to a worker of the dependency-watchdog.",
+			Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 2, 5, 10, 30},
+		},
+		nil,
+	)
+
+	dwdSkippedProbesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: dwdNamespace,
+			Subsystem: subsystemAggregate,
+			Name:      "skipped_probes_total",
+			Help:      "The accumulated total number of probe runs skipped by the dependency-watchdog as the probes ran late.",
+		},
+		nil,
+	)
+
+	dwdSharedProbeResultsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: dwdNamespace,
+			Subsystem: subsystemAggregate,
+			Name:      "shared_probe_results_total",
+			Help:      "The accumulated total number of probes answered by the dependency-watchdog with the result of a probe of the same endpoint by another prober.",
+		},
+		nil,
+	)
+
+	dwdProbeFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: dwdNamespace,
+			Subsystem: subsystemAggregate,
+			Name:      "probe_failures_total",
+			Help:      "The accumulated total number of failed probes of the dependency-watchdog by the type of the probe and the class of the failure.",
+		},
+		[]string{labelProbeType, labelClass},
+	)
+
+	dwdFlappingProbes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: dwdNamespace,
+			Subsystem: subsystemAggregate,
+			Name:      "flapping_probes",
+			Help:      "The number of probes of the dependency-watchdog which take no scale actions as their dependants transitioned too often.",
+		},
+		nil,
+	)
+
+	dwdSuppressedScaleTransitionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: dwdNamespace,
+			Subsystem: subsystemAggregate,
+			Name:      "suppressed_scale_transitions_total",
+			Help:      "The accumulated total number of scale transitions suppressed by the cooldowns of the dependency-watchdog.",
+		},
+		[]string{labelReason},
+	)
+
 	dwdThrottledScaleRequestsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: dwdNamespace,
@@ -131,11 +300,29 @@ func init() {
 	for _, lr := range []string{resultSuccess, resultFailure} {
 		dwdInternalProbesTotal.With(prometheus.Labels{labelResult: lr}).Add(0)
 		dwdExternalProbesTotal.With(prometheus.Labels{labelResult: lr}).Add(0)
+		dwdSelfChecksTotal.With(prometheus.Labels{labelResult: lr}).Add(0)
 	}
-	for _, lr := range []string{resourceSecrets, resourceDeployments} {
+	for _, lr := range []string{resourceSecrets, resourceDeployments, resourceStatefulSets} {
 		dwdGetTargetFromCacheTotal.With(prometheus.Labels{labelResource: lr}).Add(0)
 	}
-	for _, lv := range []string{verbDiscovery, verbGet, verbUpdate} {
+	for _, lp := range []api.ConflictPolicy{api.ConflictPolicySkip, api.ConflictPolicyPause, api.ConflictPolicyProceedAndWarn} {
+		dwdScaleConflictsTotal.With(prometheus.Labels{labelPolicy: string(lp)}).Add(0)
+	}
+	for _, lr := range []string{reasonConflict, reasonThrottled, reasonOther} {
+		dwdScaleErrorsTotal.With(prometheus.Labels{labelReason: lr}).Add(0)
+	}
+	for _, lt := range []string{probeTypeInternal, probeTypeExternal} {
+		for _, lc := range failureClasses {
+			dwdProbeFailuresTotal.With(prometheus.Labels{labelProbeType: lt, labelClass: string(lc)}).Add(0)
+		}
+	}
+	for _, lr := range []string{reasonMinDown, reasonMinUp, reasonFlapping} {
+		dwdSuppressedScaleTransitionsTotal.With(prometheus.Labels{labelReason: lr}).Add(0)
+	}
+	for _, lm := range []WebhookMode{WebhookModeReject, WebhookModeWarn} {
+		dwdHeldDownScaleUpsTotal.With(prometheus.Labels{labelMode: string(lm)}).Add(0)
+	}
+	for _, lv := range []string{verbDiscovery, verbGet, verbUpdate, verbPatch} {
 		dwdScaleRequestsTotal.With(prometheus.Labels{labelVerb: lv}).Add(0)
 		dwdThrottledScaleRequestsTotal.With(prometheus.Labels{labelVerb: lv}).Add(0)
 	}
@@ -146,4 +333,18 @@ func init() {
 	prometheus.MustRegister(dwdExternalProbesTotal)
 	prometheus.MustRegister(dwdScaleRequestsTotal)
 	prometheus.MustRegister(dwdThrottledScaleRequestsTotal)
+	prometheus.MustRegister(dwdScaleErrorsTotal)
+	prometheus.MustRegister(dwdSelfChecksTotal)
+	prometheus.MustRegister(dwdScaleConflictsTotal)
+	prometheus.MustRegister(dwdHeldDownScaleUpsTotal)
+	prometheus.MustRegister(dwdCircuitBreakerTripped)
+	prometheus.MustRegister(dwdCircuitBreakerTripsTotal)
+	prometheus.MustRegister(dwdSuppressedScaleDownsTotal)
+	prometheus.MustRegister(dwdScheduledProbes)
+	prometheus.MustRegister(dwdProbeScheduleDelaySeconds)
+	prometheus.MustRegister(dwdSkippedProbesTotal)
+	prometheus.MustRegister(dwdSharedProbeResultsTotal)
+	prometheus.MustRegister(dwdProbeFailuresTotal)
+	prometheus.MustRegister(dwdFlappingProbes)
+	prometheus.MustRegister(dwdSuppressedScaleTransitionsTotal)
 }
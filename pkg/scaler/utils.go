@@ -5,9 +5,11 @@
 package scaler
 
 import (
+	"fmt"
 	"io/ioutil"
 	"strings"
 
+	"github.com/gardener/dependency-watchdog/pkg/nsselector"
 	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
 	extensionscontroller "github.com/gardener/gardener/extensions/pkg/controller"
 	gardencorev1beta1 "github.com/gardener/gardener/pkg/apis/core/v1beta1"
@@ -22,7 +24,62 @@ func LoadProbeDependantsListFile(file string) (*api.ProbeDependantsList, error)
 	if err != nil {
 		return nil, err
 	}
-	return api.Decode(data)
+	deps, err := api.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateProbeDependantsList(deps); err != nil {
+		return nil, err
+	}
+	return deps, nil
+}
+
+// validatePeriods checks that the period of the probe lies within its adaptive bounds.
+func validatePeriods(probe *api.ProbeConfig) error {
+	period, minPeriod, maxPeriod := periodsOf(probe)
+	if minPeriod <= 0 {
+		return fmt.Errorf("the periods must be positive")
+	}
+	if minPeriod > period || period > maxPeriod {
+		return fmt.Errorf("minPeriodSeconds %s, periodSeconds %s and maxPeriodSeconds %s must be ascending", minPeriod, period, maxPeriod)
+	}
+	return nil
+}
+
+// validateProbeDependantsList checks that the periods, the evaluation, the cooldown and the failure classes of
+// every probe are valid, that its dependant scales form an acyclic graph, that the field paths of the dependant
+// scales are consistent and that the readiness source and the namespace selector are valid.
+func validateProbeDependantsList(probeDependantsList *api.ProbeDependantsList) error {
+	for _, pd := range probeDependantsList.Probes {
+		if _, err := newScaleGraph(pd.DependantScales); err != nil {
+			return fmt.Errorf("invalid dependant scales for probe %s: %v", pd.Name, err)
+		}
+		if pd.Probe != nil {
+			if err := validatePeriods(pd.Probe); err != nil {
+				return fmt.Errorf("invalid periods for probe %s: %v", pd.Name, err)
+			}
+			_, _, maxPeriod := periodsOf(pd.Probe)
+			if err := validateEvaluation(pd.Probe.Evaluation, maxPeriod); err != nil {
+				return fmt.Errorf("invalid evaluation for probe %s: %v", pd.Name, err)
+			}
+			if err := validateCooldown(pd.Probe.Cooldown); err != nil {
+				return fmt.Errorf("invalid cooldown for probe %s: %v", pd.Name, err)
+			}
+			if err := validateFailureClasses(pd.Probe); err != nil {
+				return fmt.Errorf("invalid failure classes for probe %s: %v", pd.Name, err)
+			}
+		}
+	}
+	if _, err := fieldPathsOf(probeDependantsList); err != nil {
+		return fmt.Errorf("invalid dependant scales: %v", err)
+	}
+	if err := ValidateReadinessSource(probeDependantsList.ReadinessSource); err != nil {
+		return fmt.Errorf("invalid readiness source: %v", err)
+	}
+	if err := nsselector.Validate(probeDependantsList.NamespaceSelector); err != nil {
+		return fmt.Errorf("invalid namespace selector: %v", err)
+	}
+	return nil
 }
 
 func isRateLimited(err error) bool {
@@ -34,7 +91,8 @@ func isRateLimited(err error) bool {
 	return strings.HasPrefix(err.Error(), prefix)
 }
 
-func shootHibernationStateChanged(old, new *gardenerv1alpha1.Cluster) bool {
+// shootStateChanged checks if the hibernation state of the shoot or the reason to stand down for it changed.
+func shootStateChanged(old, new *gardenerv1alpha1.Cluster) bool {
 	decoder, err := extensionscontroller.NewGardenDecoder()
 	if err != nil {
 		klog.V(4).Infof("Error getting gardener decoder for cluster %v. Err: %v", new.Name, err)
@@ -52,9 +110,63 @@ func shootHibernationStateChanged(old, new *gardenerv1alpha1.Cluster) bool {
 		return false
 	}
 
-	return doCheckShootHibernationStateChanged(oldShoot, newShoot)
+	return doCheckShootHibernationStateChanged(oldShoot, newShoot) || shootNotReadyReason(oldShoot) != shootNotReadyReason(newShoot)
 }
 
 func doCheckShootHibernationStateChanged(oldShoot, newShoot *gardencorev1beta1.Shoot) bool {
 	return oldShoot.Status.IsHibernated != newShoot.Status.IsHibernated || gardencorev1beta1helper.HibernationIsEnabled(oldShoot) != gardencorev1beta1helper.HibernationIsEnabled(newShoot)
 }
+
+// shootNotReadyReason returns why the dependency-watchdog has to stand down for the shoot or an empty string if
+// it does not. Besides hibernated shoots it stands down for shoots which are deleted, whose control plane is
+// migrated or restored, whose last operation failed or which are created or reconciled after a change of their
+// spec, as gardenlet scales the control plane components itself then.
+func shootNotReadyReason(shoot *gardencorev1beta1.Shoot) string {
+	if shoot.DeletionTimestamp != nil {
+		return "the shoot is being deleted"
+	}
+	if gardencorev1beta1helper.HibernationIsEnabled(shoot) || shoot.Status.IsHibernated {
+		return "the shoot is hibernated"
+	}
+
+	op := shoot.Status.LastOperation
+	if op == nil {
+		return ""
+	}
+	switch {
+	case op.Type == gardencorev1beta1.LastOperationTypeDelete:
+		return "the shoot is being deleted"
+	case op.Type == gardencorev1beta1.LastOperationTypeMigrate:
+		// the control plane stays in the source seed until it is deleted after the migration
+		return "the control plane of the shoot is being migrated"
+	case op.Type == gardencorev1beta1.LastOperationTypeRestore && op.State != gardencorev1beta1.LastOperationStateSucceeded:
+		return "the control plane of the shoot is being restored"
+	case op.State == gardencorev1beta1.LastOperationStateFailed:
+		return fmt.Sprintf("the last %s operation of the shoot failed", strings.ToLower(string(op.Type)))
+	case op.State == gardencorev1beta1.LastOperationStateProcessing && op.Type == gardencorev1beta1.LastOperationTypeCreate:
+		return "the shoot is being created"
+	case op.State == gardencorev1beta1.LastOperationStateProcessing && shoot.Generation != shoot.Status.ObservedGeneration:
+		return "the shoot is being reconciled after a change of its spec"
+	}
+	return ""
+}
+
+// shootKeepsControlPlane checks if the control plane of the shoot is meant to run, i.e. it is neither hibernated
+// nor deleted nor moved to another seed. The dependency-watchdog scales up the targets it scaled down before it
+// stands down for such shoots, e.g. as their last operation failed.
+func shootKeepsControlPlane(shoot *gardencorev1beta1.Shoot) bool {
+	if shoot.DeletionTimestamp != nil || gardencorev1beta1helper.HibernationIsEnabled(shoot) || shoot.Status.IsHibernated {
+		return false
+	}
+	op := shoot.Status.LastOperation
+	if op == nil {
+		return true
+	}
+	switch {
+	case op.Type == gardencorev1beta1.LastOperationTypeDelete, op.Type == gardencorev1beta1.LastOperationTypeMigrate:
+		return false
+	case op.Type == gardencorev1beta1.LastOperationTypeRestore && op.State != gardencorev1beta1.LastOperationStateSucceeded:
+		return false
+	}
+	return true
+}
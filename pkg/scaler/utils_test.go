@@ -8,6 +8,7 @@ import (
 	gardencorev1beta1 "github.com/gardener/gardener/pkg/apis/core/v1beta1"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var (
@@ -57,3 +58,91 @@ var _ = DescribeTable("doCheckShootHibernationStateChanged", func(oldSpec *garde
 	Entry("non-nil-enabled, false, nil-enabled, false", &gardencorev1beta1.Hibernation{Enabled: &yes}, false, &gardencorev1beta1.Hibernation{}, false, true),
 	Entry("non-nil-enabled, true, nil-enabled, true", &gardencorev1beta1.Hibernation{Enabled: &yes}, true, &gardencorev1beta1.Hibernation{}, true, true),
 )
+
+var _ = DescribeTable("shootNotReadyReason", func(mutate func(shoot *gardencorev1beta1.Shoot), expectReason string) {
+	shoot := &gardencorev1beta1.Shoot{
+		ObjectMeta: metav1.ObjectMeta{Generation: 2},
+		Status: gardencorev1beta1.ShootStatus{
+			ObservedGeneration: 2,
+			LastOperation: &gardencorev1beta1.LastOperation{
+				Type:  gardencorev1beta1.LastOperationTypeReconcile,
+				State: gardencorev1beta1.LastOperationStateSucceeded,
+			},
+		},
+	}
+	mutate(shoot)
+	Expect(shootNotReadyReason(shoot)).To(Equal(expectReason))
+},
+	Entry("ready", func(shoot *gardencorev1beta1.Shoot) {}, ""),
+	Entry("without last operation", func(shoot *gardencorev1beta1.Shoot) { shoot.Status.LastOperation = nil }, ""),
+	Entry("hibernated", func(shoot *gardencorev1beta1.Shoot) { shoot.Status.IsHibernated = true }, "the shoot is hibernated"),
+	Entry("hibernation enabled", func(shoot *gardencorev1beta1.Shoot) {
+		shoot.Spec.Hibernation = &gardencorev1beta1.Hibernation{Enabled: &yes}
+	}, "the shoot is hibernated"),
+	Entry("deletion timestamp", func(shoot *gardencorev1beta1.Shoot) {
+		now := metav1.Now()
+		shoot.DeletionTimestamp = &now
+	}, "the shoot is being deleted"),
+	Entry("deleting", func(shoot *gardencorev1beta1.Shoot) {
+		shoot.Status.LastOperation.Type = gardencorev1beta1.LastOperationTypeDelete
+		shoot.Status.LastOperation.State = gardencorev1beta1.LastOperationStateProcessing
+	}, "the shoot is being deleted"),
+	Entry("migrating", func(shoot *gardencorev1beta1.Shoot) {
+		shoot.Status.LastOperation.Type = gardencorev1beta1.LastOperationTypeMigrate
+		shoot.Status.LastOperation.State = gardencorev1beta1.LastOperationStateProcessing
+	}, "the control plane of the shoot is being migrated"),
+	Entry("migrated", func(shoot *gardencorev1beta1.Shoot) {
+		shoot.Status.LastOperation.Type = gardencorev1beta1.LastOperationTypeMigrate
+	}, "the control plane of the shoot is being migrated"),
+	Entry("restoring", func(shoot *gardencorev1beta1.Shoot) {
+		shoot.Status.LastOperation.Type = gardencorev1beta1.LastOperationTypeRestore
+		shoot.Status.LastOperation.State = gardencorev1beta1.LastOperationStateError
+	}, "the control plane of the shoot is being restored"),
+	Entry("restored", func(shoot *gardencorev1beta1.Shoot) {
+		shoot.Status.LastOperation.Type = gardencorev1beta1.LastOperationTypeRestore
+	}, ""),
+	Entry("failed", func(shoot *gardencorev1beta1.Shoot) {
+		shoot.Status.LastOperation.State = gardencorev1beta1.LastOperationStateFailed
+	}, "the last reconcile operation of the shoot failed"),
+	Entry("creating", func(shoot *gardencorev1beta1.Shoot) {
+		shoot.Status.LastOperation.Type = gardencorev1beta1.LastOperationTypeCreate
+		shoot.Status.LastOperation.State = gardencorev1beta1.LastOperationStateProcessing
+	}, "the shoot is being created"),
+	Entry("reconciling a changed spec", func(shoot *gardencorev1beta1.Shoot) {
+		shoot.Generation = 3
+		shoot.Status.LastOperation.State = gardencorev1beta1.LastOperationStateProcessing
+	}, "the shoot is being reconciled after a change of its spec"),
+	Entry("reconciling an unchanged spec", func(shoot *gardencorev1beta1.Shoot) {
+		shoot.Status.LastOperation.State = gardencorev1beta1.LastOperationStateProcessing
+	}, ""),
+)
+
+var _ = DescribeTable("shootKeepsControlPlane", func(mutate func(shoot *gardencorev1beta1.Shoot), expectKeep bool) {
+	shoot := &gardencorev1beta1.Shoot{
+		Status: gardencorev1beta1.ShootStatus{
+			LastOperation: &gardencorev1beta1.LastOperation{
+				Type:  gardencorev1beta1.LastOperationTypeReconcile,
+				State: gardencorev1beta1.LastOperationStateFailed,
+			},
+		},
+	}
+	mutate(shoot)
+	Expect(shootKeepsControlPlane(shoot)).To(Equal(expectKeep))
+},
+	Entry("failed", func(shoot *gardencorev1beta1.Shoot) {}, true),
+	Entry("without last operation", func(shoot *gardencorev1beta1.Shoot) { shoot.Status.LastOperation = nil }, true),
+	Entry("hibernated", func(shoot *gardencorev1beta1.Shoot) { shoot.Status.IsHibernated = true }, false),
+	Entry("deletion timestamp", func(shoot *gardencorev1beta1.Shoot) {
+		now := metav1.Now()
+		shoot.DeletionTimestamp = &now
+	}, false),
+	Entry("deleting", func(shoot *gardencorev1beta1.Shoot) {
+		shoot.Status.LastOperation.Type = gardencorev1beta1.LastOperationTypeDelete
+	}, false),
+	Entry("migrating", func(shoot *gardencorev1beta1.Shoot) {
+		shoot.Status.LastOperation.Type = gardencorev1beta1.LastOperationTypeMigrate
+	}, false),
+	Entry("restoring", func(shoot *gardencorev1beta1.Shoot) {
+		shoot.Status.LastOperation.Type = gardencorev1beta1.LastOperationTypeRestore
+	}, false),
+)
@@ -0,0 +1,280 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	"github.com/prometheus/client_golang/prometheus"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	autoscalingapi "k8s.io/api/autoscaling/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog"
+)
+
+// WebhookMode decides what the scale up webhook does with replica increases of targets held down by the
+// dependency-watchdog.
+type WebhookMode string
+
+const (
+	// WebhookModeReject rejects the replica increases.
+	WebhookModeReject WebhookMode = "reject"
+	// WebhookModeWarn admits the replica increases but logs a warning and adds an audit annotation.
+	WebhookModeWarn WebhookMode = "warn"
+
+	// bypassHoldDownAnnotationKey lets replica increases of targets held down by the dependency-watchdog pass
+	// the scale up webhook in emergencies.
+	bypassHoldDownAnnotationKey = "dependency-watchdog.gardener.cloud/bypass-hold-down"
+	heldDownAuditAnnotationKey  = "dependency-watchdog.gardener.cloud/held-down"
+
+	subResourceScale = "scale"
+)
+
+// holdDowns tracks the probes which currently hold their dependant scales down per namespace.
+type holdDowns struct {
+	mux    sync.RWMutex
+	probes map[string]map[string]*api.ProbeDependants
+}
+
+func newHoldDowns() *holdDowns {
+	return &holdDowns{
+		probes: make(map[string]map[string]*api.ProbeDependants),
+	}
+}
+
+// set records if the dependant scales of the given probe are held down in the namespace.
+func (h *holdDowns) set(namespace string, probeDeps *api.ProbeDependants, heldDown bool) {
+	if h == nil {
+		return
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if !heldDown {
+		delete(h.probes[namespace], probeDeps.Name)
+		if len(h.probes[namespace]) == 0 {
+			delete(h.probes, namespace)
+		}
+		return
+	}
+	if h.probes[namespace] == nil {
+		h.probes[namespace] = make(map[string]*api.ProbeDependants)
+	}
+	h.probes[namespace][probeDeps.Name] = probeDeps
+}
+
+// holds returns whether the dependant scales of the given probe are held down in the namespace.
+func (h *holdDowns) holds(namespace, probe string) bool {
+	if h == nil {
+		return false
+	}
+
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
+	_, ok := h.probes[namespace][probe]
+	return ok
+}
+
+// heldDownBy returns the name of the probe holding the dependant scales down which include the given target if there
+// is any. The target itself is only held down if it carries the mark of that probe, see scaleUpWebhook.admit.
+func (h *holdDowns) heldDownBy(namespace string, gk schema.GroupKind, name string) (string, bool) {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
+	for probe, probeDeps := range h.probes[namespace] {
+		for _, dsd := range probeDeps.DependantScales {
+			if dsd == nil || dsd.ScaleRef.Name != name {
+				continue
+			}
+			if dgk, err := targetGroupKind(dsd.ScaleRef); err == nil && dgk == gk {
+				return probe, true
+			}
+		}
+	}
+	return "", false
+}
+
+// scaleUpWebhook is a validating admission webhook for replica increases of targets which are held down by the
+// dependency-watchdog because the external probe of their probe is unhealthy and which it scaled down itself. It handles updates of the targets
+// themselves as well as of their scale sub-resources.
+type scaleUpWebhook struct {
+	mode      WebhookMode
+	mapper    apimeta.RESTMapper
+	targets   *targetCaches
+	holdDowns *holdDowns
+	paths     map[schema.GroupKind]fieldPaths
+}
+
+// NewScaleUpWebhook returns the handler of the validating admission webhook which rejects or warns, depending on the
+// mode, about replica increases of targets held down by the dependency-watchdog. Targets annotated with
+// dependency-watchdog.gardener.cloud/bypass-hold-down=true are always admitted.
+// It only knows about the targets held down by the probers of this controller and should therefore only be served
+// by the leader with a failure policy of Ignore.
+func (c *Controller) NewScaleUpWebhook(mode WebhookMode) (http.Handler, error) {
+	if mode != WebhookModeReject && mode != WebhookModeWarn {
+		return nil, fmt.Errorf("invalid webhook mode %q", mode)
+	}
+	paths, err := fieldPathsOf(c.probeDependantsList)
+	if err != nil {
+		return nil, err
+	}
+	return &scaleUpWebhook{
+		mode:      mode,
+		mapper:    c.mapper,
+		targets:   c.targets,
+		holdDowns: c.holdDowns,
+		paths:     paths,
+	}, nil
+}
+
+func (w *scaleUpWebhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	review := &admissionv1beta1.AdmissionReview{}
+	if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+		http.Error(rw, fmt.Sprintf("invalid admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review.Response = w.admit(review.Request)
+	review.Response.UID = review.Request.UID
+	review.Request = nil
+
+	data, err := json.Marshal(review)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if _, err := rw.Write(data); err != nil {
+		klog.Errorf("Failed to write the admission response: %s", err)
+	}
+}
+
+func (w *scaleUpWebhook) admit(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	allowed := &admissionv1beta1.AdmissionResponse{Allowed: true}
+	if req.Operation != admissionv1beta1.Update {
+		return allowed
+	}
+
+	gvk := schema.GroupVersionKind(req.Kind)
+	if req.SubResource == subResourceScale {
+		var err error
+		if gvk, err = w.mapper.KindFor(schema.GroupVersionResource(req.Resource)); err != nil {
+			klog.Errorf("Admitting the scale of %s/%s as its kind is unknown: %s", req.Namespace, req.Name, err)
+			return allowed
+		}
+	}
+	ref := autoscalingapi.CrossVersionObjectReference{APIVersion: gvk.GroupVersion().String(), Kind: gvk.Kind, Name: req.Name}
+	gk, err := targetGroupKind(ref)
+	if err != nil {
+		return allowed
+	}
+
+	probe, ok := w.holdDowns.heldDownBy(req.Namespace, gk, req.Name)
+	if !ok {
+		return allowed
+	}
+
+	path := defaultFieldPaths.replicas
+	if fp, ok := w.paths[gk]; ok && req.SubResource != subResourceScale {
+		path = fp.replicas
+	}
+	// The replicas of a Scale are omitted if they are 0, those of the workloads themselves default to 1.
+	var unsetReplicas int64 = 1
+	if req.SubResource == subResourceScale {
+		unsetReplicas = 0
+	}
+	oldObj, oldReplicas, err := decodeReplicas(req.OldObject.Raw, path, unsetReplicas)
+	if err != nil {
+		klog.Errorf("Admitting %s %s/%s as its old replicas are unknown: %s", gk, req.Namespace, req.Name, err)
+		return allowed
+	}
+	newObj, newReplicas, err := decodeReplicas(req.Object.Raw, path, unsetReplicas)
+	if err != nil {
+		klog.Errorf("Admitting %s %s/%s as its replicas are unknown: %s", gk, req.Namespace, req.Name, err)
+		return allowed
+	}
+	if newReplicas <= oldReplicas {
+		return allowed
+	}
+	if mark := scaledDownMarkOf(w.annotationsOf(req.Namespace, ref, oldObj, req.SubResource)); mark == nil || mark.Probe != probe {
+		// only the targets scaled down by the probe are held down
+		return allowed
+	}
+
+	if w.bypassed(req.Namespace, ref, newObj, req.SubResource) {
+		klog.Warningf("Admitting the scale up of %s %s/%s held down by %s as annotation %s is set", gk, req.Namespace, req.Name, probe, bypassHoldDownAnnotationKey)
+		return allowed
+	}
+
+	dwdHeldDownScaleUpsTotal.With(prometheus.Labels{labelMode: string(w.mode)}).Inc()
+	msg := fmt.Sprintf("%s %s/%s is held down by the dependency-watchdog as probe %s is externally unhealthy. Annotate it with %s=true to scale it up anyway",
+		gk, req.Namespace, req.Name, probe, bypassHoldDownAnnotationKey)
+	if w.mode == WebhookModeWarn {
+		klog.Warningf("Admitting the scale up by %s: %s", req.UserInfo.Username, msg)
+		allowed.AuditAnnotations = map[string]string{heldDownAuditAnnotationKey: msg}
+		return allowed
+	}
+
+	klog.Warningf("Rejecting the scale up by %s: %s", req.UserInfo.Username, msg)
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusForbidden,
+			Reason:  metav1.StatusReasonForbidden,
+			Message: msg,
+		},
+	}
+}
+
+// bypassed checks if the target is annotated to bypass the webhook.
+func (w *scaleUpWebhook) bypassed(namespace string, ref autoscalingapi.CrossVersionObjectReference, newObj *unstructured.Unstructured, subResource string) bool {
+	return w.annotationsOf(namespace, ref, newObj, subResource)[bypassHoldDownAnnotationKey] == "true"
+}
+
+// annotationsOf returns the annotations of the target of the admitted object. Scale sub-resources carry no
+// annotations of their target so that the target is looked up in the informer cache.
+func (w *scaleUpWebhook) annotationsOf(namespace string, ref autoscalingapi.CrossVersionObjectReference, obj *unstructured.Unstructured, subResource string) map[string]string {
+	if subResource == subResourceScale {
+		t, err := w.targets.get(namespace, ref)
+		if err != nil {
+			return nil
+		}
+		return t.annotations
+	}
+	return obj.GetAnnotations()
+}
+
+// decodeReplicas decodes the object and its replicas at the given path. unsetReplicas are returned if the
+// replicas are not set.
+func decodeReplicas(raw []byte, path []string, unsetReplicas int64) (*unstructured.Unstructured, int64, error) {
+	u := &unstructured.Unstructured{}
+	if err := u.UnmarshalJSON(raw); err != nil {
+		return nil, 0, err
+	}
+	replicas, found, err := unstructured.NestedInt64(u.Object, path...)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !found {
+		replicas = unsetReplicas
+	}
+	return u, replicas, nil
+}
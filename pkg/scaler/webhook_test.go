@@ -0,0 +1,192 @@
+// SPDX-FileCopyrightText: 2022 SAP SE or an SAP affiliate company and Gardener contributors.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package scaler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gardener/dependency-watchdog/pkg/scaler/api"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/pointer"
+)
+
+var _ = Describe("scaleUpWebhook", func() {
+	const ns = "test"
+
+	var (
+		kcmRef      = autoscalingv1.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: kindDeployment, Name: "kube-controller-manager"}
+		probeDeps   = &api.ProbeDependants{Name: "kube-apiserver", DependantScales: []*api.DependantScaleDetails{{ScaleRef: kcmRef}}}
+		deployments = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+		marked      = map[string]string{scaledDownByAnnotationKey: `{"probe":"kube-apiserver"}`}
+		holds       *holdDowns
+		webhook     *scaleUpWebhook
+	)
+
+	raw := func(obj runtime.Object) runtime.RawExtension {
+		data, err := json.Marshal(obj)
+		Expect(err).ToNot(HaveOccurred())
+		return runtime.RawExtension{Raw: data}
+	}
+
+	newDeploymentUpdate := func(name string, oldReplicas, newReplicas int32, annotations map[string]string) *admissionv1beta1.AdmissionRequest {
+		newDeploymentOf := func(replicas int32) *appsv1.Deployment {
+			return &appsv1.Deployment{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: kindDeployment},
+				ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name, Annotations: annotations},
+				Spec:       appsv1.DeploymentSpec{Replicas: pointer.Int32Ptr(replicas)},
+			}
+		}
+		return &admissionv1beta1.AdmissionRequest{
+			UID:       types.UID("uid"),
+			Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: kindDeployment},
+			Resource:  metav1.GroupVersionResource(deployments),
+			Namespace: ns,
+			Name:      name,
+			Operation: admissionv1beta1.Update,
+			Object:    raw(newDeploymentOf(newReplicas)),
+			OldObject: raw(newDeploymentOf(oldReplicas)),
+		}
+	}
+
+	newScaleUpdate := func(name string, oldReplicas, newReplicas int32) *admissionv1beta1.AdmissionRequest {
+		newScaleOf := func(replicas int32) *autoscalingv1.Scale {
+			return &autoscalingv1.Scale{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "autoscaling/v1", Kind: "Scale"},
+				ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+				Spec:       autoscalingv1.ScaleSpec{Replicas: replicas},
+			}
+		}
+		return &admissionv1beta1.AdmissionRequest{
+			UID:         types.UID("uid"),
+			Kind:        metav1.GroupVersionKind{Group: "autoscaling", Version: "v1", Kind: "Scale"},
+			Resource:    metav1.GroupVersionResource(deployments),
+			SubResource: subResourceScale,
+			Namespace:   ns,
+			Name:        name,
+			Operation:   admissionv1beta1.Update,
+			Object:      raw(newScaleOf(newReplicas)),
+			OldObject:   raw(newScaleOf(oldReplicas)),
+		}
+	}
+
+	BeforeEach(func() {
+		mapper := apimeta.NewDefaultRESTMapper(nil)
+		mapper.Add(deployments.GroupVersion().WithKind(kindDeployment), apimeta.RESTScopeNamespace)
+
+		factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+		targets := newTargetCaches()
+		targets.add(deploymentGroupKind, resourceDeployments, factory.Apps().V1().Deployments().Informer(), &deploymentCache{lister: factory.Apps().V1().Deployments().Lister()})
+		Expect(factory.Apps().V1().Deployments().Informer().GetIndexer().Add(&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: kcmRef.Name, Annotations: marked},
+		})).To(Succeed())
+
+		holds = newHoldDowns()
+		holds.set(ns, probeDeps, true)
+		webhook = &scaleUpWebhook{
+			mode:      WebhookModeReject,
+			mapper:    mapper,
+			targets:   targets,
+			holdDowns: holds,
+		}
+	})
+
+	It("should track the targets held down", func() {
+		gk := schema.GroupKind{Group: "apps", Kind: kindDeployment}
+		probe, ok := holds.heldDownBy(ns, gk, kcmRef.Name)
+		Expect(ok).To(BeTrue())
+		Expect(probe).To(Equal(probeDeps.Name))
+
+		_, ok = holds.heldDownBy("other", gk, kcmRef.Name)
+		Expect(ok).To(BeFalse())
+		_, ok = holds.heldDownBy(ns, gk, "other")
+		Expect(ok).To(BeFalse())
+		Expect(holds.holds(ns, probeDeps.Name)).To(BeTrue())
+		Expect(holds.holds(ns, "etcd")).To(BeFalse())
+
+		holds.set(ns, probeDeps, false)
+		_, ok = holds.heldDownBy(ns, gk, kcmRef.Name)
+		Expect(ok).To(BeFalse())
+		Expect(holds.holds(ns, probeDeps.Name)).To(BeFalse())
+	})
+
+	It("should reject scale ups of targets held down", func() {
+		resp := webhook.admit(newDeploymentUpdate(kcmRef.Name, 0, 1, marked))
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Code).To(Equal(int32(http.StatusForbidden)))
+
+		resp = webhook.admit(newScaleUpdate(kcmRef.Name, 0, 2))
+		Expect(resp.Allowed).To(BeFalse())
+
+		By("rejecting a scale up from 0 whose old replicas are omitted")
+		resp = webhook.admit(newScaleUpdate(kcmRef.Name, 0, 1))
+		Expect(resp.Allowed).To(BeFalse())
+	})
+
+	It("should admit other updates", func() {
+		Expect(webhook.admit(newDeploymentUpdate(kcmRef.Name, 1, 0, nil)).Allowed).To(BeTrue())
+		Expect(webhook.admit(newDeploymentUpdate(kcmRef.Name, 0, 0, nil)).Allowed).To(BeTrue())
+		Expect(webhook.admit(newDeploymentUpdate("other", 0, 1, nil)).Allowed).To(BeTrue())
+
+		holds.set(ns, probeDeps, false)
+		Expect(webhook.admit(newDeploymentUpdate(kcmRef.Name, 0, 1, marked)).Allowed).To(BeTrue())
+	})
+
+	It("should admit scale ups of targets not scaled down by the probe holding them down", func() {
+		Expect(webhook.admit(newDeploymentUpdate(kcmRef.Name, 0, 1, nil)).Allowed).To(BeTrue())
+		Expect(webhook.admit(newDeploymentUpdate(kcmRef.Name, 0, 1, map[string]string{scaledDownByAnnotationKey: `{"probe":"etcd"}`})).Allowed).To(BeTrue())
+
+		By("looking up the mark of the target of a scale in its informer cache")
+		webhook.targets = newTargetCaches()
+		Expect(webhook.admit(newScaleUpdate(kcmRef.Name, 0, 1)).Allowed).To(BeTrue())
+	})
+
+	It("should admit scale ups of targets annotated to bypass the webhook", func() {
+		resp := webhook.admit(newDeploymentUpdate(kcmRef.Name, 0, 1, map[string]string{
+			scaledDownByAnnotationKey:   marked[scaledDownByAnnotationKey],
+			bypassHoldDownAnnotationKey: "true",
+		}))
+		Expect(resp.Allowed).To(BeTrue())
+	})
+
+	It("should only warn about scale ups in warn mode", func() {
+		webhook.mode = WebhookModeWarn
+		resp := webhook.admit(newDeploymentUpdate(kcmRef.Name, 0, 1, marked))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(resp.AuditAnnotations).To(HaveKey(heldDownAuditAnnotationKey))
+	})
+
+	It("should answer admission reviews", func() {
+		data, err := json.Marshal(&admissionv1beta1.AdmissionReview{Request: newDeploymentUpdate(kcmRef.Name, 0, 1, marked)})
+		Expect(err).ToNot(HaveOccurred())
+
+		rec := httptest.NewRecorder()
+		webhook.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+		Expect(rec.Code).To(Equal(http.StatusOK))
+
+		review := &admissionv1beta1.AdmissionReview{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), review)).To(Succeed())
+		Expect(review.Response).ToNot(BeNil())
+		Expect(review.Response.UID).To(Equal(types.UID("uid")))
+		Expect(review.Response.Allowed).To(BeFalse())
+
+		rec = httptest.NewRecorder()
+		webhook.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte("{}"))))
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+	})
+})